@@ -0,0 +1,126 @@
+package main
+
+import (
+	"auto/internal/server"
+	"auto/internal/storage"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"os/user"
+	"strings"
+)
+
+// defaultCommand is run when no command name is provided
+const defaultCommand = "serve"
+
+// command defines application subcommand
+type command func(logger *zap.Logger, cfg config) error
+
+var commands = map[string]command{
+	"serve":        serve,
+	"reindex":      reindex,
+	"audit-verify": auditVerify,
+	"fsck":         fsck,
+}
+
+// cliActor returns name recorded in audit log for operations made with commands
+func cliActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	return "cli:" + name
+}
+
+// splitCommand separates command name from its flags.
+// Arguments starting with flags are treated as flags of default command
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return defaultCommand, args
+	}
+
+	return args[0], args[1:]
+}
+
+// serve runs HTTP server until it is stopped by signal
+func serve(logger *zap.Logger, cfg config) error {
+	store, err := storage.New(logger, cfg.storage.Path)
+	if err != nil {
+		return fmt.Errorf("storage.New: %w", err)
+	}
+
+	srv, err := server.New(logger, store, server.WithConfig(*cfg.http))
+	if err != nil {
+		_ = store.Close()
+		return fmt.Errorf("server.New: %w", err)
+	}
+
+	if err := srv.Start(); err != nil {
+		return fmt.Errorf("srv.Start: %w", err)
+	}
+
+	return nil
+}
+
+// reindex rebuilds secondary indexes from primary link records
+func reindex(logger *zap.Logger, cfg config) error {
+	store, err := storage.New(logger, cfg.storage.Path)
+	if err != nil {
+		return fmt.Errorf("storage.New: %w", err)
+	}
+
+	count, rebuildErr := store.RebuildIndex(0, cliActor())
+	if err := store.Close(); err != nil {
+		return fmt.Errorf("store.Close: %w", err)
+	}
+	if rebuildErr != nil {
+		return fmt.Errorf("store.RebuildIndex: %w", rebuildErr)
+	}
+
+	fmt.Printf("%d links indexed\n", count)
+
+	return nil
+}
+
+// auditVerify walks audit log hash chain and prints JSON report. Fails if the chain is broken
+func auditVerify(logger *zap.Logger, cfg config) error {
+	store, err := storage.New(logger, cfg.storage.Path)
+	if err != nil {
+		return fmt.Errorf("storage.New: %w", err)
+	}
+
+	report, verifyErr := store.VerifyAudit(0)
+	if err := store.Close(); err != nil {
+		return fmt.Errorf("store.Close: %w", err)
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("store.VerifyAudit: %w", verifyErr)
+	}
+
+	b, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(b))
+
+	if !report.Valid {
+		return fmt.Errorf("audit log is broken at entry %d: %s", report.BrokenSeq, report.Reason)
+	}
+
+	return nil
+}
+
+// fsck checks database consistency and prints JSON report. Fails if any problem is left unrepaired
+func fsck(logger *zap.Logger, cfg config) error {
+	report, err := storage.Check(logger, cfg.storage.Path, cfg.repair, cliActor())
+	if err != nil {
+		return fmt.Errorf("storage.Check: %w", err)
+	}
+
+	b, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(b))
+
+	if !report.Healthy() {
+		return fmt.Errorf("%d problems found", len(report.Findings))
+	}
+
+	return nil
+}
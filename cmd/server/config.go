@@ -6,13 +6,20 @@ import (
 	"github.com/caarlos0/env/v6"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
-	"os"
 )
 
 var helpErr = errors.New("help has been required")
 
 type config struct {
-	http *server.Config
+	http    *server.Config
+	storage *storageConfig
+	// repair enables fixing problems found by fsck command
+	repair bool
+}
+
+// storageConfig defines fields (with defaults) used for opening database and parsing them from environment variables
+type storageConfig struct {
+	Path string `env:"DB_PATH" envDefault:"/data/db"`
 }
 
 type options struct {
@@ -24,13 +31,38 @@ func (o options) installServerFlags(flags *pflag.FlagSet) {
 	o.logger.Debug("installing config flags")
 	flags.StringVar(&o.config.http.Host, "host", o.config.http.Host, "Application host")
 	flags.Uint16Var(&o.config.http.Port, "port", o.config.http.Port, "Application port")
+	flags.StringSliceVar(&o.config.http.Tokens, "api-tokens", o.config.http.Tokens, "API access tokens in \"name:role:secret\" form")
+	flags.StringVar(&o.config.http.PolicyFile, "policy-file", o.config.http.PolicyFile, "Allow and block rules file")
+	flags.DurationVar(&o.config.http.PolicyReloadInterval, "policy-reload-interval", o.config.http.PolicyReloadInterval, "Interval of checking policy file for changes")
+	flags.StringSliceVar(&o.config.http.PublicHosts, "public-hosts", o.config.http.PublicHosts, "Hosts short links are served on")
+	flags.StringSliceVar(&o.config.http.UnwrapHosts, "unwrap-hosts", o.config.http.UnwrapHosts, "Hosts of other shorteners whose links are replaced with their targets")
+	flags.DurationVar(&o.config.http.UnwrapTimeout, "unwrap-timeout", o.config.http.UnwrapTimeout, "Time limit of following redirects of other shorteners")
+	flags.IntVar(&o.config.http.RedirectStatus, "redirect-status", o.config.http.RedirectStatus, "HTTP status code of redirects for links without their own one: 301, 302, 307 or 308")
+	flags.Int64Var(&o.config.http.RedirectMaxAge, "redirect-max-age", o.config.http.RedirectMaxAge, "Seconds redirects may be cached for, 0 forbids caching, negative leaves cache headers out")
+	flags.StringVar(&o.config.http.PublicBaseURL, "public-base-url", o.config.http.PublicBaseURL, "Base URL of short links in responses, scheme and host of the request by default")
+	flags.StringSliceVar(&o.config.http.PublicBaseURLOverrides, "public-base-url-overrides", o.config.http.PublicBaseURLOverrides, "Base URLs for requests to particular hosts in \"host=URL\" form")
+	flags.IntVar(&o.config.http.PasswordAttempts, "password-attempts", o.config.http.PasswordAttempts, "Failed password attempts allowed per protected link within window")
+	flags.DurationVar(&o.config.http.PasswordAttemptsWindow, "password-attempts-window", o.config.http.PasswordAttemptsWindow, "Window failed password attempts are counted in")
+	flags.StringVar(&o.config.http.ComingSoonPage, "coming-soon-page", o.config.http.ComingSoonPage, "HTML template of page shown before link activation, built-in page by default")
+	flags.StringVar(&o.config.http.CountryHeader, "country-header", o.config.http.CountryHeader, "Request header trusted proxy sets client country code in, e.g. CF-IPCountry")
+}
+
+func (o options) installStorageFlags(flags *pflag.FlagSet) {
+	o.logger.Debug("installing storage flags")
+	flags.StringVar(&o.config.storage.Path, "db-path", o.config.storage.Path, "Database directory")
 }
 
-func newConfig(logger *zap.Logger) (config, error) {
+func (o options) installCommandFlags(flags *pflag.FlagSet) {
+	o.logger.Debug("installing command flags")
+	flags.BoolVar(&o.config.repair, "repair", false, "Repair problems found by fsck command")
+}
+
+func newConfig(logger *zap.Logger, args []string) (config, error) {
 	opts := options{
 		logger: logger,
 		config: &config{
-			http: &server.Config{},
+			http:    &server.Config{},
+			storage: &storageConfig{},
 		},
 	}
 
@@ -38,15 +70,21 @@ func newConfig(logger *zap.Logger) (config, error) {
 		logger.Error("parsing server environment config", zap.Error(err))
 	}
 
-	serverFlags := pflag.NewFlagSet("http_server", pflag.ContinueOnError)
-	opts.installServerFlags(serverFlags)
+	if err := env.Parse(opts.config.storage); err != nil {
+		logger.Error("parsing storage environment config", zap.Error(err))
+	}
+
+	flags := pflag.NewFlagSet("http_server", pflag.ContinueOnError)
+	opts.installServerFlags(flags)
+	opts.installStorageFlags(flags)
+	opts.installCommandFlags(flags)
 
-	if err := serverFlags.Parse(os.Args[1:]); err != nil {
-		if errors.As(err, &pflag.ErrHelp) {
-			//logger.Info("", zap.String("usage", serverFlags.FlagUsages()))
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			//logger.Info("", zap.String("usage", flags.FlagUsages()))
 			return config{}, helpErr
 		}
-		logger.Error("can not parse http server flags", zap.Error(err))
+		logger.Error("can not parse flags", zap.Error(err))
 		return config{}, err
 	}
 
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"auto/internal/server"
-	"auto/internal/storage"
 	"errors"
 	"go.uber.org/zap"
 	"log"
@@ -18,7 +16,13 @@ func main() {
 
 	logger.Info("Application is starting")
 
-	config, err := newConfig(logger)
+	name, args := splitCommand(os.Args[1:])
+	cmd, ok := commands[name]
+	if !ok {
+		logger.Fatal("unknown command", zap.String("command", name))
+	}
+
+	config, err := newConfig(logger, args)
 	if err != nil {
 		if errors.Is(err, helpErr) {
 			logger.Info("-help invoked, exiting")
@@ -27,18 +31,7 @@ func main() {
 		logger.Fatal("can not create config")
 	}
 
-	store, err := storage.New(logger, "/data/db")
-	if err != nil {
-		log.Fatalf("Error creating store: %v", err)
-	}
-
-	srv, err := server.New(logger, store, server.WithConfig(*config.http))
-	if err != nil {
-		logger.Fatal("server.New", zap.Error(err))
-	}
-
-	err = srv.Start()
-	if err != nil {
-		logger.Fatal("srv.Start", zap.Error(err))
+	if err := cmd(logger, config); err != nil {
+		logger.Fatal(name, zap.Error(err))
 	}
 }
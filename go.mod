@@ -7,10 +7,13 @@ require (
 	github.com/dgraph-io/badger/v2 v2.2007.1
 	github.com/pingcap/failpoint v0.0.0-20200702092429-9f69995143ce
 	github.com/rs/xid v1.2.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/speps/go-hashids v2.0.0+incompatible
 	github.com/spf13/pflag v1.0.3
 	github.com/stretchr/testify v1.6.1
 	github.com/valyala/fasthttp v1.16.0
 	github.com/valyala/fastjson v1.5.4
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20200602114024-627f9648deb9
 )
@@ -0,0 +1,137 @@
+// Package linkurl validates and normalizes target URLs of short links.
+// Normalized URL is stored as is and sent back in redirect Location header unchanged,
+// so normalization has to be idempotent
+package linkurl
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the maximum length of URL before and after normalization
+const MaxLength = 2048
+
+// validation errors, returned wrapped with details
+var (
+	ErrTooLong     = errors.New("URL is too long")
+	ErrSyntax      = errors.New("URL can not be parsed")
+	ErrScheme      = errors.New("URL scheme is not allowed")
+	ErrCredentials = errors.New("URL must not contain credentials")
+	ErrHost        = errors.New("URL host is invalid")
+	ErrPort        = errors.New("URL port is invalid")
+)
+
+// schemes holds allowed URL schemes with their default ports
+var schemes = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// hostProfile converts Unicode host names to punycode checking label syntax
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+	idna.Transitional(false),
+)
+
+// Normalize validates raw URL and returns its normalized form: lowercased scheme and host,
+// host converted to punycode and default port removed
+func Normalize(raw string) (string, error) {
+	if len(raw) > MaxLength {
+		return "", fmt.Errorf("%w: length %d exceeds %d", ErrTooLong, len(raw), MaxLength)
+	}
+
+	for _, r := range raw {
+		if r <= ' ' || r == 0x7f {
+			return "", fmt.Errorf("%w: whitespace and control characters are not allowed", ErrSyntax)
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSyntax, errors.Unwrap(err))
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	defaultPort, ok := schemes[u.Scheme]
+	if !ok {
+		if u.Scheme == "" {
+			return "", fmt.Errorf("%w: scheme is missing, only http and https are allowed", ErrScheme)
+		}
+		return "", fmt.Errorf("%w: %q, only http and https are allowed", ErrScheme, u.Scheme)
+	}
+
+	if u.Opaque != "" || !strings.HasPrefix(raw[len(u.Scheme)+1:], "//") {
+		return "", fmt.Errorf("%w: scheme must be followed by \"//\"", ErrSyntax)
+	}
+
+	if u.User != nil {
+		return "", ErrCredentials
+	}
+
+	host, err := NormalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port != "" {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return "", fmt.Errorf("%w: %q", ErrPort, port)
+		}
+		port = strconv.FormatUint(n, 10)
+	}
+
+	u.Host = host
+	if port != "" && port != defaultPort {
+		u.Host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+
+	normalized := u.String()
+	if len(normalized) > MaxLength {
+		return "", fmt.Errorf("%w: normalized length %d exceeds %d", ErrTooLong, len(normalized), MaxLength)
+	}
+
+	return normalized, nil
+}
+
+// NormalizeHost validates host name or IP address and returns it lowercased with domain names in punycode.
+// IPv6 addresses are returned in brackets
+func NormalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("%w: host is missing", ErrHost)
+	}
+
+	if !utf8.ValidString(host) {
+		return "", fmt.Errorf("%w: host is not valid UTF-8", ErrHost)
+	}
+
+	if strings.Contains(host, ":") {
+		ip := net.ParseIP(host)
+		if ip == nil || ip.To4() != nil {
+			return "", fmt.Errorf("%w: %q is not an IPv6 address", ErrHost, host)
+		}
+		return "[" + ip.String() + "]", nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii, err := hostProfile.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrHost, err)
+	}
+
+	return strings.ToLower(ascii), nil
+}
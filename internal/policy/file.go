@@ -0,0 +1,48 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseRules reads rules file. Every non-empty line not starting with "#" holds single rule:
+//
+//	<allow|block> <pattern> [retroactive]
+//
+// where pattern is domain ("example.com"), wildcard ("*.example.com") or regex ("/^https://[^/]+/login/").
+// Rules get IDs from their line numbers
+func ParseRules(r io.Reader) ([]Rule, error) {
+	rules := make([]Rule, 0)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		retroactive := len(fields) == 3 && fields[2] == "retroactive"
+		if len(fields) != 2 && !retroactive {
+			return nil, fmt.Errorf("line %d: rule must be in \"<allow|block> <pattern> [retroactive]\" form", line)
+		}
+
+		rule, err := NewRule(Action(fields[0]), fields[1], retroactive)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rule.ID = SourceFile + ":" + strconv.Itoa(line)
+		rule.Source = SourceFile
+
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,178 @@
+// Package policy decides which URLs may be shortened.
+// Rules come from local file reloaded on change and from runtime rules managed by admins.
+// Block rules reject matching URLs unless an allow rule matches them too
+package policy
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// Decision describes result of URL evaluation
+type Decision struct {
+	Allowed bool
+	// Rule is the rule decision is based on, zero if no rule matches
+	Rule Rule
+}
+
+// Engine evaluates URLs against file and runtime rules. It is safe for concurrent use
+type Engine struct {
+	logger *zap.Logger
+	path   string
+
+	mu      sync.RWMutex
+	file    []Rule
+	runtime []Rule
+	// modTime and size of the last loaded file tell whether it has to be read again
+	modTime time.Time
+	size    int64
+	onAdded func(rules []Rule)
+}
+
+// New constructs Engine reading rules from file at path. Empty path means runtime rules only.
+// Rules are not read until Reload is called
+func New(logger *zap.Logger, path string) (*Engine, error) {
+	if logger == nil {
+		return nil, errors.New("no logger provided")
+	}
+
+	return &Engine{logger: logger, path: path, file: make([]Rule, 0), runtime: make([]Rule, 0)}, nil
+}
+
+// OnAdded registers function called with file rules that appear after reload, including every rule of the first load
+func (e *Engine) OnAdded(fn func(rules []Rule)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.onAdded = fn
+}
+
+// Reload reads rules file if it has changed since the last load and reports whether rules have been replaced.
+// Previous rules are kept if file can not be read or parsed
+func (e *Engine) Reload() (bool, error) {
+	if e.path == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.RLock()
+	unchanged := info.ModTime().Equal(e.modTime) && info.Size() == e.size
+	e.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	f, err := os.Open(e.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	rules, err := ParseRules(f)
+	failpoint.Inject("parseRulesErr", func() {
+		err = errors.New("mock parse rules error")
+	})
+	if err != nil {
+		return false, err
+	}
+
+	e.mu.Lock()
+	known := make(map[string]bool, len(e.file))
+	for _, r := range e.file {
+		known[r.Key()] = true
+	}
+	added := make([]Rule, 0)
+	for _, r := range rules {
+		if !known[r.Key()] {
+			added = append(added, r)
+		}
+	}
+	e.file, e.modTime, e.size = rules, info.ModTime(), info.Size()
+	onAdded := e.onAdded
+	e.mu.Unlock()
+
+	e.logger.Info("policy rules loaded", zap.String("path", e.path), zap.Int("rules", len(rules)), zap.Int("added", len(added)))
+
+	if onAdded != nil && len(added) > 0 {
+		onAdded(added)
+	}
+
+	return true, nil
+}
+
+// Watch reloads rules file every interval until stop is closed
+func (e *Engine) Watch(interval time.Duration, stop <-chan struct{}) {
+	if e.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := e.Reload(); err != nil {
+				e.logger.Error("reloading policy rules", zap.String("path", e.path), zap.Error(err))
+			}
+		}
+	}
+}
+
+// SetRuntime replaces runtime rules. Rules with invalid patterns are skipped
+func (e *Engine) SetRuntime(rules []Rule) {
+	compiled := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		if err := r.compile(r.Pattern); err != nil {
+			e.logger.Error("skipping runtime policy rule", zap.String("id", r.ID), zap.Error(err))
+			continue
+		}
+		compiled = append(compiled, r)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.runtime = compiled
+}
+
+// Rules returns file rules followed by runtime rules
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(e.file)+len(e.runtime))
+	rules = append(rules, e.file...)
+
+	return append(rules, e.runtime...)
+}
+
+// Evaluate decides whether normalized URL may be redirected to
+func (e *Engine) Evaluate(rawURL string) Decision {
+	host := hostOf(rawURL)
+	rules := e.Rules()
+
+	for _, r := range rules {
+		if r.Action == ActionAllow && r.match(host, rawURL) {
+			return Decision{Allowed: true, Rule: r}
+		}
+	}
+
+	for _, r := range rules {
+		if r.Action == ActionBlock && r.match(host, rawURL) {
+			return Decision{Allowed: false, Rule: r}
+		}
+	}
+
+	return Decision{Allowed: true}
+}
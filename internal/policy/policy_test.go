@@ -0,0 +1,247 @@
+package policy
+
+import (
+	mytesting "auto/internal/testing"
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const packagePath = "auto/internal/policy/"
+
+func TestNewRule(t *testing.T) {
+	rule, err := NewRule(ActionBlock, "Bücher.Example.", false)
+	require.NoError(t, err)
+	require.Equal(t, KindDomain, rule.Kind)
+	require.Equal(t, "xn--bcher-kva.example", rule.Pattern)
+
+	rule, err = NewRule(ActionAllow, "*.EVIL.com", true)
+	require.NoError(t, err)
+	require.Equal(t, KindWildcard, rule.Kind)
+	require.Equal(t, "*.evil.com", rule.Pattern)
+
+	rule, err = NewRule(ActionBlock, "/login\\.php$/", false)
+	require.NoError(t, err)
+	require.Equal(t, KindRegex, rule.Kind)
+	require.Equal(t, "/login\\.php$/", rule.Pattern)
+
+	_, err = NewRule("deny", "evil.com", false)
+	require.True(t, errors.Is(err, ErrInvalidAction))
+
+	_, err = NewRule(ActionBlock, "/(/", false)
+	require.True(t, errors.Is(err, ErrInvalidPattern))
+
+	_, err = NewRule(ActionBlock, "evil_host.com", false)
+	require.True(t, errors.Is(err, ErrInvalidPattern))
+
+	_, err = NewRule(ActionBlock, "", false)
+	require.True(t, errors.Is(err, ErrInvalidPattern))
+}
+
+func TestRule_MatchURL(t *testing.T) {
+	domain, err := NewRule(ActionBlock, "evil.com", false)
+	require.NoError(t, err)
+	require.True(t, domain.MatchURL("https://evil.com/path"))
+	require.True(t, domain.MatchURL("http://evil.com:8080/"))
+	require.False(t, domain.MatchURL("https://www.evil.com/"))
+	require.False(t, domain.MatchURL("https://notevil.com/"))
+
+	wildcard, err := NewRule(ActionBlock, "*.evil.com", false)
+	require.NoError(t, err)
+	require.True(t, wildcard.MatchURL("https://www.evil.com/"))
+	require.True(t, wildcard.MatchURL("https://a.b.evil.com/"))
+	require.False(t, wildcard.MatchURL("https://evil.com/"))
+	require.False(t, wildcard.MatchURL("https://notevil.com/"))
+
+	regex, err := NewRule(ActionBlock, "/^https?://[^/]+/wp-login\\.php/", false)
+	require.NoError(t, err)
+	require.True(t, regex.MatchURL("https://example.com/wp-login.php?x=1"))
+	require.False(t, regex.MatchURL("https://example.com/blog/wp-login.php"))
+
+	ip, err := NewRule(ActionBlock, "2001:DB8::1", false)
+	require.NoError(t, err)
+	require.True(t, ip.MatchURL("http://[2001:db8::1]:8080/"))
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# phishing campaigns
+block *.evil.com retroactive
+allow good.evil.com
+
+block /\/login\.php$/
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	require.Equal(t, "file:3", rules[0].ID)
+	require.Equal(t, SourceFile, rules[0].Source)
+	require.True(t, rules[0].Retroactive)
+	require.Equal(t, ActionAllow, rules[1].Action)
+	require.Equal(t, KindRegex, rules[2].Kind)
+
+	_, err = ParseRules(strings.NewReader("block evil.com\nblock evil.com forever\n"))
+	require.Equal(t, errors.New("line 2: rule must be in \"<allow|block> <pattern> [retroactive]\" form"), err)
+
+	_, err = ParseRules(strings.NewReader("deny evil.com\n"))
+	require.True(t, errors.Is(err, ErrInvalidAction))
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	e, err := New(logger, "")
+	require.NoError(t, err)
+
+	require.Equal(t, Decision{Allowed: true}, e.Evaluate("https://evil.com/"))
+
+	rules, err := ParseRules(strings.NewReader("block *.evil.com\nallow good.evil.com\n"))
+	require.NoError(t, err)
+
+	// runtime rules decoded from storage are compiled by engine
+	e.SetRuntime(append(rules, Rule{ID: "re", Action: ActionBlock, Kind: KindRegex, Pattern: "/phish/"}, Rule{ID: "broken", Action: ActionBlock, Kind: KindRegex, Pattern: "/(/"}))
+	require.Len(t, e.Rules(), 3)
+
+	decision := e.Evaluate("https://www.evil.com/")
+	require.False(t, decision.Allowed)
+	require.Equal(t, "file:1", decision.Rule.ID)
+
+	decision = e.Evaluate("https://good.evil.com/")
+	require.True(t, decision.Allowed)
+	require.Equal(t, "file:2", decision.Rule.ID)
+
+	decision = e.Evaluate("https://example.com/phish")
+	require.False(t, decision.Allowed)
+	require.Equal(t, "re", decision.Rule.ID)
+
+	require.True(t, e.Evaluate("https://example.com/").Allowed)
+}
+
+func TestEngine_Reload(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(path, []byte("block evil.com\n"), 0644)
+	require.NoError(t, err)
+
+	e, err := New(logger, path)
+	require.NoError(t, err)
+
+	var added [][]Rule
+	e.OnAdded(func(rules []Rule) {
+		added = append(added, rules)
+	})
+
+	reloaded, err := e.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Len(t, added, 1)
+	require.False(t, e.Evaluate("https://evil.com/").Allowed)
+
+	reloaded, err = e.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	err = ioutil.WriteFile(path, []byte("# moved\nblock evil.com\nblock *.bad.org retroactive\n"), 0644)
+	require.NoError(t, err)
+	// make the change visible on file systems with coarse modification time
+	err = os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+
+	reloaded, err = e.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	// moved rule is not reported as added again
+	require.Len(t, added, 2)
+	require.Len(t, added[1], 1)
+	require.Equal(t, "*.bad.org", added[1][0].Pattern)
+	require.Equal(t, "file:2", e.Evaluate("https://evil.com/").Rule.ID)
+
+	err = ioutil.WriteFile(path, []byte("block evil_com\n"), 0644)
+	require.NoError(t, err)
+	err = os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	require.NoError(t, err)
+
+	// broken file keeps previous rules
+	_, err = e.Reload()
+	require.True(t, errors.Is(err, ErrInvalidPattern))
+	require.False(t, e.Evaluate("https://www.bad.org/").Allowed)
+}
+
+func TestEngine_ReloadErr(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	e, err := New(logger, filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+
+	_, err = e.Reload()
+	require.True(t, os.IsNotExist(err))
+
+	path := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(path, []byte("block evil.com\n"), 0644)
+	require.NoError(t, err)
+
+	e, err = New(logger, path)
+	require.NoError(t, err)
+
+	err = failpoint.Enable(packagePath+"parseRulesErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "parseRulesErr")
+		require.NoError(t, err)
+	}()
+
+	_, err = e.Reload()
+	require.Equal(t, errors.New("mock parse rules error"), err)
+	require.Empty(t, e.Rules())
+}
+
+func TestEngine_Watch(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(path, []byte(""), 0644)
+	require.NoError(t, err)
+
+	e, err := New(logger, path)
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		e.Watch(10*time.Millisecond, stop)
+		close(done)
+	}()
+
+	err = ioutil.WriteFile(path, []byte("block evil.com\n"), 0644)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !e.Evaluate("https://evil.com/").Allowed
+	}, 2*time.Second, 10*time.Millisecond)
+
+	close(stop)
+	<-done
+
+	_, err = New(nil, path)
+	require.Equal(t, errors.New("no logger provided"), err)
+}
@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"auto/internal/linkurl"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Action defines what happens to URLs matching rule
+type Action string
+
+const (
+	// ActionAllow exempts matching URLs from block rules
+	ActionAllow Action = "allow"
+	// ActionBlock rejects matching URLs
+	ActionBlock Action = "block"
+)
+
+// Kind defines how rule pattern is matched
+type Kind string
+
+const (
+	// KindDomain matches host equal to pattern
+	KindDomain Kind = "domain"
+	// KindWildcard matches subdomains of pattern written as "*.example.com", but not the domain itself
+	KindWildcard Kind = "wildcard"
+	// KindRegex matches the whole normalized URL against pattern written as "/expression/"
+	KindRegex Kind = "regex"
+)
+
+// rule sources
+const (
+	SourceFile = "file"
+	SourceAPI  = "api"
+)
+
+var (
+	ErrInvalidAction  = errors.New("rule action must be \"allow\" or \"block\"")
+	ErrInvalidPattern = errors.New("rule pattern is invalid")
+)
+
+// Rule defines single allow or block rule
+type Rule struct {
+	ID      string `json:"id"`
+	Action  Action `json:"action"`
+	Kind    Kind   `json:"kind"`
+	Pattern string `json:"pattern"`
+	// Retroactive block rules disable existing links they match when added
+	Retroactive bool      `json:"retroactive"`
+	Source      string    `json:"source"`
+	Creator     string    `json:"creator,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// NewRule parses pattern into rule. Domain patterns are normalized the way link hosts are
+func NewRule(action Action, pattern string, retroactive bool) (Rule, error) {
+	if action != ActionAllow && action != ActionBlock {
+		return Rule{}, fmt.Errorf("%w, got %q", ErrInvalidAction, action)
+	}
+
+	rule := Rule{Action: action, Retroactive: retroactive}
+	if err := rule.compile(pattern); err != nil {
+		return Rule{}, err
+	}
+
+	return rule, nil
+}
+
+// compile detects kind of pattern and prepares it for matching
+func (r *Rule) compile(pattern string) error {
+	switch {
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+		}
+		r.Kind, r.Pattern, r.re = KindRegex, pattern, re
+	case strings.HasPrefix(pattern, "*."):
+		host, err := linkurl.NormalizeHost(pattern[2:])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+		}
+		r.Kind, r.Pattern = KindWildcard, "*."+host
+	default:
+		host, err := linkurl.NormalizeHost(pattern)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+		}
+		r.Kind, r.Pattern = KindDomain, host
+	}
+
+	return nil
+}
+
+// Key identifies rule by its meaning regardless of ID and source
+func (r Rule) Key() string {
+	return fmt.Sprintf("%s %s %t", r.Action, r.Pattern, r.Retroactive)
+}
+
+// MatchURL reports whether rule matches normalized URL
+func (r Rule) MatchURL(rawURL string) bool {
+	return r.match(hostOf(rawURL), rawURL)
+}
+
+// hostOf returns host of normalized URL in the form domain patterns are normalized to
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	host := u.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return host
+}
+
+// match reports whether rule matches normalized URL with provided host
+func (r Rule) match(host, rawURL string) bool {
+	switch r.Kind {
+	case KindDomain:
+		return host == r.Pattern
+	case KindWildcard:
+		return strings.HasSuffix(host, r.Pattern[1:])
+	case KindRegex:
+		// rules decoded from JSON are not compiled until they are passed to Engine
+		return r.re != nil && r.re.MatchString(rawURL)
+	}
+
+	return false
+}
@@ -0,0 +1,204 @@
+// Package qr renders QR codes of short links as PNG and SVG images
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// Format defines image format of QR code
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+const (
+	// DefaultSize is width and height of QR code image in pixels
+	DefaultSize = 256
+	// MinSize and MaxSize limit requested image size
+	MinSize = 32
+	MaxSize = 2048
+	// DefaultMargin is a quiet zone around QR code in modules required by the standard
+	DefaultMargin = 4
+	// MaxMargin limits requested quiet zone
+	MaxMargin = 32
+)
+
+var ErrInvalidOptions = errors.New("invalid QR code options")
+
+// levels maps error correction level names to recovery levels
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options defines how QR code is rendered
+type Options struct {
+	Format Format
+	// Size is image width and height in pixels. Images are enlarged if QR code modules do not fit
+	Size int
+	// Level is error correction level: L, M, Q or H
+	Level string
+	// Margin is a quiet zone around QR code in modules
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// DefaultOptions returns black on white PNG options with medium error correction
+func DefaultOptions() Options {
+	return Options{
+		Format:     FormatPNG,
+		Size:       DefaultSize,
+		Level:      "M",
+		Margin:     DefaultMargin,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// Validate checks options are in supported ranges
+func (o Options) Validate() error {
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("%w: format must be png or svg, got %q", ErrInvalidOptions, o.Format)
+	}
+
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("%w: size must be between %d and %d pixels, got %d", ErrInvalidOptions, MinSize, MaxSize, o.Size)
+	}
+
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("%w: error correction level must be L, M, Q or H, got %q", ErrInvalidOptions, o.Level)
+	}
+
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("%w: margin must be between 0 and %d modules, got %d", ErrInvalidOptions, MaxMargin, o.Margin)
+	}
+
+	if o.Foreground == o.Background {
+		return fmt.Errorf("%w: foreground and background colors must differ", ErrInvalidOptions)
+	}
+
+	return nil
+}
+
+// ParseColor parses hex color in "RGB" or "RRGGBB" form, leading "#" is optional
+func ParseColor(s string) (color.RGBA, error) {
+	digits := strings.TrimPrefix(s, "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if len(digits) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("%w: color must be hex RGB or RRGGBB, got %q", ErrInvalidOptions, s)
+	}
+
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// Encode renders QR code of content as image of options format
+func Encode(content string, o Options) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	q, err := qrcode.New(content, levels[o.Level])
+	if err != nil {
+		return nil, err
+	}
+	q.DisableBorder = true
+
+	modules := q.Bitmap()
+
+	if o.Format == FormatSVG {
+		return encodeSVG(modules, o), nil
+	}
+
+	return encodePNG(modules, o)
+}
+
+// layout returns image size, pixels per module and offset of the first module keeping QR code centered
+func layout(modules [][]bool, o Options) (size, scale, offset int) {
+	total := len(modules) + 2*o.Margin
+
+	size = o.Size
+	if size < total {
+		size = total
+	}
+	scale = size / total
+	offset = (size-total*scale)/2 + o.Margin*scale
+
+	return size, scale, offset
+}
+
+// encodePNG renders modules as two color PNG image
+func encodePNG(modules [][]bool, o Options) ([]byte, error) {
+	size, scale, offset := layout(modules, o)
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{o.Background, o.Foreground})
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeSVG renders modules as SVG image drawing runs of dark modules in a single path
+func encodeSVG(modules [][]bool, o Options) []byte {
+	total := len(modules) + 2*o.Margin
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		o.Size, o.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/><path fill="%s" d="`, hex(o.Background), hex(o.Foreground))
+
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+o.Margin, y+o.Margin, run, run)
+			x += run
+		}
+	}
+
+	buf.WriteString(`"/></svg>`)
+
+	return buf.Bytes()
+}
+
+// hex formats color as "#rrggbb"
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,115 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#1a2B3c")
+	require.NoError(t, err)
+	require.Equal(t, color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 0xff}, c)
+
+	c, err = ParseColor("f00")
+	require.NoError(t, err)
+	require.Equal(t, color.RGBA{R: 0xff, A: 0xff}, c)
+
+	for _, s := range []string{"", "red", "12345", "+12345", "1234567", "ggg"} {
+		_, err = ParseColor(s)
+		require.True(t, errors.Is(err, ErrInvalidOptions), s)
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	require.NoError(t, DefaultOptions().Validate())
+
+	for _, change := range []func(o *Options){
+		func(o *Options) { o.Format = "gif" },
+		func(o *Options) { o.Size = MinSize - 1 },
+		func(o *Options) { o.Size = MaxSize + 1 },
+		func(o *Options) { o.Level = "X" },
+		func(o *Options) { o.Margin = -1 },
+		func(o *Options) { o.Margin = MaxMargin + 1 },
+		func(o *Options) { o.Foreground = o.Background },
+	} {
+		o := DefaultOptions()
+		change(&o)
+		require.True(t, errors.Is(o.Validate(), ErrInvalidOptions), o)
+	}
+}
+
+func TestEncode_PNG(t *testing.T) {
+	o := DefaultOptions()
+	o.Size = 300
+	o.Foreground = color.RGBA{B: 0x80, A: 0xff}
+
+	b, err := Encode("https://sho.rt/jnegYbw", o)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, 300, img.Bounds().Dx())
+	require.Equal(t, 300, img.Bounds().Dy())
+
+	// version 2 code with default margin is 33 modules wide, 9 pixels each, centered
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	require.Equal(t, white, color.RGBAModel.Convert(img.At(0, 0)))
+	require.Equal(t, white, color.RGBAModel.Convert(img.At(1+4*9-1, 1+4*9-1)))
+	// top left finder pattern starts right after margin
+	require.Equal(t, o.Foreground, color.RGBAModel.Convert(img.At(1+4*9, 1+4*9)))
+}
+
+func TestEncode_Margin(t *testing.T) {
+	o := DefaultOptions()
+	o.Size = MinSize
+
+	b, err := Encode("https://sho.rt/jnegYbw", o)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	// image is enlarged to fit every module of 25 modules wide code with margin
+	require.Equal(t, 33, img.Bounds().Dx())
+	require.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, color.RGBAModel.Convert(img.At(3, 3)))
+	require.Equal(t, color.RGBA{A: 0xff}, color.RGBAModel.Convert(img.At(4, 4)))
+
+	o.Format = FormatSVG
+	o.Margin = 0
+
+	b, err = Encode("https://sho.rt/jnegYbw", o)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `viewBox="0 0 25 25"`)
+	require.Contains(t, string(b), `d="M0 0h7v1h-7z`)
+}
+
+func TestEncode_SVG(t *testing.T) {
+	o := DefaultOptions()
+	o.Format = FormatSVG
+	o.Background = color.RGBA{R: 0xff, G: 0xee, B: 0xdd, A: 0xff}
+
+	b, err := Encode("https://sho.rt/jnegYbw", o)
+	require.NoError(t, err)
+
+	svg := string(b)
+	require.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 33 33"`))
+	require.Contains(t, svg, `fill="#ffeedd"`)
+	require.Contains(t, svg, `<path fill="#000000" d="M4 4h7v1h-7z`)
+	require.True(t, strings.HasSuffix(svg, `"/></svg>`))
+}
+
+func TestEncode_TooLong(t *testing.T) {
+	o := DefaultOptions()
+	o.Level = "H"
+
+	_, err := Encode(strings.Repeat("x", 4000), o)
+	require.Error(t, err)
+
+	o.Format = "gif"
+	_, err = Encode("https://sho.rt/jnegYbw", o)
+	require.True(t, errors.Is(err, ErrInvalidOptions))
+}
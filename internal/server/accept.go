@@ -0,0 +1,88 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mediaRange defines single Accept header entry
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// parseAccept parses Accept header value into media ranges. Malformed entries are skipped
+func parseAccept(accept string) []mediaRange {
+	ranges := make([]mediaRange, 0)
+	for _, entry := range strings.Split(accept, ",") {
+		params := strings.Split(entry, ";")
+
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		slash := strings.IndexByte(mediaType, '/')
+		if slash <= 0 || slash == len(mediaType)-1 {
+			continue
+		}
+
+		r := mediaRange{typ: mediaType[:slash], subtype: mediaType[slash+1:], q: 1}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil && q >= 0 && q <= 1 {
+					r.q = q
+				}
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
+
+// quality returns weight given to media type by the most specific matching range, -1 if no range matches
+func quality(ranges []mediaRange, mediaType string) float64 {
+	slash := strings.IndexByte(mediaType, '/')
+	typ, subtype := mediaType[:slash], mediaType[slash+1:]
+
+	q, specificity := -1.0, -1
+	for _, r := range ranges {
+		var s int
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+
+	return q
+}
+
+// negotiate returns the offered media type preferred by Accept header value.
+// Offers are listed in server preference order which breaks ties.
+// The first offer is returned for empty header and empty string is returned if nothing is acceptable
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	ranges := parseAccept(accept)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{contentTypeProblem, contentTypeJSON, "text/html"}
+
+	require.Equal(t, contentTypeProblem, negotiate("", offers...))
+	require.Equal(t, contentTypeProblem, negotiate("*/*", offers...))
+	require.Equal(t, contentTypeJSON, negotiate("application/json", offers...))
+	require.Equal(t, "text/html", negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers...))
+	require.Equal(t, contentTypeProblem, negotiate("text/html;q=0.5, application/*", offers...))
+	require.Equal(t, contentTypeJSON, negotiate("application/json, application/problem+json;q=0", offers...))
+	require.Equal(t, "", negotiate("image/png", offers...))
+	require.Equal(t, contentTypeProblem, negotiate("bogus, */*", offers...))
+}
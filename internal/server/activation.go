@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"github.com/valyala/fasthttp"
+	"html/template"
+	"io/ioutil"
+	"time"
+)
+
+// defaultComingSoonPage is HTML template of page shown before link activation unless custom one is configured
+const defaultComingSoonPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Coming soon</title>
+</head>
+<body>
+<h1>Coming soon</h1>
+<p>Link {{.Short}} is not active yet, please come back later.</p>
+</body>
+</html>
+`
+
+// comingSoonData defines fields "coming soon" page template gets
+type comingSoonData struct {
+	Short     string
+	NotBefore time.Time
+}
+
+// parseComingSoonPage parses "coming soon" page template from file or built-in one if path is empty.
+// Template is executed once with sample data, so broken custom pages fail at start rather than on requests
+func parseComingSoonPage(path string) (*template.Template, error) {
+	var (
+		t   *template.Template
+		err error
+	)
+	if path == "" {
+		t, err = template.New("coming soon").Parse(defaultComingSoonPage)
+	} else {
+		t, err = template.ParseFiles(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.Execute(ioutil.Discard, comingSoonData{Short: "jnegYbw", NotBefore: time.Now()}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// writeNotActive responds on link whose activation time has not come yet with redirect to its fallback URL,
+// "coming soon" page for clients preferring HTML (e.g. browsers) or error response for others.
+// Responses are never cached, so link starts redirecting right at activation time
+func (h *handler) writeNotActive(ctx *fasthttp.RequestCtx, short string) {
+	link, err := h.Storage.LookupLink(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	setCacheHeaders(ctx, h.Storage.Now(), 0)
+
+	if link.Fallback != "" {
+		status := fasthttp.StatusFound
+		// browsers follow redirects of posted password forms with GET requests
+		if ctx.IsPost() {
+			status = fasthttp.StatusSeeOther
+		}
+		ctx.Response.Header.Set(fasthttp.HeaderLocation, link.Fallback)
+		ctx.SetStatusCode(status)
+		return
+	}
+
+	accept := string(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+	if negotiate(accept, contentTypeProblem, contentTypeJSON, "text/html") != "text/html" {
+		writeError(ctx, errCodeNotActive, "")
+		return
+	}
+
+	data := comingSoonData{Short: short}
+	if link.NotBefore != nil {
+		data.NotBefore = *link.NotBefore
+	}
+
+	// rendering can not fail as template is checked at start and buffer writes never fail
+	var body bytes.Buffer
+	_ = h.comingSoon.Execute(&body, data)
+
+	ctx.SetStatusCode(errCodeNotActive.status)
+	ctx.SetContentType(contentTypeHTML)
+	ctx.SetBody(body.Bytes())
+}
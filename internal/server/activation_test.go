@@ -0,0 +1,227 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseComingSoonPage(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	page, err := parseComingSoonPage("")
+	require.NoError(t, err)
+
+	var body strings.Builder
+	err = page.Execute(&body, comingSoonData{Short: "jnegYbw"})
+	require.NoError(t, err)
+	require.Contains(t, body.String(), "Link jnegYbw is not active yet")
+
+	custom := filepath.Join(dir, "soon.html")
+	err = ioutil.WriteFile(custom, []byte(`<p>{{.Short}} launches at {{.NotBefore.Format "15:04"}}</p>`), 0600)
+	require.NoError(t, err)
+
+	page, err = parseComingSoonPage(custom)
+	require.NoError(t, err)
+
+	body.Reset()
+	err = page.Execute(&body, comingSoonData{Short: "jnegYbw", NotBefore: time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	require.Equal(t, "<p>jnegYbw launches at 12:00</p>", body.String())
+
+	_, err = parseComingSoonPage(filepath.Join(dir, "missing.html"))
+	require.Error(t, err)
+
+	// pages using fields the template does not get fail at start
+	broken := filepath.Join(dir, "broken.html")
+	err = ioutil.WriteFile(broken, []byte(`<p>{{.URL}}</p>`), 0600)
+	require.NoError(t, err)
+
+	_, err = parseComingSoonPage(broken)
+	require.Error(t, err)
+}
+
+func TestGetUrl_NotActive(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.SetClock(func() time.Time {
+		return now
+	})
+
+	launch := now.Add(time.Hour)
+	soon, err := store.SaveLink(0, storage.Link{URL: "https://example.com/launch", NotBefore: &launch})
+	require.NoError(t, err)
+	fallback, err := store.SaveLink(0, storage.Link{URL: "https://example.com/launch", NotBefore: &launch, Fallback: "https://example.com/teaser"})
+	require.NoError(t, err)
+
+	comingSoon, err := parseComingSoonPage("")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		comingSoon: comingSoon,
+	}
+
+	for _, tc := range []struct {
+		short    string
+		accept   string
+		status   int
+		code     string
+		location string
+	}{
+		{soon, contentTypeJSON, fasthttp.StatusNotFound, "code_not_active", ""},
+		{soon, "text/html", fasthttp.StatusNotFound, "", ""},
+		{fallback, contentTypeJSON, fasthttp.StatusFound, "", "https://example.com/teaser"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.Header.Set(fasthttp.HeaderAccept, tc.accept)
+		req.SetRequestURI("/" + tc.short)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		name := tc.short + " " + tc.accept
+		require.Equal(t, tc.status, res.StatusCode(), name)
+		require.Equal(t, tc.location, string(res.Header.Peek(fasthttp.HeaderLocation)), name)
+		// responses before activation are never cached, so link redirects right at activation time
+		require.Equal(t, "no-store, max-age=0", string(res.Header.Peek(fasthttp.HeaderCacheControl)), name)
+		if tc.code != "" {
+			require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"), name)
+		}
+		if tc.accept == "text/html" {
+			require.Equal(t, contentTypeHTML, string(res.Header.ContentType()))
+			require.Contains(t, string(res.Body()), "Coming soon", name)
+		}
+	}
+
+	// targets are not previewed before activation
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set(fasthttp.HeaderAccept, contentTypeJSON)
+	req.SetRequestURI("/preview/" + soon)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.True(t, fastjson.GetBool(res.Body(), "scheduled"))
+	require.False(t, fastjson.Exists(res.Body(), "url"))
+
+	now = launch
+	for _, short := range []string{soon, fallback} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.SetRequestURI("/" + short)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusMovedPermanently, res.StatusCode(), short)
+		require.Equal(t, "https://example.com/launch", string(res.Header.Peek(fasthttp.HeaderLocation)), short)
+	}
+}
+
+func TestSaveUrl_Activation(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{contentTypeJSON, `{"url":"https://example.com","not_before":"2100-01-01T00:00:00Z","not_after":"2100-02-01T00:00:00Z","fallback_url":"https://example.com/teaser"}`, fasthttp.StatusOK, ""},
+		{contentTypeForm, "url=https%3A%2F%2Fexample.com&not_before=2100-01-01T00%3A00%3A00Z&fallback_url=https%3A%2F%2Fexample.com%2Fteaser", fasthttp.StatusOK, ""},
+		{contentTypeJSON, `{"url":"https://example.com","not_before":"tomorrow"}`, fasthttp.StatusBadRequest, "activation_invalid"},
+		{contentTypeJSON, `{"url":"https://example.com","fallback_url":"https://example.com/teaser"}`, fasthttp.StatusBadRequest, "activation_invalid"},
+		{contentTypeJSON, `{"url":"https://example.com","not_before":"2100-02-01T00:00:00Z","not_after":"2100-01-01T00:00:00Z"}`, fasthttp.StatusBadRequest, "activation_invalid"},
+		{contentTypeJSON, `{"url":"https://example.com","not_before":"2100-01-01T00:00:00Z","fallback_url":"teaser"}`, fasthttp.StatusBadRequest, "url_invalid"},
+		{contentTypeJSON, `{"url":"https://example.com","expires_at":"2100-01-01T00:00:00Z","not_after":"2100-01-01T00:00:00Z"}`, fasthttp.StatusBadRequest, "expiry_invalid"},
+		{contentTypeForm, "url=https%3A%2F%2Fexample.com&not_after=2001-01-01T00%3A00%3A00Z", fasthttp.StatusBadRequest, "expiry_invalid"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType(tc.contentType)
+		req.SetRequestURI("/api/shorten")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.saveURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.body)
+		if tc.code != "" {
+			require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"), tc.body)
+			continue
+		}
+
+		require.Equal(t, "2100-01-01T00:00:00Z", fastjson.GetString(res.Body(), "not_before"))
+		require.Equal(t, "https://example.com/teaser", fastjson.GetString(res.Body(), "fallback_url"))
+	}
+
+	// "not_after" is another name of "expires_at"
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.SetContentType(contentTypeJSON)
+	req.SetRequestURI("/api/shorten")
+	req.SetBody([]byte(`{"url":"https://example.com","not_after":"2100-02-01T00:00:00Z"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "2100-02-01T00:00:00Z", fastjson.GetString(res.Body(), "expires_at"))
+}
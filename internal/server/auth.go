@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"strings"
+)
+
+// role defines access level of API token holder
+type role int
+
+// roles are ordered by access level so a role includes permissions of every lower one
+const (
+	roleEditor role = iota + 1
+	roleAdmin
+)
+
+// roles maps role names used in API tokens configuration to roles
+var roles = map[string]role{
+	"editor": roleEditor,
+	"admin":  roleAdmin,
+}
+
+// principal defines API token holder
+type principal struct {
+	name   string
+	role   role
+	secret []byte
+}
+
+// parseTokens parses API tokens defined in "name:role:secret" form
+func parseTokens(tokens []string) ([]principal, error) {
+	principals := make([]principal, 0, len(tokens))
+	for i, token := range tokens {
+		parts := strings.SplitN(token, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("API token #%d must be in \"name:role:secret\" form", i+1)
+		}
+
+		r, ok := roles[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("API token #%d has unknown role %q", i+1, parts[1])
+		}
+
+		principals = append(principals, principal{name: parts[0], role: r, secret: []byte(parts[2])})
+	}
+
+	return principals, nil
+}
+
+// authenticate returns token holder referenced by "Authorization: Bearer <secret>" request header.
+// Token holder found by authentication middleware is reused
+func (h *handler) authenticate(ctx *fasthttp.RequestCtx) (principal, bool) {
+	const prefix = "Bearer "
+
+	if p, ok := ctx.UserValue(principalKey).(principal); ok {
+		return p, true
+	}
+
+	header := ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)
+	if !bytes.HasPrefix(header, []byte(prefix)) {
+		return principal{}, false
+	}
+	secret := header[len(prefix):]
+
+	// every secret is compared to keep response time independent of the matching token position
+	found := -1
+	for i, p := range h.principals {
+		if subtle.ConstantTimeCompare(p.secret, secret) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return principal{}, false
+	}
+
+	return h.principals[found], true
+}
+
+// authorize checks that request is made by token holder with required role.
+// Writes corresponding error response and returns false otherwise
+func (h *handler) authorize(ctx *fasthttp.RequestCtx, required role) (principal, bool) {
+	p, ok := h.authenticate(ctx)
+	if !ok {
+		writeUnauthorized(ctx)
+		return principal{}, false
+	}
+
+	if p.role < required {
+		writeError(ctx, errForbidden, "Token role does not allow this operation")
+		return principal{}, false
+	}
+
+	return p, true
+}
+
+// writeUnauthorized writes error response asking for valid API token
+func writeUnauthorized(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, "Bearer")
+	writeError(ctx, errUnauthorized, "Valid \"Authorization: Bearer <token>\" header is required")
+}
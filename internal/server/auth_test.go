@@ -0,0 +1,19 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+	principals, err := parseTokens([]string{"root:admin:s3:cr3t"})
+	require.NoError(t, err)
+	require.Equal(t, []principal{{name: "root", role: roleAdmin, secret: []byte("s3:cr3t")}}, principals)
+
+	_, err = parseTokens([]string{"root:admin:"})
+	require.Equal(t, errors.New("API token #1 must be in \"name:role:secret\" form"), err)
+
+	_, err = parseTokens([]string{"root:admin:secret", "guest:nobody:secret"})
+	require.Equal(t, errors.New("API token #2 has unknown role \"nobody\""), err)
+}
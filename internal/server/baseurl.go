@@ -0,0 +1,108 @@
+package server
+
+import (
+	"auto/internal/linkurl"
+	"errors"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// baseURLs holds public base URLs absolute short link URLs are built on
+type baseURLs struct {
+	// fallback is used for requests to hosts without own base URL, empty to build URLs from requests
+	fallback string
+	// overrides maps request hosts to their base URLs
+	overrides map[string]string
+}
+
+// parseBaseURL validates public base URL and strips its trailing slash
+func parseBaseURL(raw string) (string, error) {
+	normalized, err := linkurl.Normalize(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return "", err
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("base URL must not have query or fragment")
+	}
+
+	return strings.TrimSuffix(normalized, "/"), nil
+}
+
+// parseBaseURLs parses default public base URL and overrides for request hosts in "host=URL" form
+func parseBaseURLs(fallback string, overrides []string) (baseURLs, error) {
+	b := baseURLs{overrides: make(map[string]string, len(overrides))}
+
+	if fallback != "" {
+		base, err := parseBaseURL(fallback)
+		if err != nil {
+			return baseURLs{}, fmt.Errorf("public base URL: %w", err)
+		}
+		b.fallback = base
+	}
+
+	for i, override := range overrides {
+		parts := strings.SplitN(override, "=", 2)
+		host := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || host == "" {
+			return baseURLs{}, fmt.Errorf("public base URL override #%d must be in \"host=URL\" form", i+1)
+		}
+
+		base, err := parseBaseURL(parts[1])
+		if err != nil {
+			return baseURLs{}, fmt.Errorf("public base URL override #%d: %w", i+1, err)
+		}
+		b.overrides[host] = base
+	}
+
+	return b, nil
+}
+
+// publicHosts returns hosts of configured base URLs
+func (b baseURLs) publicHosts() []string {
+	bases := []string{b.fallback}
+	for _, base := range b.overrides {
+		bases = append(bases, base)
+	}
+
+	hosts := make([]string, 0, len(bases))
+	for _, base := range bases {
+		if u, err := url.Parse(base); err == nil && u.Host != "" {
+			hosts = append(hosts, u.Host)
+		}
+	}
+
+	return hosts
+}
+
+// shortURL returns absolute URL of short link. Base URL configured for request host wins over the default one,
+// URL is built from request scheme and host if neither is configured
+func (h *handler) shortURL(ctx *fasthttp.RequestCtx, short string) string {
+	host := strings.ToLower(string(ctx.Host()))
+	if base, ok := h.baseURLs.overrides[host]; ok {
+		return base + "/" + short
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if base, ok := h.baseURLs.overrides[hostname]; ok {
+			return base + "/" + short
+		}
+	}
+
+	if h.baseURLs.fallback != "" {
+		return h.baseURLs.fallback + "/" + short
+	}
+
+	scheme := "http"
+	if ctx.IsTLS() {
+		scheme = "https"
+	}
+
+	return scheme + "://" + string(ctx.Host()) + "/" + short
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"sort"
+	"testing"
+)
+
+func TestParseBaseURLs(t *testing.T) {
+	b, err := parseBaseURLs("HTTPS://Sho.rt/", []string{"go.example.com=https://go.example.com/s/", " Localhost:9000 =http://localhost:9000"})
+	require.NoError(t, err)
+	require.Equal(t, "https://sho.rt", b.fallback)
+	require.Equal(t, map[string]string{
+		"go.example.com": "https://go.example.com/s",
+		"localhost:9000": "http://localhost:9000",
+	}, b.overrides)
+
+	hosts := b.publicHosts()
+	sort.Strings(hosts)
+	require.Equal(t, []string{"go.example.com", "localhost:9000", "sho.rt"}, hosts)
+
+	b, err = parseBaseURLs("", nil)
+	require.NoError(t, err)
+	require.Empty(t, b.fallback)
+	require.Empty(t, b.publicHosts())
+
+	for _, tc := range []struct {
+		base      string
+		overrides []string
+		err       string
+	}{
+		{"ftp://sho.rt", nil, "public base URL: "},
+		{"https://sho.rt/?a=b", nil, "public base URL: base URL must not have query or fragment"},
+		{"", []string{"sho.rt"}, "public base URL override #1 must be in \"host=URL\" form"},
+		{"", []string{"=https://sho.rt"}, "public base URL override #1 must be in \"host=URL\" form"},
+		{"", []string{"a=https://a.com", "b=sho rt"}, "public base URL override #2: "},
+	} {
+		_, err = parseBaseURLs(tc.base, tc.overrides)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tc.err)
+	}
+}
+
+func TestSaveUrl_ShortURL(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	base, err := parseBaseURLs("https://sho.rt", []string{"go.example.com=https://go.example.com/s"})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		base     baseURLs
+		host     string
+		shortURL string
+	}{
+		{baseURLs{}, "localhost:9000", "http://localhost:9000/"},
+		{base, "localhost:9000", "https://sho.rt/"},
+		{base, "GO.example.com", "https://go.example.com/s/"},
+		{base, "go.example.com:8080", "https://go.example.com/s/"},
+	} {
+		h := &handler{
+			logger:   logger,
+			Storage:  store,
+			baseURLs: tc.base,
+		}
+
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost(tc.host)
+		req.Header.SetContentType("application/json")
+		req.SetRequestURI("/api/shorten")
+		req.SetBody([]byte(`{"url":"https://example.com/target"}`))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.saveURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+		require.Equal(t, contentTypeJSON, string(res.Header.ContentType()))
+
+		short := fastjson.GetString(res.Body(), "short")
+		require.Len(t, short, 7)
+		require.Equal(t, tc.shortURL+short, fastjson.GetString(res.Body(), "short_url"), tc.host)
+		require.Equal(t, "https://example.com/target", fastjson.GetString(res.Body(), "url"))
+		require.Equal(t, 1, fastjson.GetInt(res.Body(), "version"))
+		require.Equal(t, "active", fastjson.GetString(res.Body(), "status"))
+		require.NotEmpty(t, fastjson.GetString(res.Body(), "created_at"))
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
-import "strconv"
+import (
+	"auto/internal/storage"
+	"strconv"
+	"time"
+)
 
 type Option interface {
 	apply(*config)
@@ -12,18 +16,173 @@ func (f optionFunc) apply(c *config) { f(c) }
 
 // config defines fields used for configuring Server instance
 type config struct {
-	addr string
+	addr           string
+	tokens         []string
+	policyFile     string
+	policyInterval time.Duration
+	publicHosts    []string
+	unwrapHosts    []string
+	unwrapTimeout  time.Duration
+	redirect       storage.RedirectPolicy
+	baseURL        string
+	baseURLs       []string
+	middlewares    []Middleware
+	// passwordAttempts and passwordWindow limit failed password attempts per protected link
+	passwordAttempts int
+	passwordWindow   time.Duration
+	// comingSoonPage holds path to HTML template of page shown before link activation, empty for built-in one
+	comingSoonPage string
+	// countryHeader and countryLookup provide client country to targeting rules
+	countryHeader string
+	countryLookup CountryLookup
 }
 
 // Config defines fields (with defaults) used for configuring http server and parsing them from environment variables
 type Config struct {
 	Host string `env:"HOST" envDefault:"0.0.0.0"`
 	Port uint16 `env:"PORT" envDefault:"9000"`
+	// Tokens holds API access tokens in "name:role:secret" form
+	Tokens []string `env:"API_TOKENS" envSeparator:","`
+	// PolicyFile holds path to allow and block rules file, empty for runtime rules only
+	PolicyFile string `env:"POLICY_FILE"`
+	// PolicyReloadInterval defines how often policy file is checked for changes
+	PolicyReloadInterval time.Duration `env:"POLICY_RELOAD_INTERVAL" envDefault:"10s"`
+	// PublicHosts holds hosts short links are served on, links to them are rejected as loops
+	PublicHosts []string `env:"PUBLIC_HOSTS" envSeparator:","`
+	// UnwrapHosts holds hosts of other shorteners whose links are replaced with their targets, empty disables unwrapping
+	UnwrapHosts []string `env:"UNWRAP_HOSTS" envSeparator:","`
+	// UnwrapTimeout limits time spent on following redirects of other shorteners
+	UnwrapTimeout time.Duration `env:"UNWRAP_TIMEOUT" envDefault:"3s"`
+	// RedirectStatus defines HTTP status code of redirects for links without their own one
+	RedirectStatus int `env:"REDIRECT_STATUS" envDefault:"301"`
+	// RedirectMaxAge defines how long redirects of links without their own cache policy may be cached in seconds.
+	// Zero forbids caching, negative value leaves caching headers out
+	RedirectMaxAge int64 `env:"REDIRECT_MAX_AGE" envDefault:"-1"`
+	// PublicBaseURL is prepended to short forms in responses, empty to use scheme and host of the request
+	PublicBaseURL string `env:"PUBLIC_BASE_URL"`
+	// PublicBaseURLOverrides holds base URLs for requests to particular hosts in "host=URL" form
+	PublicBaseURLOverrides []string `env:"PUBLIC_BASE_URL_OVERRIDES" envSeparator:","`
+	// PasswordAttempts limits failed password attempts per protected link within PasswordAttemptsWindow
+	PasswordAttempts int `env:"PASSWORD_ATTEMPTS" envDefault:"5"`
+	// PasswordAttemptsWindow defines how long failed password attempts are counted for
+	PasswordAttemptsWindow time.Duration `env:"PASSWORD_ATTEMPTS_WINDOW" envDefault:"1m"`
+	// ComingSoonPage holds path to HTML template of page browsers see before link activation, empty for built-in one
+	ComingSoonPage string `env:"COMING_SOON_PAGE"`
+	// CountryHeader names request header trusted proxy sets client country code in (e.g. "CF-IPCountry")
+	CountryHeader string `env:"COUNTRY_HEADER"`
 }
 
 // WithConfig enables processing exported Config struct to acts as a source of config parameters for Server
 func WithConfig(cfg Config) Option {
 	return optionFunc(func(c *config) {
 		c.addr = cfg.Host + ":" + strconv.FormatUint(uint64(cfg.Port), 10)
+		c.tokens = cfg.Tokens
+		c.policyFile = cfg.PolicyFile
+		c.policyInterval = cfg.PolicyReloadInterval
+		c.publicHosts = cfg.PublicHosts
+		c.unwrapHosts = cfg.UnwrapHosts
+		c.unwrapTimeout = cfg.UnwrapTimeout
+		c.redirect = redirectPolicy(cfg.RedirectStatus, cfg.RedirectMaxAge)
+		c.baseURL = cfg.PublicBaseURL
+		c.baseURLs = cfg.PublicBaseURLOverrides
+		c.passwordAttempts = cfg.PasswordAttempts
+		c.passwordWindow = cfg.PasswordAttemptsWindow
+		c.comingSoonPage = cfg.ComingSoonPage
+		c.countryHeader = cfg.CountryHeader
+	})
+}
+
+// WithTokens adds API access tokens in "name:role:secret" form
+func WithTokens(tokens ...string) Option {
+	return optionFunc(func(c *config) {
+		c.tokens = append(c.tokens, tokens...)
+	})
+}
+
+// WithPolicyFile enables reading allow and block rules from file checked for changes every interval
+func WithPolicyFile(path string, interval time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.policyFile = path
+		c.policyInterval = interval
+	})
+}
+
+// WithPublicHosts adds hosts short links are served on
+func WithPublicHosts(hosts ...string) Option {
+	return optionFunc(func(c *config) {
+		c.publicHosts = append(c.publicHosts, hosts...)
+	})
+}
+
+// WithUnwrapHosts enables replacing links of other shorteners served on hosts with their targets.
+// Following redirects takes no longer than timeout
+func WithUnwrapHosts(timeout time.Duration, hosts ...string) Option {
+	return optionFunc(func(c *config) {
+		c.unwrapHosts = append(c.unwrapHosts, hosts...)
+		c.unwrapTimeout = timeout
+	})
+}
+
+// WithRedirect sets redirect status code and cache age in seconds for links without their own ones.
+// Zero age forbids caching, negative age leaves caching headers out
+func WithRedirect(status int, maxAge int64) Option {
+	return optionFunc(func(c *config) {
+		c.redirect = redirectPolicy(status, maxAge)
+	})
+}
+
+// WithPublicBaseURL sets base URL of short links in responses and its overrides for requests to particular hosts
+// in "host=URL" form
+func WithPublicBaseURL(base string, overrides ...string) Option {
+	return optionFunc(func(c *config) {
+		c.baseURL = base
+		c.baseURLs = append(c.baseURLs, overrides...)
+	})
+}
+
+// redirectPolicy builds deployment default redirect policy, negative age means no cache policy
+func redirectPolicy(status int, maxAge int64) storage.RedirectPolicy {
+	p := storage.RedirectPolicy{Redirect: status}
+	if maxAge >= 0 {
+		p.MaxAge = &maxAge
+	}
+
+	return p
+}
+
+// WithPasswordAttempts limits failed password attempts per protected link within window
+func WithPasswordAttempts(limit int, window time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.passwordAttempts = limit
+		c.passwordWindow = window
+	})
+}
+
+// WithComingSoonPage sets HTML template file of page browsers see before link activation.
+// Template gets link short form as .Short and its activation time as .NotBefore
+func WithComingSoonPage(path string) Option {
+	return optionFunc(func(c *config) {
+		c.comingSoonPage = path
+	})
+}
+
+// WithCountryHeader makes targeting rules read client country code from request header set by trusted proxy
+func WithCountryHeader(name string) Option {
+	return optionFunc(func(c *config) {
+		c.countryHeader = name
+	})
+}
+
+// WithCountryLookup makes targeting rules resolve client country by IP address when country header is not set
+func WithCountryLookup(lookup CountryLookup) Option {
+	return optionFunc(func(c *config) {
+		c.countryLookup = lookup
+	})
+}
+
+// WithMiddleware adds custom middlewares run in the given order after built-in ones
+func WithMiddleware(middlewares ...Middleware) Option {
+	return optionFunc(func(c *config) {
+		c.middlewares = append(c.middlewares, middlewares...)
 	})
 }
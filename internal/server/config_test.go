@@ -46,4 +46,8 @@ func TestWithEnvConfig(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, "1.2.3.4:43210", srv.addr)
+	// redirects are permanent and carry no cache headers by default
+	c := config{}
+	WithConfig(srvCfg).apply(&c)
+	require.Equal(t, storage.RedirectPolicy{Redirect: 301}, c.redirect)
 }
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"html"
+)
+
+// media types of requests and responses
+const (
+	contentTypeProblem = "application/problem+json"
+	contentTypeJSON    = "application/json"
+	contentTypeHTML    = "text/html; charset=utf-8"
+	contentTypeForm    = "application/x-www-form-urlencoded"
+	contentTypeText    = "text/plain; charset=utf-8"
+)
+
+// apiError defines kind of error response with stable machine-readable code
+type apiError struct {
+	status int
+	code   string
+	title  string
+}
+
+// error responses returned by API. Codes are part of API contract and must never change
+var (
+	errNotFound             = apiError{fasthttp.StatusNotFound, "not_found", "Not Found"}
+	errMethodNotAllowed     = apiError{fasthttp.StatusMethodNotAllowed, "method_not_allowed", "Method Not Allowed"}
+	errUnauthorized         = apiError{fasthttp.StatusUnauthorized, "unauthorized", "Unauthorized"}
+	errForbidden            = apiError{fasthttp.StatusForbidden, "forbidden", "Forbidden"}
+	errMediaTypeUnsupported = apiError{fasthttp.StatusUnsupportedMediaType, "media_type_unsupported", "Unsupported Media Type"}
+	errURLMissing           = apiError{fasthttp.StatusBadRequest, "url_missing", "Missing \"url\" field"}
+	errURLInvalid           = apiError{fasthttp.StatusBadRequest, "url_invalid", "Invalid \"url\" field"}
+	errVersionInvalid       = apiError{fasthttp.StatusBadRequest, "version_invalid", "Invalid \"version\" field"}
+	errQueryInvalid         = apiError{fasthttp.StatusBadRequest, "query_invalid", "Invalid query parameters"}
+	errCursorInvalid        = apiError{fasthttp.StatusBadRequest, "cursor_invalid", "Invalid \"cursor\" query parameter"}
+	errPathInvalid          = apiError{fasthttp.StatusBadRequest, "path_invalid", "Invalid path"}
+	errCodeNotFound         = apiError{fasthttp.StatusNotFound, "code_not_found", "Short link not found"}
+	errCodeNotActive        = apiError{fasthttp.StatusNotFound, "code_not_active", "Short link is not active yet"}
+	errCodeGone             = apiError{fasthttp.StatusGone, "code_gone", "Short link is disabled, deleted or used up"}
+	errVersionNotFound      = apiError{fasthttp.StatusUnprocessableEntity, "version_not_found", "Version does not exist or is the current one"}
+	errRedirectInvalid      = apiError{fasthttp.StatusBadRequest, "redirect_invalid", "Invalid redirect policy"}
+	errExpiryInvalid        = apiError{fasthttp.StatusBadRequest, "expiry_invalid", "Invalid \"expires_at\" field"}
+	errActivationInvalid    = apiError{fasthttp.StatusBadRequest, "activation_invalid", "Invalid link activation window"}
+	errVariantsInvalid      = apiError{fasthttp.StatusBadRequest, "variants_invalid", "Invalid link variants"}
+	errTargetsInvalid       = apiError{fasthttp.StatusBadRequest, "targets_invalid", "Invalid link targeting rules"}
+	errMaxClicksInvalid     = apiError{fasthttp.StatusBadRequest, "max_clicks_invalid", "Invalid \"max_clicks\" field"}
+	errQRInvalid            = apiError{fasthttp.StatusBadRequest, "qr_invalid", "Invalid QR code options"}
+	errURLLoop              = apiError{fasthttp.StatusUnprocessableEntity, "url_loop", "URL points back to this shortener"}
+	errURLUnresolved        = apiError{fasthttp.StatusUnprocessableEntity, "url_unresolved", "Shortened URL can not be resolved"}
+	errURLBlocked           = apiError{fasthttp.StatusForbidden, "url_blocked", "URL is blocked by policy"}
+	errRuleInvalid          = apiError{fasthttp.StatusBadRequest, "rule_invalid", "Invalid policy rule"}
+	errRuleNotFound         = apiError{fasthttp.StatusNotFound, "rule_not_found", "Policy rule not found"}
+	errTemplateInvalid      = apiError{fasthttp.StatusBadRequest, "template_invalid", "Invalid query template"}
+	errTemplateNotFound     = apiError{fasthttp.StatusNotFound, "template_not_found", "Query template not found"}
+	errPasswordInvalid      = apiError{fasthttp.StatusBadRequest, "password_invalid", "Invalid \"password\" field"}
+	errPasswordRequired     = apiError{fasthttp.StatusForbidden, "password_required", "Link is protected by password"}
+	errPasswordWrong        = apiError{fasthttp.StatusForbidden, "password_wrong", "Wrong link password"}
+	errPasswordAttempts     = apiError{fasthttp.StatusTooManyRequests, "password_attempts_exceeded", "Too many wrong passwords, try again later"}
+	errInternal             = apiError{fasthttp.StatusInternalServerError, "internal_error", "Internal Server Error"}
+	errStorageUnavailable   = apiError{fasthttp.StatusInternalServerError, "storage_unavailable", "Something went wrong"}
+)
+
+// problem defines application/problem+json response body (RFC 7807) extended with error code
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance"`
+	Code     string `json:"code"`
+}
+
+// storageErrors maps storage errors to error responses, any other storage error means storage failure
+var storageErrors = []struct {
+	err error
+	api apiError
+}{
+	{storage.ErrShortNotExist, errCodeNotFound},
+	{storage.ErrInvalidShort, errCodeNotFound},
+	{storage.ErrShortGone, errCodeGone},
+	{storage.ErrShortNotActive, errCodeNotActive},
+	{storage.ErrVersionNotExist, errVersionNotFound},
+	{storage.ErrInvalidCursor, errCursorInvalid},
+	{storage.ErrRuleNotExist, errRuleNotFound},
+	{storage.ErrInvalidRedirect, errRedirectInvalid},
+	{storage.ErrInvalidExpiry, errExpiryInvalid},
+	{storage.ErrInvalidActivation, errActivationInvalid},
+	{storage.ErrInvalidVariants, errVariantsInvalid},
+	{storage.ErrInvalidTargets, errTargetsInvalid},
+	{storage.ErrTemplateNotExist, errTemplateNotFound},
+	{storage.ErrInvalidTemplate, errTemplateInvalid},
+	{storage.ErrInvalidPassword, errPasswordInvalid},
+	{storage.ErrWrongPassword, errPasswordWrong},
+}
+
+// writeStorageError writes error response corresponding to error returned by storage
+func writeStorageError(ctx *fasthttp.RequestCtx, err error) {
+	for _, e := range storageErrors {
+		if errors.Is(err, e.err) {
+			writeError(ctx, e.api, "")
+			return
+		}
+	}
+
+	writeError(ctx, errStorageUnavailable, "")
+}
+
+// writeError writes error response as problem details JSON or as HTML page for clients preferring HTML (e.g. browsers)
+func writeError(ctx *fasthttp.RequestCtx, e apiError, detail string) {
+	ctx.SetStatusCode(e.status)
+
+	accept := string(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+	if negotiate(accept, contentTypeProblem, contentTypeJSON, "text/html") == "text/html" {
+		text := e.title
+		if detail != "" {
+			text = detail
+		}
+
+		ctx.SetContentType(contentTypeHTML)
+		ctx.SetBodyString(fmt.Sprintf(
+			"<!DOCTYPE html><html><head><title>%d %s</title></head><body><h1>%s</h1><p>%s</p></body></html>",
+			e.status, html.EscapeString(e.title), html.EscapeString(e.title), html.EscapeString(text),
+		))
+		return
+	}
+
+	body, _ := json.Marshal(problem{
+		Type:     "about:blank",
+		Title:    e.title,
+		Status:   e.status,
+		Detail:   detail,
+		Instance: string(ctx.Path()),
+		Code:     e.code,
+	})
+
+	ctx.SetContentType(contentTypeProblem)
+	ctx.SetBody(body)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"strings"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/api/shorten")
+
+	res := fasthttp.AcquireResponse()
+
+	err := serve(func(ctx *fasthttp.RequestCtx) {
+		writeError(ctx, errMethodNotAllowed, "Use POST")
+	}, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, res.StatusCode())
+	require.Equal(t, []byte(contentTypeProblem), res.Header.ContentType())
+	require.Equal(t, "about:blank", fastjson.GetString(res.Body(), "type"))
+	require.Equal(t, "Method Not Allowed", fastjson.GetString(res.Body(), "title"))
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, fastjson.GetInt(res.Body(), "status"))
+	require.Equal(t, "Use POST", fastjson.GetString(res.Body(), "detail"))
+	require.Equal(t, "/api/shorten", fastjson.GetString(res.Body(), "instance"))
+	require.Equal(t, "method_not_allowed", fastjson.GetString(res.Body(), "code"))
+}
+
+func TestWriteError_HTML(t *testing.T) {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	req.SetRequestURI("/abcdefg")
+
+	res := fasthttp.AcquireResponse()
+
+	err := serve(func(ctx *fasthttp.RequestCtx) {
+		writeError(ctx, errCodeNotFound, "<script>")
+	}, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
+	require.Equal(t, []byte(contentTypeHTML), res.Header.ContentType())
+	require.True(t, strings.Contains(string(res.Body()), "<h1>Short link not found</h1>"))
+	require.True(t, strings.Contains(string(res.Body()), "&lt;script&gt;"))
+}
+
+func TestWriteStorageError(t *testing.T) {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/abcdefg")
+
+	res := fasthttp.AcquireResponse()
+
+	err := serve(func(ctx *fasthttp.RequestCtx) {
+		writeStorageError(ctx, errors.New("mock storage error"))
+	}, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusInternalServerError, res.StatusCode())
+	require.Equal(t, "storage_unavailable", fastjson.GetString(res.Body(), "code"))
+	require.False(t, fastjson.Exists(res.Body(), "detail"))
+}
@@ -1,84 +1,275 @@
 package server
 
 import (
+	"auto/internal/policy"
 	"auto/internal/storage"
+	"auto/internal/unwrap"
+	"encoding/json"
 	"errors"
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fastjson"
 	"go.uber.org/zap"
+	"html/template"
 	"strings"
 )
 
 type handler struct {
-	logger  *zap.Logger
-	Storage *storage.Storage
+	logger     *zap.Logger
+	Storage    *storage.Storage
+	principals []principal
+	policy     *policy.Engine
+	// publicHosts holds hosts short links are served on
+	publicHosts map[string]bool
+	// unwrapper replaces links of other shorteners with their targets, nil if unwrapping is disabled
+	unwrapper *unwrap.Unwrapper
+	// redirect holds deployment default redirect policy of links
+	redirect storage.RedirectPolicy
+	// baseURLs holds public base URLs absolute short link URLs are built on
+	baseURLs baseURLs
+	// passwordAttempts limits failed password attempts per protected link
+	passwordAttempts *attemptLimiter
+	// comingSoon renders page browsers see before link activation
+	comingSoon *template.Template
+	// countryHeader names request header trusted proxy sets client country in, empty if there is none
+	countryHeader string
+	// countryLookup resolves client country by IP address, nil if it is not available
+	countryLookup CountryLookup
 }
 
-// saveURL handles HTTP requests on "/api/shorten" endpoint
+// saveURL handles HTTP requests on "POST /api/shorten" endpoint. Accepts JSON, form and plain text bodies
 func (h *handler) saveURL(ctx *fasthttp.RequestCtx) {
-	logger := h.logger.With(zap.Uint64("request id", ctx.ID()), zap.String("path", string(ctx.Path())))
-	logger.Debug("New request")
+	r, ok := readShortenRequest(ctx)
+	if !ok {
+		return
+	}
 
-	if !ctx.IsPost() {
-		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
-		ctx.SetBody([]byte("Method Not Allowed"))
+	url, ok := h.resolveTarget(ctx, r.url)
+	if !ok {
+		return
+	}
+	if !h.resolveTargets(ctx, r.targets) {
 		return
 	}
+	for i := range r.variants {
+		if r.variants[i].URL, ok = h.resolveTarget(ctx, r.variants[i].URL); !ok {
+			return
+		}
+	}
+	// fallback URL is redirected to as well, so it passes the same checks as target
+	if r.fallback != "" {
+		if r.fallback, ok = h.resolveTarget(ctx, r.fallback); !ok {
+			return
+		}
+	}
 
-	if !fastjson.Exists(ctx.PostBody(), "url") {
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
-		ctx.SetBody([]byte("Missing \"url\" field\n"))
+	// creating links is open to everyone, token holders are just recorded as creators
+	link := storage.Link{
+		URL:            url,
+		NotBefore:      r.notBefore,
+		Fallback:       r.fallback,
+		Variants:       r.variants,
+		Targets:        r.targets,
+		ExpiresAt:      r.expiresAt,
+		MaxClicks:      r.maxClicks,
+		Password:       r.password,
+		RedirectPolicy: r.redirect,
+	}
+	if p, ok := h.authenticate(ctx); ok {
+		link.Creator = p.name
+	}
+
+	short, link, err := h.Storage.CreateLink(ctx.ID(), link)
+	if err != nil {
+		writeStorageError(ctx, err)
 		return
 	}
 
-	url := fastjson.GetString(ctx.PostBody(), "url")
-	if len(url) == 0 {
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
-		ctx.SetBody([]byte("Field \"url\" must be a string and have non-zero length"))
+	h.writeCreated(ctx, short, link)
+
+	return
+}
+
+// getURL handles HTTP requests on "GET /{short}" and "GET /{short}/{path...}" endpoints and password forms
+// posted to them. Returns corresponding redirect, NotFound or Gone. Query parameters of link and its template are set
+// on target, extra path and query are passed to target of passthrough links only. Protected links redirect
+// once password is sent, split links redirect to variant visitor is assigned to, targeted links redirect to destination
+// of the first rule request matches, links not active yet redirect to their fallback URL or show "coming soon" page
+func (h *handler) getURL(ctx *fasthttp.RequestCtx) {
+	short, extraPath := strings.TrimPrefix(string(ctx.Path()), "/"), ""
+	if i := strings.IndexByte(short, '/'); i >= 0 {
+		short, extraPath = short[:i], short[i+1:]
+	}
+
+	if len(short) != 7 {
+		// paths of several segments are not short links at all unless they start with one
+		if extraPath != "" {
+			writeError(ctx, errNotFound, "")
+			return
+		}
+		writeError(ctx, errPathInvalid, "Short link path must be 7 characters long")
 		return
 	}
 
-	short, err := h.Storage.SaveURL(ctx.ID(), url)
+	// extra path and password are checked before click is counted
+	link, err := h.Storage.GetLink(ctx.ID(), short)
+	if errors.Is(err, storage.ErrShortNotActive) {
+		h.writeNotActive(ctx, short)
+		return
+	}
 	if err != nil {
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-		ctx.SetBody([]byte("Something went wrong"))
+		writeStorageError(ctx, err)
+		return
+	}
+	if extraPath != "" && !link.Passthrough {
+		writeError(ctx, errNotFound, "")
+		return
+	}
+	if link.Protected && !h.unlock(ctx, short) {
 		return
 	}
 
-	ctx.SetStatusCode(fasthttp.StatusOK)
-	ctx.SetBody([]byte(`{"short":"` + short + `"}`))
+	// visitors of split links are kept in their variant by cookie
+	var visitor string
+	if len(link.Variants) > 0 {
+		visitor = visitorKey(ctx)
+	}
 
-	logger.Debug("Finishing request")
+	// link checkers probing with HEAD requests are not counted as clicks
+	click := h.Storage.Click
+	if ctx.IsHead() {
+		click = func(reqID uint64, short, visitor string) (storage.Link, error) {
+			link, err := h.Storage.GetLink(reqID, short)
+			link, _ = link.Split(short, visitor)
+			return link, err
+		}
+	}
+
+	link, err = click(ctx.ID(), short, visitor)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	// click is counted for link whatever destination targeting rules choose
+	if len(link.Targets) > 0 {
+		link, _ = link.Target(h.visit(ctx))
+	}
+
+	// query parameters of link and its template are set before passthrough ones, so requests can not override them
+	params, err := h.Storage.LinkQuery(ctx.ID(), link)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	if hasClickID(params) {
+		noCache := int64(0)
+		link.MaxAge = &noCache
+	}
+
+	// stored targets are valid URLs, so failure means broken record
+	if link.URL, err = queryURL(link.URL, expandQuery(params, short)); err != nil {
+		writeError(ctx, errInternal, "")
+		return
+	}
+
+	if link.Passthrough {
+		if link.URL, err = passthroughURL(link.URL, extraPath, ctx.QueryArgs()); err != nil {
+			writeError(ctx, errInternal, "")
+			return
+		}
+	}
+
+	h.writeRedirect(ctx, link)
 
 	return
 }
 
-// getURL handles HTTP requests on "/**" endpoint. Returns corresponding redirect or NotFound
-func (h *handler) getURL(ctx *fasthttp.RequestCtx) {
-	logger := h.logger.With(zap.Uint64("request id", ctx.ID()), zap.String("path", string(ctx.Path())))
-	logger.Debug("New request")
+// searchLinks handles HTTP requests on "GET /api/admin/links/search" endpoint.
+// Exactly one of "host", "prefix" or "q" (substring) query parameters defines the search term
+func (h *handler) searchLinks(ctx *fasthttp.RequestCtx) {
+	if _, ok := h.authorize(ctx, roleAdmin); !ok {
+		return
+	}
+
+	args := ctx.QueryArgs()
+	query := storage.SearchQuery{Cursor: string(args.Peek("cursor"))}
+	switch {
+	case args.Has("host"):
+		query.Mode, query.Term = storage.SearchHost, string(args.Peek("host"))
+	case args.Has("prefix"):
+		query.Mode, query.Term = storage.SearchPrefix, string(args.Peek("prefix"))
+	case args.Has("q"):
+		query.Mode, query.Term = storage.SearchSubstring, string(args.Peek("q"))
+	}
 
-	path := strings.Trim(string(ctx.Path()), "/")
-	if len(path) != 7 {
-		ctx.Error("Invalid path", fasthttp.StatusBadRequest)
+	if len(query.Term) == 0 {
+		writeError(ctx, errQueryInvalid, "One of \"host\", \"prefix\" or \"q\" query parameters must have non-zero length")
 		return
 	}
 
-	url, err := h.Storage.GetURL(ctx.ID(), path)
-	if err != nil {
-		if errors.Is(err, storage.ErrShortNotExist) || errors.Is(err, storage.ErrInvalidShort) {
-			ctx.NotFound()
+	if args.Has("limit") {
+		limit, err := args.GetUint("limit")
+		if err != nil || limit == 0 {
+			writeError(ctx, errQueryInvalid, "Query parameter \"limit\" must be a positive integer")
 			return
 		}
+		query.Limit = limit
+	}
 
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-		ctx.SetBody([]byte("Something went wrong"))
+	result, err := h.Storage.Search(ctx.ID(), query)
+	if err != nil {
+		writeStorageError(ctx, err)
 		return
 	}
 
-	ctx.Redirect(url, fasthttp.StatusMovedPermanently)
+	body, _ := json.Marshal(result)
 
-	logger.Debug("Finishing request")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
 
-	return
+// auditLog handles HTTP requests on "GET /api/admin/audit" endpoint.
+// Optional "actor", "action" and "object" query parameters filter entries, "after" continues from provided sequence number
+func (h *handler) auditLog(ctx *fasthttp.RequestCtx) {
+	if _, ok := h.authorize(ctx, roleAdmin); !ok {
+		return
+	}
+
+	args := ctx.QueryArgs()
+	query := storage.AuditQuery{
+		Actor:  string(args.Peek("actor")),
+		Action: string(args.Peek("action")),
+		Object: string(args.Peek("object")),
+	}
+
+	if args.Has("after") {
+		after, err := args.GetUint("after")
+		if err != nil {
+			writeError(ctx, errQueryInvalid, "Query parameter \"after\" must be a non-negative integer")
+			return
+		}
+		query.AfterSeq = uint64(after)
+	}
+
+	if args.Has("limit") {
+		limit, err := args.GetUint("limit")
+		if err != nil || limit == 0 {
+			writeError(ctx, errQueryInvalid, "Query parameter \"limit\" must be a positive integer")
+			return
+		}
+		query.Limit = limit
+	}
+
+	page, err := h.Storage.AuditLog(ctx.ID(), query)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	body, _ := json.Marshal(page)
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
 }
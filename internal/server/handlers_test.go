@@ -10,6 +10,7 @@ import (
 	"github.com/valyala/fastjson"
 	"go.uber.org/zap"
 	"net"
+	"sync"
 	"testing"
 )
 
@@ -59,11 +60,11 @@ func TestSaveUrl_NotPOST(t *testing.T) {
 
 	res := fasthttp.AcquireResponse()
 
-	err = serve(h.saveURL, req, res)
+	err = serve(h.router().dispatch, req, res)
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusMethodNotAllowed, res.StatusCode())
-	require.Equal(t, []byte("Method Not Allowed"), res.Body())
+	require.Equal(t, "method_not_allowed", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestSaveUrl_NoUrlField(t *testing.T) {
@@ -97,7 +98,7 @@ func TestSaveUrl_NoUrlField(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
-	require.Equal(t, []byte("Missing \"url\" field\n"), res.Body())
+	require.Equal(t, "url_missing", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestSaveUrl_BadUrl(t *testing.T) {
@@ -132,7 +133,43 @@ func TestSaveUrl_BadUrl(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
-	require.Equal(t, []byte("Field \"url\" must be a string and have non-zero length"), res.Body())
+	require.Equal(t, "url_invalid", fastjson.GetString(res.Body(), "code"))
+}
+
+func TestSaveUrl_RejectedUrl(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI("/api/shorten")
+	req.SetBody([]byte(`{"url":"javascript:alert(1)"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+	require.Equal(t, "url_invalid", fastjson.GetString(res.Body(), "code"))
+	require.Equal(t, "URL scheme is not allowed: \"javascript\", only http and https are allowed", fastjson.GetString(res.Body(), "detail"))
 }
 
 func TestSaveUrl_ISE(t *testing.T) {
@@ -174,7 +211,7 @@ func TestSaveUrl_ISE(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusInternalServerError, res.StatusCode())
-	require.Equal(t, []byte("Something went wrong"), res.Body())
+	require.Equal(t, "storage_unavailable", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestGetUrl_InvalidPath(t *testing.T) {
@@ -207,7 +244,7 @@ func TestGetUrl_InvalidPath(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
-	require.Equal(t, []byte("Invalid path"), res.Body())
+	require.Equal(t, "path_invalid", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestGetUrl_InvalidShort(t *testing.T) {
@@ -240,7 +277,7 @@ func TestGetUrl_InvalidShort(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
-	require.Equal(t, []byte("404 Page not found"), res.Body())
+	require.Equal(t, "code_not_found", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestGetUrl_ShortNotExist(t *testing.T) {
@@ -286,7 +323,7 @@ func TestGetUrl_ShortNotExist(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
-	require.Equal(t, []byte("404 Page not found"), res.Body())
+	require.Equal(t, "code_not_found", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestGetUrl_ISE(t *testing.T) {
@@ -329,7 +366,7 @@ func TestGetUrl_ISE(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, fasthttp.StatusInternalServerError, res.StatusCode())
-	require.Equal(t, []byte("Something went wrong"), res.Body())
+	require.Equal(t, "storage_unavailable", fastjson.GetString(res.Body(), "code"))
 }
 
 func TestSaveGetUrl(t *testing.T) {
@@ -375,3 +412,342 @@ func TestSaveGetUrl(t *testing.T) {
 	require.Equal(t, fasthttp.StatusMovedPermanently, getRes.StatusCode())
 	require.Equal(t, []byte("https://github.com/valyala/fasthttp"), getRes.Header.Peek("Location"))
 }
+
+func TestSaveGetUrl_Normalized(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	saveReq := fasthttp.AcquireRequest()
+	saveReq.Header.SetMethod("POST")
+	saveReq.Header.SetHost("dab")
+	saveReq.Header.SetContentType("application/json")
+	saveReq.SetRequestURI("/api/shorten")
+	saveReq.SetBody([]byte(`{"url":"HTTPS://Bücher.Example:443/a/../b%2Fc?x=1"}`))
+
+	saveRes := fasthttp.AcquireResponse()
+	err = serve(h.saveURL, saveReq, saveRes)
+	require.NoError(t, err)
+	short := fastjson.GetString(saveRes.Body(), "short")
+
+	getReq := fasthttp.AcquireRequest()
+	getReq.Header.SetMethod("GET")
+	getReq.Header.SetHost("dab")
+	getReq.SetRequestURI("/" + short)
+
+	getRes := fasthttp.AcquireResponse()
+	err = serve(h.getURL, getReq, getRes)
+	require.NoError(t, err)
+
+	// path is kept as is, so the target decides how to resolve dot segments and escapes
+	require.Equal(t, fasthttp.StatusMovedPermanently, getRes.StatusCode())
+	require.Equal(t, []byte("https://xn--bcher-kva.example/a/../b%2Fc?x=1"), getRes.Header.Peek("Location"))
+}
+
+func TestSaveGetUrl_MaxClicks(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	saveReq := fasthttp.AcquireRequest()
+	saveReq.Header.SetMethod("POST")
+	saveReq.Header.SetHost("dab")
+	saveReq.Header.SetContentType("application/json")
+	saveReq.SetRequestURI("/api/shorten")
+	saveReq.SetBody([]byte(`{"url":"https://github.com/valyala/fasthttp","max_clicks":10}`))
+
+	saveRes := fasthttp.AcquireResponse()
+	err = serve(h.saveURL, saveReq, saveRes)
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusOK, saveRes.StatusCode())
+	short := fastjson.GetString(saveRes.Body(), "short")
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	go func() {
+		err := fasthttp.Serve(ln, h.getURL)
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	client := fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	// all redirects race for the last clicks, only the allowed number of them wins
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		codes    = map[string]int{}
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			req.Header.SetMethod("GET")
+			req.Header.SetHost("dab")
+			req.SetRequestURI("/" + short)
+
+			res := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(res)
+
+			if err := client.Do(req, res); err != nil {
+				panic(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[res.StatusCode()]++
+			if code := fastjson.GetString(res.Body(), "code"); code != "" {
+				codes[code]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, map[int]int{fasthttp.StatusMovedPermanently: 10, fasthttp.StatusGone: 90}, statuses)
+	require.Equal(t, map[string]int{"code_gone": 90}, codes)
+
+	stats, err := store.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), stats.Total)
+}
+
+func TestSearchLinks_Unauthorized(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer wrong")
+	req.SetRequestURI("/api/admin/links/search?host=example.com")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.searchLinks, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusUnauthorized, res.StatusCode())
+	require.Equal(t, []byte("Bearer"), res.Header.Peek("WWW-Authenticate"))
+}
+
+func TestSearchLinks_NoTerm(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.SetRequestURI("/api/admin/links/search?host=")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.searchLinks, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+	require.Equal(t, "query_invalid", fastjson.GetString(res.Body(), "code"))
+}
+
+func TestSearchLinks(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	first, err := store.SaveURL(0, "https://example.com/promo/a")
+	require.NoError(t, err)
+	second, err := store.SaveURL(0, "https://example.com/promo/b")
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.SetRequestURI("/api/admin/links/search?prefix=example.com/promo&limit=1")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.searchLinks, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, first, fastjson.GetString(res.Body(), "links", "0", "short"))
+	cursor := fastjson.GetString(res.Body(), "next_cursor")
+	require.NotEmpty(t, cursor)
+
+	req.SetRequestURI("/api/admin/links/search?prefix=example.com/promo&limit=1&cursor=" + cursor)
+
+	err = serve(h.searchLinks, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, second, fastjson.GetString(res.Body(), "links", "0", "short"))
+	require.Equal(t, "https://example.com/promo/b", fastjson.GetString(res.Body(), "links", "0", "url"))
+	require.Empty(t, fastjson.GetString(res.Body(), "next_cursor"))
+
+	req.SetRequestURI("/api/admin/links/search?q=promo&cursor=zz")
+
+	err = serve(h.searchLinks, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+	require.Equal(t, "cursor_invalid", fastjson.GetString(res.Body(), "code"))
+}
+
+func TestAuditLog(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	_, err = store.Disable(0, short, "bob")
+	require.NoError(t, err)
+
+	_, err = store.Enable(0, short, "carol")
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"bob:editor:editor-secret", "root:admin:admin-secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer editor-secret")
+	req.SetRequestURI("/api/admin/audit?actor=carol")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.auditLog, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusForbidden, res.StatusCode())
+
+	req.Header.Set("Authorization", "Bearer admin-secret")
+
+	err = serve(h.auditLog, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, storage.ActionLinkEnable, fastjson.GetString(res.Body(), "entries", "0", "action"))
+	require.Equal(t, short, fastjson.GetString(res.Body(), "entries", "0", "object"))
+	require.Equal(t, "disabled", fastjson.GetString(res.Body(), "entries", "0", "before", "status"))
+	require.False(t, fastjson.Exists(res.Body(), "entries", "1"))
+
+	req.SetRequestURI("/api/admin/audit?after=-1")
+
+	err = serve(h.auditLog, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+}
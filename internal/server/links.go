@@ -0,0 +1,198 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+)
+
+// linksPath is a path template of link management endpoints
+const linksPath = apiPrefix + "/links/{short}"
+
+// linkResponse defines link representation returned by link management endpoints
+type linkResponse struct {
+	Short    string `json:"short"`
+	ShortURL string `json:"short_url"`
+	storage.Link
+}
+
+// linkMetadata defines link representation returned by link metadata endpoint
+type linkMetadata struct {
+	linkResponse
+	Clicks storage.Clicks `json:"clicks"`
+}
+
+// linkMetadata handles HTTP requests on "GET /api/links/{short}" endpoint.
+// Returns link with its effective status and redirect status and click totals, "If-None-Match" header is honored
+func (h *handler) linkMetadata(ctx *fasthttp.RequestCtx, short string) {
+	if _, ok := h.authorize(ctx, roleEditor); !ok {
+		return
+	}
+
+	link, err := h.Storage.LinkState(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	clicks, err := h.Storage.LinkClicks(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	link.Redirect = h.redirectStatus(link)
+
+	body, _ := json.Marshal(linkMetadata{
+		linkResponse: linkResponse{Short: short, ShortURL: h.shortURL(ctx, short), Link: link},
+		Clicks:       clicks,
+	})
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "private, no-cache")
+
+	if etagMatches(string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)), etag) {
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBody(body)
+}
+
+// updateLink handles HTTP requests on "PATCH /api/links/{short}" endpoint. Points link to new URL
+func (h *handler) updateLink(ctx *fasthttp.RequestCtx, short string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	url := fastjson.GetString(ctx.PostBody(), "url")
+	if len(url) == 0 {
+		writeError(ctx, errURLInvalid, "Field \"url\" must be a string and have non-zero length")
+		return
+	}
+
+	url, ok = h.resolveTarget(ctx, url)
+	if !ok {
+		return
+	}
+
+	link, err := h.Storage.UpdateURL(ctx.ID(), short, url, p.name)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	h.writeLink(ctx, short, link)
+}
+
+// linkHistory handles HTTP requests on "GET /api/links/{short}/history" endpoint. Lists every link version
+func (h *handler) linkHistory(ctx *fasthttp.RequestCtx, short string) {
+	if _, ok := h.authorize(ctx, roleEditor); !ok {
+		return
+	}
+
+	revisions, err := h.Storage.History(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	body, _ := json.Marshal(struct {
+		Short     string             `json:"short"`
+		Revisions []storage.Revision `json:"revisions"`
+	}{short, revisions})
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// revertLink handles HTTP requests on "POST /api/links/{short}/revert" endpoint.
+// Points link to URL of the version provided in request body
+func (h *handler) revertLink(ctx *fasthttp.RequestCtx, short string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	version := fastjson.GetInt(ctx.PostBody(), "version")
+	if version <= 0 {
+		writeError(ctx, errVersionInvalid, "Field \"version\" must be a positive integer")
+		return
+	}
+
+	link, err := h.Storage.Revert(ctx.ID(), short, uint64(version), p.name)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	h.writeLink(ctx, short, link)
+}
+
+// deleteLink handles HTTP requests on "DELETE /api/links/{short}" endpoint.
+// Link is marked as deleted unless "purge=true" query parameter is provided by admin to remove it completely
+func (h *handler) deleteLink(ctx *fasthttp.RequestCtx, short string) {
+	purge := ctx.QueryArgs().GetBool("purge")
+
+	required := roleEditor
+	if purge {
+		required = roleAdmin
+	}
+
+	p, ok := h.authorize(ctx, required)
+	if !ok {
+		return
+	}
+
+	var err error
+	if purge {
+		err = h.Storage.Purge(ctx.ID(), short, p.name)
+	} else {
+		err = h.Storage.Delete(ctx.ID(), short, p.name)
+	}
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// toggleLink handles HTTP requests on "POST /api/links/{short}/enable" and "POST /api/links/{short}/disable" endpoints
+func (h *handler) toggleLink(ctx *fasthttp.RequestCtx, short string, enable bool) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	var link storage.Link
+	var err error
+	if enable {
+		link, err = h.Storage.Enable(ctx.ID(), short, p.name)
+	} else {
+		link, err = h.Storage.Disable(ctx.ID(), short, p.name)
+	}
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	h.writeLink(ctx, short, link)
+}
+
+// writeLink writes link representation as JSON response
+func (h *handler) writeLink(ctx *fasthttp.RequestCtx, short string, link storage.Link) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeJSON)
+
+	_ = json.NewEncoder(ctx).Encode(linkResponse{Short: short, ShortURL: h.shortURL(ctx, short), Link: link})
+}
@@ -0,0 +1,503 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestManageLink_Unauthorized(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com/old")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("PATCH")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/api/links/" + short)
+	req.SetBody([]byte(`{"url":"https://example.com/new"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusUnauthorized, res.StatusCode())
+
+	actual, err := store.GetURL(0, short)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/old", actual)
+}
+
+func TestManageLink_NotFound(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"bob:editor:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("PATCH")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.SetRequestURI("/api/links/abcdefg")
+	req.SetBody([]byte(`{"url":"https://example.com/new"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
+
+	req.Header.SetMethod("DELETE")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
+}
+
+func TestManageLink_UpdateHistoryRevert(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com/old")
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"bob:editor:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	updateReq := fasthttp.AcquireRequest()
+	updateReq.Header.SetMethod("PATCH")
+	updateReq.Header.SetHost("dab")
+	updateReq.Header.Set("Authorization", "Bearer secret")
+	updateReq.SetRequestURI("/api/links/" + short)
+	updateReq.SetBody([]byte(`{"url":"https://example.com/new"}`))
+
+	updateRes := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, updateReq, updateRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, updateRes.StatusCode())
+	require.Equal(t, short, fastjson.GetString(updateRes.Body(), "short"))
+	require.Equal(t, "https://example.com/new", fastjson.GetString(updateRes.Body(), "url"))
+	require.Equal(t, 2, fastjson.GetInt(updateRes.Body(), "version"))
+	require.Equal(t, "bob", fastjson.GetString(updateRes.Body(), "editor"))
+
+	historyReq := fasthttp.AcquireRequest()
+	historyReq.Header.SetMethod("GET")
+	historyReq.Header.SetHost("dab")
+	historyReq.Header.Set("Authorization", "Bearer secret")
+	historyReq.SetRequestURI("/api/links/" + short + "/history")
+
+	historyRes := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, historyReq, historyRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, historyRes.StatusCode())
+	require.Equal(t, "https://example.com/old", fastjson.GetString(historyRes.Body(), "revisions", "0", "url"))
+	require.Equal(t, "https://example.com/new", fastjson.GetString(historyRes.Body(), "revisions", "1", "url"))
+
+	revertReq := fasthttp.AcquireRequest()
+	revertReq.Header.SetMethod("POST")
+	revertReq.Header.SetHost("dab")
+	revertReq.Header.Set("Authorization", "Bearer secret")
+	revertReq.SetRequestURI("/api/links/" + short + "/revert")
+	revertReq.SetBody([]byte(`{"version":5}`))
+
+	revertRes := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, revertReq, revertRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusUnprocessableEntity, revertRes.StatusCode())
+
+	revertReq.SetBody([]byte(`{"version":1}`))
+
+	err = serve(h.router().dispatch, revertReq, revertRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, revertRes.StatusCode())
+	require.Equal(t, "https://example.com/old", fastjson.GetString(revertRes.Body(), "url"))
+	require.Equal(t, 3, fastjson.GetInt(revertRes.Body(), "version"))
+
+	actual, err := store.GetURL(0, short)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/old", actual)
+}
+
+func TestManageLink_DisableDeletePurge(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"bob:editor:editor-secret", "root:admin:admin-secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer editor-secret")
+	req.SetRequestURI("/api/links/" + short + "/disable")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "disabled", fastjson.GetString(res.Body(), "status"))
+
+	getReq := fasthttp.AcquireRequest()
+	getReq.Header.SetMethod("GET")
+	getReq.Header.SetHost("dab")
+	getReq.SetRequestURI("/" + short)
+
+	getRes := fasthttp.AcquireResponse()
+
+	err = serve(h.getURL, getReq, getRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusGone, getRes.StatusCode())
+	require.Equal(t, "code_gone", fastjson.GetString(getRes.Body(), "code"))
+
+	req.SetRequestURI("/api/links/" + short + "/enable")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "active", fastjson.GetString(res.Body(), "status"))
+
+	// purging is available to admins only
+	req.Header.SetMethod("DELETE")
+	req.SetRequestURI("/api/links/" + short + "?purge=true")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusForbidden, res.StatusCode())
+	require.Equal(t, "forbidden", fastjson.GetString(res.Body(), "code"))
+
+	req.SetRequestURI("/api/links/" + short)
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNoContent, res.StatusCode())
+
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	req.SetRequestURI("/api/links/" + short + "?purge=true")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNoContent, res.StatusCode())
+
+	err = serve(h.getURL, getReq, getRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusGone, getRes.StatusCode())
+
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("/api/links/" + short + "/enable")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusGone, res.StatusCode())
+}
+
+func TestLinkMetadata(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	short, _, err := store.CreateLink(0, storage.Link{URL: "https://example.com/target", Creator: "alice", ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+		redirect:   storage.RedirectPolicy{Redirect: 302},
+	}
+
+	// HEAD requests are not counted
+	for _, method := range []string{"GET", "GET", "HEAD"} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(method)
+		req.Header.SetHost("dab")
+		req.SetRequestURI("/" + short)
+
+		res := fasthttp.AcquireResponse()
+		res.SkipBody = method == "HEAD"
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+		require.Equal(t, fasthttp.StatusFound, res.StatusCode())
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.SetRequestURI("/api/v1/links/" + short)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, contentTypeJSON, string(res.Header.ContentType()))
+	require.Equal(t, short, fastjson.GetString(res.Body(), "short"))
+	require.Equal(t, "http://dab/"+short, fastjson.GetString(res.Body(), "short_url"))
+	require.Equal(t, "https://example.com/target", fastjson.GetString(res.Body(), "url"))
+	require.Equal(t, "alice", fastjson.GetString(res.Body(), "creator"))
+	require.Equal(t, "active", fastjson.GetString(res.Body(), "status"))
+	require.Equal(t, fasthttp.StatusFound, fastjson.GetInt(res.Body(), "redirect"))
+	require.Equal(t, expiresAt.Format(time.RFC3339), fastjson.GetString(res.Body(), "expires_at"))
+	require.Equal(t, 2, fastjson.GetInt(res.Body(), "clicks", "total"))
+	require.NotEmpty(t, fastjson.GetString(res.Body(), "clicks", "last_at"))
+
+	etag := string(res.Header.Peek(fasthttp.HeaderETag))
+	require.NotEmpty(t, etag)
+
+	req = fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set(fasthttp.HeaderIfNoneMatch, etag)
+	req.SetRequestURI("/api/v1/links/" + short)
+
+	res = fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotModified, res.StatusCode())
+	require.Empty(t, res.Body())
+
+	// disabled links are described too, the next click is rejected and not counted
+	_, err = store.Disable(0, short, "root")
+	require.NoError(t, err)
+
+	req = fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set(fasthttp.HeaderIfNoneMatch, etag)
+	req.SetRequestURI("/api/v1/links/" + short)
+
+	res = fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "disabled", fastjson.GetString(res.Body(), "status"))
+	require.NotEqual(t, etag, string(res.Header.Peek(fasthttp.HeaderETag)))
+}
+
+func TestLinkMetadata_EffectiveStatus(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Now()
+	store.SetClock(func() time.Time { return now })
+
+	expiresAt := now.Add(time.Hour)
+	expired, err := store.SaveLink(0, storage.Link{URL: "https://example.com/expired", ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	exhausted, err := store.SaveLink(0, storage.Link{URL: "https://example.com/exhausted", MaxClicks: 1})
+	require.NoError(t, err)
+	_, err = store.Click(0, exhausted, "")
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	// links which do not redirect anymore are not reported active
+	for short, status := range map[string]string{expired: "expired", exhausted: "exhausted"} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.SetRequestURI("/" + short)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+		require.Equal(t, fasthttp.StatusGone, res.StatusCode(), short)
+
+		req.Header.Set("Authorization", "Bearer secret")
+		req.SetRequestURI("/api/v1/links/" + short)
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), short)
+		require.Equal(t, status, fastjson.GetString(res.Body(), "status"))
+	}
+}
+
+func TestLinkMetadata_Errors(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com/target")
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	for _, tc := range []struct {
+		authorization string
+		path          string
+		status        int
+		code          string
+	}{
+		{"", "/api/v1/links/" + short, fasthttp.StatusUnauthorized, "unauthorized"},
+		{"Bearer secret", "/api/v1/links/abcdefg", fasthttp.StatusNotFound, "code_not_found"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		req.SetRequestURI(tc.path)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode())
+		require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"))
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// Middleware wraps request handler with behaviour shared by every endpoint.
+// It may short-circuit the chain by writing response without calling next handler
+type Middleware func(next fasthttp.RequestHandler) fasthttp.RequestHandler
+
+// principalKey is a key of request user value holding token holder found by authentication middleware
+const principalKey = "principal"
+
+// chain wraps handler with middlewares, the first middleware is the outermost one
+func chain(handler fasthttp.RequestHandler, middlewares ...Middleware) fasthttp.RequestHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// middlewares lists built-in middlewares followed by custom ones, so custom middlewares are logged,
+// recovered from and see authenticated token holder
+func (h *handler) middlewares(custom []Middleware) []Middleware {
+	return append([]Middleware{requestID, timing, h.logging, h.recovery, h.authentication}, custom...)
+}
+
+// requestID returns request ID in "X-Request-ID" header, it is recorded in logs and audit log entries
+func requestID(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		// set after the handler as recovery resets the response
+		ctx.Response.Header.Set("X-Request-ID", strconv.FormatUint(ctx.ID(), 10))
+	}
+}
+
+// timing returns time spent on request in "Server-Timing" header
+func timing(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		next(ctx)
+
+		ms := float64(time.Since(start)) / float64(time.Millisecond)
+		ctx.Response.Header.Set("Server-Timing", fmt.Sprintf("app;dur=%.3f", ms))
+	}
+}
+
+// logging logs start and end of every request
+func (h *handler) logging(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		logger := h.logger.With(
+			zap.Uint64("request id", ctx.ID()),
+			zap.ByteString("method", ctx.Method()),
+			zap.String("path", string(ctx.Path())),
+		)
+		logger.Debug("New request")
+
+		next(ctx)
+
+		logger.Debug("Finishing request",
+			zap.Int("status", ctx.Response.StatusCode()),
+			zap.Duration("duration", time.Since(ctx.Time())),
+		)
+	}
+}
+
+// recovery turns handler panics into internal server error responses
+func (h *handler) recovery(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			if r := recover(); r != nil {
+				h.logger.Error("Request handler panicked",
+					zap.Uint64("request id", ctx.ID()),
+					zap.String("panic", fmt.Sprint(r)),
+					zap.Stack("stack"),
+				)
+
+				ctx.Response.Reset()
+				writeError(ctx, errInternal, "")
+			}
+		}()
+
+		next(ctx)
+	}
+}
+
+// authentication rejects requests with "Authorization" header matching no API token
+// and keeps token holder of the others for handlers
+func (h *handler) authentication(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if len(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)) == 0 {
+			next(ctx)
+			return
+		}
+
+		p, ok := h.authenticate(ctx)
+		if !ok {
+			writeUnauthorized(ctx)
+			return
+		}
+
+		ctx.SetUserValue(principalKey, p)
+		next(ctx)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"strconv"
+	"testing"
+)
+
+func TestChain_Order(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+			return func(ctx *fasthttp.RequestCtx) {
+				calls = append(calls, name+" before")
+				next(ctx)
+				calls = append(calls, name+" after")
+			}
+		}
+	}
+
+	handler := chain(func(ctx *fasthttp.RequestCtx) {
+		calls = append(calls, "handler")
+	}, record("a"), record("b"))
+
+	handler(&fasthttp.RequestCtx{})
+	require.Equal(t, []string{"a before", "b before", "handler", "b after", "a after"}, calls)
+}
+
+func TestChain_ShortCircuit(t *testing.T) {
+	var calls []string
+	deny := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			calls = append(calls, "deny")
+			ctx.SetStatusCode(fasthttp.StatusForbidden)
+		}
+	}
+	inner := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			calls = append(calls, "inner")
+			next(ctx)
+		}
+	}
+
+	handler := chain(func(ctx *fasthttp.RequestCtx) {
+		calls = append(calls, "handler")
+	}, deny, inner)
+
+	ctx := &fasthttp.RequestCtx{}
+	handler(ctx)
+	require.Equal(t, []string{"deny"}, calls)
+	require.Equal(t, fasthttp.StatusForbidden, ctx.Response.StatusCode())
+}
+
+func TestMiddlewares_Recovery(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	h := &handler{
+		logger: logger,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/abcdefg")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(chain(func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(fasthttp.HeaderLocation, "https://example.com")
+		panic("mock handler panic")
+	}, h.middlewares(nil)...), req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusInternalServerError, res.StatusCode())
+	require.Equal(t, "internal_error", fastjson.GetString(res.Body(), "code"))
+	require.Empty(t, res.Header.Peek(fasthttp.HeaderLocation))
+
+	id, err := strconv.ParseUint(string(res.Header.Peek("X-Request-ID")), 10, 64)
+	require.NoError(t, err)
+	require.NotZero(t, id)
+	require.Regexp(t, `^app;dur=\d+\.\d{3}$`, string(res.Header.Peek("Server-Timing")))
+}
+
+func TestMiddlewares_Authentication(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		principals: principals,
+	}
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+		name          string
+	}{
+		{"", fasthttp.StatusOK, ""},
+		{"Bearer secret", fasthttp.StatusOK, "root"},
+		{"Bearer wrong", fasthttp.StatusUnauthorized, ""},
+		{"Basic cm9vdDpzZWNyZXQ=", fasthttp.StatusUnauthorized, ""},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		if tc.authorization != "" {
+			req.Header.Set(fasthttp.HeaderAuthorization, tc.authorization)
+		}
+		req.SetRequestURI("/abcdefg")
+
+		res := fasthttp.AcquireResponse()
+
+		called := false
+		err = serve(chain(func(ctx *fasthttp.RequestCtx) {
+			called = true
+			p, _ := ctx.UserValue(principalKey).(principal)
+			ctx.SetBodyString(p.name)
+		}, h.middlewares(nil)...), req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.authorization)
+		require.Equal(t, tc.status == fasthttp.StatusOK, called)
+		if called {
+			require.Equal(t, tc.name, string(res.Body()))
+		} else {
+			require.Equal(t, "unauthorized", fastjson.GetString(res.Body(), "code"))
+		}
+	}
+}
+
+func TestNew_WithMiddleware(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	var paths []string
+	record := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			paths = append(paths, string(ctx.Path()))
+			next(ctx)
+		}
+	}
+	maintenance := func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if ctx.IsPost() {
+				ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+				return
+			}
+			next(ctx)
+		}
+	}
+
+	srv, err := New(logger, store, WithMiddleware(record, maintenance))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"POST", "/api/v1/shorten", fasthttp.StatusServiceUnavailable},
+		{"GET", "/api/v1/typo", fasthttp.StatusNotFound},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(tc.method)
+		req.Header.SetHost("dab")
+		req.SetRequestURI(tc.path)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(srv.httpServer.Handler, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.path)
+		require.NotEmpty(t, res.Header.Peek("X-Request-ID"))
+	}
+
+	require.Equal(t, []string{"/api/v1/shorten", "/api/v1/typo"}, paths)
+}
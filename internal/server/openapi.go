@@ -0,0 +1,1054 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"html/template"
+)
+
+const (
+	// openAPIPath is a path the OpenAPI document is served on
+	openAPIPath = apiPrefix + "/openapi.json"
+	// docsPath is a path of API documentation page rendered from the OpenAPI document
+	docsPath = apiPrefix + "/docs"
+)
+
+// openAPISpec is OpenAPI 3 document describing every endpoint. Tests keep it in sync with routes and responses
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "URL Shortener API",
+    "version": "1.0.0",
+    "description": "Creates short links and redirects them to their targets. Errors are returned as problem details with stable machine-readable code. Paths under /api/v1 are also served under /api for clients of unversioned API. Every path answers OPTIONS requests and GET endpoints answer HEAD requests."
+  },
+  "servers": [{"url": "/"}],
+  "paths": {
+    "/api/v1/shorten": {
+      "post": {
+        "operationId": "saveURL",
+        "summary": "Create short link",
+        "description": "Creating links is open to everyone, token holders are recorded as link creators.",
+        "security": [{}, {"bearer": []}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"$ref": "#/components/schemas/ShortenRequest"},
+              "example": {"url": "https://example.com/promo", "redirect": 302, "max_age": 3600, "expires_at": "2100-01-01T00:00:00Z"}
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {"$ref": "#/components/schemas/ShortenRequest"}
+            },
+            "text/plain": {
+              "schema": {"type": "string", "description": "Target URL"}
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Created link",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/Link"}},
+              "application/x-www-form-urlencoded": {
+                "schema": {
+                  "type": "object",
+                  "properties": {"short": {"type": "string"}, "short_url": {"type": "string"}, "url": {"type": "string"}}
+                }
+              },
+              "text/plain": {"schema": {"type": "string", "description": "Short URL followed by newline"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "415": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/{short}": {
+      "get": {
+        "operationId": "getURL",
+        "summary": "Redirect to link target",
+        "description": "Redirect status and cache headers follow link redirect policy falling back to deployment defaults. Protected links redirect once password is sent, browsers get password form instead of 403 error. Links not active yet redirect to their fallback URL with 302 or respond with 404, browsers get \"coming soon\" page. Split links redirect to variant visitor is assigned to and targeted links to destination of the first rule request matches.",
+        "parameters": [{"$ref": "#/components/parameters/Short"}, {"$ref": "#/components/parameters/LinkPassword"}],
+        "responses": {
+          "301": {"$ref": "#/components/responses/Redirect"},
+          "302": {"$ref": "#/components/responses/Redirect"},
+          "307": {"$ref": "#/components/responses/Redirect"},
+          "308": {"$ref": "#/components/responses/Redirect"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "post": {
+        "operationId": "unlockURL",
+        "summary": "Redirect to protected link target",
+        "description": "Password form of protected link posts here. Links without password redirect too.",
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "requestBody": {"$ref": "#/components/requestBodies/Password"},
+        "responses": {
+          "303": {"$ref": "#/components/responses/Redirect"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/{short}/{path...}": {
+      "get": {
+        "operationId": "passthroughURL",
+        "summary": "Redirect to link target with extra path",
+        "description": "Served for links with passthrough policy only. Extra path is appended to target path and query parameters target does not set are added to it.",
+        "parameters": [
+          {"$ref": "#/components/parameters/Short"},
+          {"$ref": "#/components/parameters/ExtraPath"},
+          {"$ref": "#/components/parameters/LinkPassword"}
+        ],
+        "responses": {
+          "301": {"$ref": "#/components/responses/Redirect"},
+          "302": {"$ref": "#/components/responses/Redirect"},
+          "307": {"$ref": "#/components/responses/Redirect"},
+          "308": {"$ref": "#/components/responses/Redirect"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "post": {
+        "operationId": "unlockPassthroughURL",
+        "summary": "Redirect to protected link target with extra path",
+        "description": "Password form of protected passthrough link posts here.",
+        "parameters": [
+          {"$ref": "#/components/parameters/Short"},
+          {"$ref": "#/components/parameters/ExtraPath"}
+        ],
+        "requestBody": {"$ref": "#/components/requestBodies/Password"},
+        "responses": {
+          "303": {"$ref": "#/components/responses/Redirect"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/{short}+": {
+      "get": {
+        "operationId": "previewLinkSuffix",
+        "summary": "Preview link",
+        "description": "Same as /preview/{short}.",
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Preview"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/preview/{short}": {
+      "get": {
+        "operationId": "previewLink",
+        "summary": "Preview link",
+        "description": "Describes link destination without redirecting. Targets of disabled and deleted links are not shown.",
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Preview"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/{short}/qr": {
+      "get": {
+        "operationId": "qrCode",
+        "summary": "QR code of short link",
+        "parameters": [
+          {"$ref": "#/components/parameters/Short"},
+          {"name": "format", "in": "query", "schema": {"type": "string", "enum": ["png", "svg"], "default": "png"}},
+          {"name": "size", "in": "query", "schema": {"type": "integer", "minimum": 32, "maximum": 2048, "default": 256}, "example": 512},
+          {"name": "ecc", "in": "query", "schema": {"type": "string", "enum": ["L", "M", "Q", "H"], "default": "M"}},
+          {"name": "margin", "in": "query", "schema": {"type": "integer", "minimum": 0, "maximum": 32, "default": 4}},
+          {"name": "fg", "in": "query", "schema": {"type": "string", "default": "000000"}},
+          {"name": "bg", "in": "query", "schema": {"type": "string", "default": "ffffff"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "QR code image",
+            "headers": {"ETag": {"schema": {"type": "string"}}},
+            "content": {
+              "image/png": {"schema": {"type": "string", "format": "binary"}},
+              "image/svg+xml": {"schema": {"type": "string"}}
+            }
+          },
+          "304": {"description": "Image matches If-None-Match header"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/admin/links/search": {
+      "get": {
+        "operationId": "searchLinks",
+        "summary": "Search links by target",
+        "description": "Exactly one of host, prefix or q parameters selects the search mode.",
+        "security": [{"bearer": []}],
+        "parameters": [
+          {"name": "host", "in": "query", "schema": {"type": "string"}},
+          {"name": "prefix", "in": "query", "schema": {"type": "string"}, "example": "example.com/promo"},
+          {"name": "q", "in": "query", "schema": {"type": "string"}},
+          {"name": "cursor", "in": "query", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1}, "example": 20}
+        ],
+        "responses": {
+          "200": {"description": "Page of found links", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SearchResult"}}}},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/admin/audit": {
+      "get": {
+        "operationId": "auditLog",
+        "summary": "Read audit log",
+        "security": [{"bearer": []}],
+        "parameters": [
+          {"name": "actor", "in": "query", "schema": {"type": "string"}},
+          {"name": "action", "in": "query", "schema": {"type": "string"}},
+          {"name": "object", "in": "query", "schema": {"type": "string"}},
+          {"name": "after", "in": "query", "schema": {"type": "integer", "minimum": 0}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1}, "example": 20}
+        ],
+        "responses": {
+          "200": {"description": "Page of audit entries", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AuditPage"}}}},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "405": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/admin/policy/rules": {
+      "get": {
+        "operationId": "listRules",
+        "summary": "List policy rules",
+        "description": "File rules are followed by runtime ones.",
+        "security": [{"bearer": []}],
+        "responses": {
+          "200": {
+            "description": "Policy rules",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": ["rules"],
+                  "properties": {"rules": {"type": "array", "items": {"$ref": "#/components/schemas/Rule"}}}
+                }
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "post": {
+        "operationId": "addRule",
+        "summary": "Add runtime policy rule",
+        "description": "Retroactive block rule disables existing links it blocks.",
+        "security": [{"bearer": []}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["action", "pattern"],
+                "properties": {
+                  "action": {"type": "string", "enum": ["allow", "block"]},
+                  "pattern": {"type": "string"},
+                  "retroactive": {"type": "boolean"}
+                }
+              },
+              "example": {"action": "block", "pattern": "*.evil.example", "retroactive": true}
+            }
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Added rule",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {"$ref": "#/components/schemas/Rule"},
+                    {
+                      "type": "object",
+                      "required": ["disabled"],
+                      "properties": {"disabled": {"type": "array", "items": {"type": "string"}, "description": "Short forms of links disabled by the rule"}}
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/admin/policy/rules/{id}": {
+      "delete": {
+        "operationId": "removeRule",
+        "summary": "Remove runtime policy rule",
+        "security": [{"bearer": []}],
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "204": {"description": "Rule removed"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/templates": {
+      "get": {
+        "operationId": "listTemplates",
+        "summary": "List query templates",
+        "security": [{"bearer": []}],
+        "responses": {
+          "200": {
+            "description": "Query templates ordered by name",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "required": ["templates"],
+              "properties": {"templates": {"type": "array", "items": {"$ref": "#/components/schemas/QueryTemplate"}}}
+            }}}
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/templates/{name}": {
+      "get": {
+        "operationId": "getTemplate",
+        "summary": "Read query template",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/TemplateName"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/QueryTemplate"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "put": {
+        "operationId": "putTemplate",
+        "summary": "Create or replace query template",
+        "description": "Links referencing template pick the change up on the next redirect.",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/TemplateName"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {
+            "schema": {"type": "object", "properties": {"params": {"$ref": "#/components/schemas/QueryParams"}}},
+            "example": {"params": {"utm_source": "newsletter", "utm_campaign": "spring", "ref": "{code}"}}
+          }}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/QueryTemplate"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "delete": {
+        "operationId": "removeTemplate",
+        "summary": "Remove query template",
+        "description": "Links referencing removed template keep the reference and add no template parameters.",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/TemplateName"}],
+        "responses": {
+          "204": {"description": "Template removed"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}": {
+      "get": {
+        "operationId": "linkMetadata",
+        "summary": "Read link metadata",
+        "description": "Returns link of any status with its effective redirect status and click totals. Expired links keep their status, expires_at tells they do not redirect anymore.",
+        "security": [{"bearer": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/Short"},
+          {"name": "If-None-Match", "in": "header", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Link metadata",
+            "headers": {"ETag": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LinkMetadata"}}}
+          },
+          "304": {"description": "Metadata matches If-None-Match header"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "patch": {
+        "operationId": "updateLink",
+        "summary": "Point link to new target",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"type": "object", "required": ["url"], "properties": {"url": {"type": "string"}}},
+              "example": {"url": "https://example.com/other"}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "delete": {
+        "operationId": "deleteLink",
+        "summary": "Delete link",
+        "description": "Link is marked as deleted unless purge is requested by admin to remove it completely.",
+        "security": [{"bearer": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/Short"},
+          {"name": "purge", "in": "query", "schema": {"type": "boolean", "default": false}, "example": false}
+        ],
+        "responses": {
+          "204": {"description": "Link deleted"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/history": {
+      "get": {
+        "operationId": "linkHistory",
+        "summary": "List link versions",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "responses": {
+          "200": {
+            "description": "Every link version from the oldest one",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": ["short", "revisions"],
+                  "properties": {
+                    "short": {"type": "string"},
+                    "revisions": {"type": "array", "items": {"$ref": "#/components/schemas/Revision"}}
+                  }
+                }
+              }
+            }
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/revert": {
+      "post": {
+        "operationId": "revertLink",
+        "summary": "Point link to target of earlier version",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"type": "object", "required": ["version"], "properties": {"version": {"type": "integer", "minimum": 1}}},
+              "example": {"version": 1}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/disable": {
+      "post": {
+        "operationId": "disableLink",
+        "summary": "Stop link redirecting",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/enable": {
+      "post": {
+        "operationId": "enableLink",
+        "summary": "Resume link redirecting",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/redirect": {
+      "put": {
+        "operationId": "setRedirect",
+        "summary": "Replace link redirect policy",
+        "description": "Omitted fields fall back to deployment defaults.",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {"$ref": "#/components/schemas/RedirectPolicy"},
+              "example": {"redirect": 307, "max_age": 0, "passthrough": true, "template": "spring", "query": {"utm_content": "{code}"}}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/variants": {
+      "put": {
+        "operationId": "setVariantWeights",
+        "summary": "Replace weights of split link variants",
+        "description": "Weights are listed in variant order. Visitors are reassigned at once, link version is kept.",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["weights"],
+                "properties": {"weights": {"type": "array", "items": {"type": "integer", "minimum": 0, "maximum": 10000}}}
+              },
+              "example": {"weights": [20, 80]}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/targets": {
+      "put": {
+        "operationId": "setTargets",
+        "summary": "Replace link targeting rules",
+        "description": "Rules are evaluated in order, the first one request matches chooses destination and link url is the destination of requests matching none. Empty rules make link redirect to its url only, link version is kept.",
+        "security": [{"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/Short"}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["targets"],
+                "properties": {"targets": {"type": "array", "items": {"$ref": "#/components/schemas/TargetRule"}}}
+              },
+              "example": {"targets": [{"os": ["ios"], "url": "https://apps.apple.com/app/id1"}, {"os": ["android"], "device": ["mobile", "tablet"], "url": "https://play.google.com/store/apps/details?id=com.example"}]}
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Link"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/links/{short}/targets/test": {
+      "get": {
+        "operationId": "testTargets",
+        "summary": "Show destination targeting rules choose for request",
+        "description": "Omitted parameters are taken from the request itself. Destination is shown before query parameters are set on it.",
+        "security": [{"bearer": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/Short"},
+          {"name": "user_agent", "in": "query", "schema": {"type": "string"}, "example": "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) Mobile/15E148"},
+          {"name": "accept_language", "in": "query", "schema": {"type": "string"}, "example": "de-CH, de;q=0.9"},
+          {"name": "country", "in": "query", "schema": {"type": "string"}, "example": "CH"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Destination of the request",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetTest"}}}
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/openapi.json": {
+      "get": {
+        "operationId": "openAPI",
+        "summary": "This document",
+        "responses": {
+          "200": {"description": "OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}},
+          "405": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/api/v1/docs": {
+      "get": {
+        "operationId": "docs",
+        "summary": "API documentation page",
+        "responses": {
+          "200": {"description": "Documentation rendered from this document", "content": {"text/html": {"schema": {"type": "string"}}}},
+          "405": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer", "description": "API token secret. Editor role manages links, admin role also reads audit log and manages policy."}
+    },
+    "parameters": {
+      "Short": {"name": "short", "in": "path", "required": true, "schema": {"type": "string", "minLength": 7, "maxLength": 7}, "example": "jnegYbw"},
+      "ExtraPath": {"name": "path", "in": "path", "required": true, "description": "Rest of request path, may span several segments", "schema": {"type": "string"}, "example": "docs/intro"},
+      "LinkPassword": {"name": "X-Link-Password", "in": "header", "description": "Password of protected link", "schema": {"type": "string"}},
+      "TemplateName": {"name": "name", "in": "path", "required": true, "schema": {"type": "string", "pattern": "^[a-z0-9][a-z0-9_-]{0,63}$"}, "example": "spring"}
+    },
+    "requestBodies": {
+      "Password": {
+        "content": {"application/x-www-form-urlencoded": {"schema": {"type": "object", "properties": {"password": {"type": "string"}}}}}
+      }
+    },
+    "responses": {
+      "Problem": {
+        "description": "Error with stable code",
+        "content": {
+          "application/problem+json": {"schema": {"$ref": "#/components/schemas/Problem"}},
+          "text/html": {"schema": {"type": "string"}}
+        }
+      },
+      "QueryTemplate": {
+        "description": "Query template",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/QueryTemplate"}}}
+      },
+      "Link": {
+        "description": "Link",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Link"}}}
+      },
+      "Redirect": {
+        "description": "Redirect to link target",
+        "headers": {
+          "Location": {"schema": {"type": "string"}},
+          "Cache-Control": {"schema": {"type": "string"}},
+          "Expires": {"schema": {"type": "string"}}
+        }
+      },
+      "Preview": {
+        "description": "Link preview",
+        "content": {
+          "text/html": {"schema": {"type": "string"}},
+          "application/json": {"schema": {"$ref": "#/components/schemas/Preview"}}
+        }
+      }
+    },
+    "schemas": {
+      "Problem": {
+        "type": "object",
+        "required": ["type", "title", "status", "instance", "code"],
+        "properties": {
+          "type": {"type": "string"},
+          "title": {"type": "string"},
+          "status": {"type": "integer"},
+          "detail": {"type": "string"},
+          "instance": {"type": "string"},
+          "code": {"type": "string"}
+        }
+      },
+      "RedirectPolicy": {
+        "type": "object",
+        "properties": {
+          "redirect": {"type": "integer", "enum": [301, 302, 307, 308]},
+          "max_age": {"type": "integer", "minimum": 0, "maximum": 31536000, "description": "Seconds redirect may be cached for, 0 forbids caching"},
+          "passthrough": {"type": "boolean", "description": "Forward extra path and query parameters of short link requests to target"},
+          "query": {"$ref": "#/components/schemas/QueryParams"},
+          "template": {"type": "string", "description": "Name of query template shared by group of links, link query parameters override template ones"}
+        }
+      },
+      "QueryParams": {
+        "type": "object",
+        "description": "Query parameters set on target on redirect replacing its own ones. Values may hold {code} and {click_id} placeholders",
+        "additionalProperties": {"type": "string"}
+      },
+      "QueryTemplate": {
+        "type": "object",
+        "required": ["name", "params", "updated_at"],
+        "properties": {
+          "name": {"type": "string"},
+          "params": {"$ref": "#/components/schemas/QueryParams"},
+          "editor": {"type": "string"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "ShortenRequest": {
+        "allOf": [
+          {"$ref": "#/components/schemas/RedirectPolicy"},
+          {
+            "type": "object",
+            "properties": {
+              "url": {"type": "string", "description": "Link target, required unless variants are sent"},
+              "expires_at": {"type": "string", "format": "date-time", "description": "Time link stops redirecting at, must be in the future"},
+              "max_clicks": {"type": "integer", "minimum": 1, "description": "Number of redirects link stops redirecting after, 1 makes single-use link"},
+              "not_before": {"type": "string", "format": "date-time", "description": "Time link starts redirecting at"},
+              "not_after": {"type": "string", "format": "date-time", "description": "Another name of expires_at, only one of them may be sent"},
+              "fallback_url": {"type": "string", "description": "URL link redirects to before not_before time"},
+              "targets": {
+                "type": "array",
+                "items": {"$ref": "#/components/schemas/TargetRule"},
+                "maxItems": 20,
+                "description": "Rules choosing destination by device, language and country in order, url is the destination of requests matching none"
+              },
+              "variants": {
+                "type": "array",
+                "items": {"$ref": "#/components/schemas/Variant"},
+                "minItems": 2,
+                "maxItems": 10,
+                "description": "Destinations split link distributes visitors across by weight, sent instead of url. The first one is link url"
+              },
+              "password": {"type": "string", "maxLength": 72, "description": "Password link redirects with, only its hash is stored"}
+            }
+          }
+        ]
+      },
+      "Link": {
+        "allOf": [
+          {"$ref": "#/components/schemas/RedirectPolicy"},
+          {
+            "type": "object",
+            "required": ["short", "short_url", "url", "version", "status", "created_at", "updated_at"],
+            "properties": {
+              "short": {"type": "string"},
+              "short_url": {"type": "string"},
+              "url": {"type": "string"},
+              "version": {"type": "integer", "minimum": 1},
+              "status": {"$ref": "#/components/schemas/LinkStatus"},
+              "creator": {"type": "string"},
+              "created_at": {"type": "string", "format": "date-time"},
+              "editor": {"type": "string"},
+              "updated_at": {"type": "string", "format": "date-time"},
+              "not_before": {"type": "string", "format": "date-time"},
+              "fallback_url": {"type": "string"},
+              "variants": {"type": "array", "items": {"$ref": "#/components/schemas/Variant"}},
+              "targets": {"type": "array", "items": {"$ref": "#/components/schemas/TargetRule"}},
+              "expires_at": {"type": "string", "format": "date-time"},
+              "max_clicks": {"type": "integer", "minimum": 1},
+              "protected": {"type": "boolean"}
+            }
+          }
+        ]
+      },
+      "LinkMetadata": {
+        "allOf": [
+          {"$ref": "#/components/schemas/Link"},
+          {"type": "object", "required": ["clicks"], "properties": {"clicks": {"$ref": "#/components/schemas/Clicks"}}}
+        ]
+      },
+      "Clicks": {
+        "type": "object",
+        "required": ["total"],
+        "properties": {
+          "total": {"type": "integer", "minimum": 0},
+          "last_at": {"type": "string", "format": "date-time"},
+          "variants": {"type": "array", "items": {"type": "integer", "minimum": 0}, "description": "Clicks of split link variants in variant order"}
+        }
+      },
+      "TargetRule": {
+        "type": "object",
+        "required": ["url"],
+        "description": "Request matches rule when it matches every condition rule has, condition matches any of its values",
+        "properties": {
+          "os": {"type": "array", "items": {"type": "string", "enum": ["android", "ios", "windows", "macos", "linux", "chromeos"]}},
+          "device": {"type": "array", "items": {"type": "string", "enum": ["mobile", "tablet", "desktop", "bot"]}},
+          "language": {"type": "array", "items": {"type": "string"}, "description": "Language tags matching the most preferred language of Accept-Language header and more specific ones"},
+          "country": {"type": "array", "items": {"type": "string"}, "description": "ISO 3166-1 alpha-2 country codes"},
+          "url": {"type": "string"}
+        }
+      },
+      "Visit": {
+        "type": "object",
+        "properties": {
+          "os": {"type": "string"},
+          "device": {"type": "string"},
+          "language": {"type": "string"},
+          "country": {"type": "string"}
+        }
+      },
+      "TargetTest": {
+        "type": "object",
+        "required": ["visit", "url"],
+        "properties": {
+          "visit": {"$ref": "#/components/schemas/Visit"},
+          "rule": {"type": "integer", "minimum": 0, "description": "Index of matching rule, omitted when request matches none"},
+          "url": {"type": "string"}
+        }
+      },
+      "Variant": {
+        "type": "object",
+        "required": ["url", "weight"],
+        "properties": {
+          "url": {"type": "string"},
+          "weight": {"type": "integer", "minimum": 0, "maximum": 10000}
+        }
+      },
+      "LinkStatus": {"type": "string", "enum": ["active", "disabled", "deleted", "expired", "exhausted"], "description": "Expired and exhausted are effective statuses of active links past expiry time or click limit, they are reported by metadata and preview only"},
+      "Preview": {
+        "type": "object",
+        "required": ["short", "status", "created_at", "updated_at"],
+        "properties": {
+          "short": {"type": "string"},
+          "url": {"type": "string"},
+          "domain": {"type": "string"},
+          "status": {"$ref": "#/components/schemas/LinkStatus"},
+          "protected": {"type": "boolean"},
+          "scheduled": {"type": "boolean"},
+          "redirect": {"type": "integer"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Revision": {
+        "type": "object",
+        "required": ["version", "url", "time"],
+        "properties": {
+          "version": {"type": "integer", "minimum": 1},
+          "url": {"type": "string"},
+          "editor": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"}
+        }
+      },
+      "SearchResult": {
+        "type": "object",
+        "required": ["links", "next_cursor"],
+        "properties": {
+          "links": {
+            "type": "array",
+            "items": {"type": "object", "required": ["short", "url"], "properties": {"short": {"type": "string"}, "url": {"type": "string"}}}
+          },
+          "next_cursor": {"type": "string", "description": "Empty for the last page"}
+        }
+      },
+      "AuditPage": {
+        "type": "object",
+        "required": ["entries", "next_seq"],
+        "properties": {
+          "entries": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEntry"}},
+          "next_seq": {"type": "integer", "description": "Zero for the last page"}
+        }
+      },
+      "AuditEntry": {
+        "type": "object",
+        "required": ["seq", "time", "actor", "action", "object", "request_id", "prev_hash", "hash"],
+        "properties": {
+          "seq": {"type": "integer"},
+          "time": {"type": "string", "format": "date-time"},
+          "actor": {"type": "string"},
+          "action": {"type": "string"},
+          "object": {"type": "string"},
+          "before": {"type": "object"},
+          "after": {"type": "object"},
+          "request_id": {"type": "integer"},
+          "prev_hash": {"type": "string"},
+          "hash": {"type": "string"}
+        }
+      },
+      "Rule": {
+        "type": "object",
+        "required": ["id", "action", "kind", "pattern", "retroactive", "source", "created_at"],
+        "properties": {
+          "id": {"type": "string"},
+          "action": {"type": "string", "enum": ["allow", "block"]},
+          "kind": {"type": "string", "enum": ["domain", "wildcard", "regex"]},
+          "pattern": {"type": "string"},
+          "retroactive": {"type": "boolean"},
+          "source": {"type": "string", "enum": ["file", "api"]},
+          "creator": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      }
+    }
+  }
+}
+`
+
+// docsPage renders API documentation page listing every operation of the OpenAPI document
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Info.Title}}</title>
+</head>
+<body>
+<h1>{{.Info.Title}} {{.Info.Version}}</h1>
+<p>{{.Info.Description}}</p>
+<p>Machine-readable document: <a href="` + openAPIPath + `">` + openAPIPath + `</a></p>
+{{- range $path, $item := .Paths}}
+{{- range $method, $op := $item}}
+<h2><code>{{$method}} {{$path}}</code></h2>
+<p><strong>{{$op.Summary}}</strong></p>
+{{- if $op.Description}}
+<p>{{$op.Description}}</p>
+{{- end}}
+<ul>
+{{- range $status, $res := $op.Responses}}
+<li><code>{{$status}}</code>{{if $res.Description}} {{$res.Description}}{{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// openAPIDocument defines parts of OpenAPI document shown on documentation page
+type openAPIDocument struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	// Paths maps path templates to operations by method
+	Paths map[string]map[string]struct {
+		Summary     string `json:"summary"`
+		Description string `json:"description"`
+		Responses   map[string]struct {
+			Description string `json:"description"`
+		} `json:"responses"`
+	} `json:"paths"`
+}
+
+// docsHTML holds documentation page rendered once from the OpenAPI document
+var docsHTML = func() []byte {
+	var doc openAPIDocument
+	if err := json.Unmarshal([]byte(openAPISpec), &doc); err != nil {
+		panic("parsing OpenAPI document: " + err.Error())
+	}
+
+	var body bytes.Buffer
+	if err := docsPage.Execute(&body, doc); err != nil {
+		panic("rendering documentation page: " + err.Error())
+	}
+
+	return body.Bytes()
+}()
+
+// openAPI handles HTTP requests on "GET /api/openapi.json" endpoint. Returns OpenAPI document
+func (h *handler) openAPI(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBodyString(openAPISpec)
+}
+
+// docs handles HTTP requests on "GET /api/docs" endpoint. Returns documentation page rendered from OpenAPI document
+func (h *handler) docs(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeHTML)
+	ctx.SetBody(docsHTML)
+}
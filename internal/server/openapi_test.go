@@ -0,0 +1,311 @@
+package server
+
+import (
+	"auto/internal/policy"
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkSchema reports mismatch of decoded JSON value and schema of OpenAPI document.
+// It supports the subset of JSON Schema used by the document. Properties undeclared by object schema
+// are checked against its additionalProperties or rejected unless open is set, as allOf parts declare only some of them
+func checkSchema(doc, schema map[string]interface{}, value interface{}, at string, open bool) error {
+	schema = resolve(doc, schema)
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, part := range all {
+			if err := checkSchema(doc, part.(map[string]interface{}), value, at, true); err != nil {
+				return err
+			}
+		}
+		if object, ok := value.(map[string]interface{}); ok && !open {
+			known := properties(doc, schema)
+			for name := range object {
+				if _, ok := known[name]; !ok {
+					return fmt.Errorf("%s: undocumented property %q", at, name)
+				}
+			}
+		}
+		return nil
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == value
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not in %v", at, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", at, value)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: required property %q is missing", at, name)
+			}
+		}
+		declared, ok := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range object {
+			property, known := declared[name]
+			if !known && additional != nil {
+				property, known = additional, true
+			}
+			if !known {
+				if ok && !open {
+					return fmt.Errorf("%s: undocumented property %q", at, name)
+				}
+				continue
+			}
+			if err := checkSchema(doc, property.(map[string]interface{}), v, at+"."+name, false); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", at, value)
+		}
+		for i, v := range array {
+			if err := checkSchema(doc, schema["items"].(map[string]interface{}), v, at+"["+strconv.Itoa(i)+"]", false); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: %v is not a string", at, value)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: %v is not an integer", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", at, value)
+		}
+	}
+
+	return nil
+}
+
+// properties returns properties declared by schema and its allOf parts
+func properties(doc, schema map[string]interface{}) map[string]interface{} {
+	schema = resolve(doc, schema)
+
+	known := map[string]interface{}{}
+	all, _ := schema["allOf"].([]interface{})
+	for _, part := range all {
+		for name, property := range properties(doc, part.(map[string]interface{})) {
+			known[name] = property
+		}
+	}
+
+	declared, _ := schema["properties"].(map[string]interface{})
+	for name, property := range declared {
+		known[name] = property
+	}
+
+	return known
+}
+
+// resolve follows reference to document component if object is one
+func resolve(doc, object map[string]interface{}) map[string]interface{} {
+	ref, ok := object["$ref"].(string)
+	if !ok {
+		return object
+	}
+
+	var resolved interface{} = doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		resolved = resolved.(map[string]interface{})[part]
+	}
+
+	return resolved.(map[string]interface{})
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(openAPISpec), &doc))
+	paths := doc["paths"].(map[string]interface{})
+
+	h := &handler{}
+	for _, r := range h.routes() {
+		require.Contains(t, paths, r.pattern)
+		require.Contains(t, paths[r.pattern], strings.ToLower(r.method), r.pattern)
+	}
+
+	// every documented operation is served by the route of its path, under legacy prefix too
+	router := h.router()
+	for path, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			concrete := strings.NewReplacer("{short}", "jnegYbw", "{id}", "rule", "{name}", "spring", "{path...}", "docs/intro").Replace(path)
+			r, _, _ := router.lookup(strings.ToUpper(method), concrete)
+			require.Equal(t, strings.ToUpper(method), r.method, concrete)
+			require.Equal(t, path, r.pattern, concrete)
+
+			if strings.HasPrefix(path, apiPrefix+"/") {
+				legacy := legacyAPIPrefix + strings.TrimPrefix(concrete, apiPrefix)
+				r, _, _ = router.lookup(strings.ToUpper(method), legacy)
+				require.Equal(t, legacyAPIPrefix+strings.TrimPrefix(path, apiPrefix), r.pattern, legacy)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_Operations(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	srv, err := New(logger, store, WithTokens("root:admin:secret"))
+	require.NoError(t, err)
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.SetRequestURI(openAPIPath)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(srv.httpServer.Handler, req, res)
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, contentTypeJSON, string(res.Header.ContentType()))
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(res.Body(), &doc))
+	require.Equal(t, "3.0.3", doc["openapi"])
+
+	paths := doc["paths"].(map[string]interface{})
+	var names []string
+	for path := range paths {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+
+	for _, path := range names {
+		for method, o := range paths[path].(map[string]interface{}) {
+			op := o.(map[string]interface{})
+			name := strings.ToUpper(method) + " " + path
+
+			link := storage.Link{URL: "https://example.com/" + strconv.Itoa(len(name))}
+			link.Passthrough = strings.Contains(path, "{path...}")
+			if op["operationId"] == "setVariantWeights" {
+				link.Variants = []storage.Variant{{URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b", Weight: 1}}
+			}
+			short, err := store.SaveLink(0, link)
+			require.NoError(t, err)
+			if op["operationId"] == "revertLink" {
+				_, err = store.UpdateURL(0, short, "https://example.com/v2", "root")
+				require.NoError(t, err)
+			}
+
+			template := ""
+			if strings.Contains(path, "{name}") {
+				template = "campaign-" + strings.ToLower(method)
+				_, err = store.PutQueryTemplate(0, "root", storage.QueryTemplate{Name: template})
+				require.NoError(t, err)
+			}
+
+			id := ""
+			if strings.Contains(path, "{id}") {
+				rule, err := policy.NewRule(policy.ActionBlock, "blocked.example", false)
+				require.NoError(t, err)
+				rule, err = store.AddPolicyRule(0, "root", rule)
+				require.NoError(t, err)
+				id = rule.ID
+			}
+
+			req := fasthttp.AcquireRequest()
+			req.Header.SetMethod(strings.ToUpper(method))
+			req.Header.SetHost("dab")
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set(fasthttp.HeaderAccept, contentTypeJSON)
+
+			uri := strings.NewReplacer("{short}", short, "{id}", id, "{name}", template).Replace(path)
+			params, _ := op["parameters"].([]interface{})
+			for _, p := range params {
+				param := resolve(doc, p.(map[string]interface{}))
+				if example, ok := param["example"]; ok && param["in"] == "path" {
+					uri = strings.Replace(uri, "{"+param["name"].(string)+"...}", fmt.Sprint(example), 1)
+				}
+			}
+			req.SetRequestURI(uri)
+
+			for _, p := range params {
+				param := resolve(doc, p.(map[string]interface{}))
+				if example, ok := param["example"]; ok && param["in"] == "query" {
+					req.URI().QueryArgs().Add(param["name"].(string), fmt.Sprint(example))
+				}
+			}
+
+			// bodies of other media types are left out
+			var content map[string]interface{}
+			if body, ok := op["requestBody"].(map[string]interface{}); ok {
+				content = resolve(doc, body)["content"].(map[string]interface{})
+			}
+			if media, ok := content[contentTypeJSON].(map[string]interface{}); ok {
+				example, err := json.Marshal(media["example"])
+				require.NoError(t, err)
+				require.NoError(t, checkSchema(doc, media["schema"].(map[string]interface{}), media["example"], name+" example", false))
+
+				req.Header.SetContentType(contentTypeJSON)
+				req.SetBody(example)
+			}
+
+			res := fasthttp.AcquireResponse()
+
+			err = serve(srv.httpServer.Handler, req, res)
+			require.NoError(t, err)
+
+			status := res.StatusCode()
+			require.Less(t, status, fasthttp.StatusBadRequest, "%s: %s", name, res.Body())
+
+			responses := op["responses"].(map[string]interface{})
+			require.Contains(t, responses, strconv.Itoa(status), name)
+			response := resolve(doc, responses[strconv.Itoa(status)].(map[string]interface{}))
+
+			content, ok := response["content"].(map[string]interface{})
+			if !ok {
+				require.Empty(t, res.Body(), name)
+				continue
+			}
+
+			media := mediaType(res.Header.ContentType())
+			require.Contains(t, content, media, name)
+			if media != contentTypeJSON {
+				continue
+			}
+
+			var value interface{}
+			require.NoError(t, json.Unmarshal(res.Body(), &value), name)
+			schema := content[media].(map[string]interface{})["schema"].(map[string]interface{})
+			require.NoError(t, checkSchema(doc, schema, value, name, false))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"strings"
+)
+
+// passthroughURL appends extra path of short link request to target path and adds request query parameters
+// target does not set. Parameters of target win conflicts, so clicks can not override choices of link owner.
+// Extra path segments are escaped one by one, target fragment stays at the end
+func passthroughURL(target, extraPath string, query *fasthttp.Args) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	changed := false
+
+	if extraPath != "" {
+		segments := strings.Split(extraPath, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+
+		escaped := u.EscapedPath()
+		if !strings.HasSuffix(escaped, "/") {
+			escaped += "/"
+		}
+		escaped += strings.Join(segments, "/")
+
+		if u.Path, err = url.PathUnescape(escaped); err != nil {
+			return "", err
+		}
+		u.RawPath = escaped
+		changed = true
+	}
+
+	// malformed pairs of target query are skipped, the query itself is kept as is
+	fixed, _ := url.ParseQuery(u.RawQuery)
+
+	var extra []string
+	query.VisitAll(func(key, value []byte) {
+		if len(key) == 0 {
+			return
+		}
+		if _, ok := fixed[string(key)]; ok {
+			return
+		}
+		extra = append(extra, url.QueryEscape(string(key))+"="+url.QueryEscape(string(value)))
+	})
+	if len(extra) > 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += strings.Join(extra, "&")
+		changed = true
+	}
+
+	// stored URLs are normalized, so they are kept as is unless something is passed through
+	if !changed {
+		return target, nil
+	}
+
+	return u.String(), nil
+}
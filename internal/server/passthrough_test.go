@@ -0,0 +1,111 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestPassthroughURL(t *testing.T) {
+	for _, tc := range []struct {
+		target    string
+		extraPath string
+		query     string
+		expected  string
+	}{
+		{"https://example.com/docs", "", "", "https://example.com/docs"},
+		{"https://example.com/docs", "intro/setup", "", "https://example.com/docs/intro/setup"},
+		{"https://example.com/docs/", "intro", "", "https://example.com/docs/intro"},
+		{"https://example.com", "intro", "", "https://example.com/intro"},
+		{"https://example.com/docs", "a b/c?d", "", "https://example.com/docs/a%20b/c%3Fd"},
+		{"https://example.com/docs#top", "intro", "", "https://example.com/docs/intro#top"},
+		{"https://example.com/docs", "", "utm_source=mail&page=2", "https://example.com/docs?utm_source=mail&page=2"},
+		// parameters of target win conflicts
+		{"https://example.com/docs?utm_source=site", "intro", "utm_source=mail&page=2", "https://example.com/docs/intro?utm_source=site&page=2"},
+		{"https://example.com/docs?utm_source=site", "", "utm_source=mail", "https://example.com/docs?utm_source=site"},
+		{"https://example.com/docs", "", "q=a+b%26c", "https://example.com/docs?q=a+b%26c"},
+	} {
+		query := fasthttp.AcquireArgs()
+		query.Parse(tc.query)
+
+		u, err := passthroughURL(tc.target, tc.extraPath, query)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, u, tc.target+" "+tc.extraPath+" "+tc.query)
+
+		fasthttp.ReleaseArgs(query)
+	}
+}
+
+func TestGetUrl_Passthrough(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	plain, err := store.SaveURL(0, "https://example.com/plain?ref=site")
+	require.NoError(t, err)
+
+	passthrough, err := store.SaveLink(0, storage.Link{
+		URL:            "https://example.com/docs?ref=site",
+		RedirectPolicy: storage.RedirectPolicy{Passthrough: true},
+	})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		uri      string
+		status   int
+		code     string
+		location string
+	}{
+		{"/" + plain + "?utm_source=mail", fasthttp.StatusMovedPermanently, "", "https://example.com/plain?ref=site"},
+		{"/" + plain + "/intro", fasthttp.StatusNotFound, "not_found", ""},
+		{"/" + passthrough, fasthttp.StatusMovedPermanently, "", "https://example.com/docs?ref=site"},
+		{"/" + passthrough + "?utm_source=mail&ref=mail", fasthttp.StatusMovedPermanently, "", "https://example.com/docs?ref=site&utm_source=mail"},
+		{"/" + passthrough + "/intro/setup?page=2", fasthttp.StatusMovedPermanently, "", "https://example.com/docs/intro/setup?ref=site&page=2"},
+		{"/" + passthrough + "/a%20b", fasthttp.StatusMovedPermanently, "", "https://example.com/docs/a%20b?ref=site"},
+		{"/abcdefg/intro", fasthttp.StatusNotFound, "code_not_found", ""},
+		{"/short/intro", fasthttp.StatusNotFound, "not_found", ""},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.SetRequestURI(tc.uri)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.uri)
+		require.Equal(t, tc.location, string(res.Header.Peek(fasthttp.HeaderLocation)), tc.uri)
+		if tc.code != "" {
+			require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"), tc.uri)
+		}
+	}
+
+	// requests with extra path of links not passing it through are not counted
+	clicks, err := store.LinkClicks(0, plain)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), clicks.Total)
+
+	clicks, err = store.LinkClicks(0, passthrough)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), clicks.Total)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"bytes"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"html/template"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// headerLinkPassword is a request header API clients send password of protected link in
+const headerLinkPassword = "X-Link-Password"
+
+// defaults of failed password attempts limit
+const (
+	defaultPasswordAttempts       = 5
+	defaultPasswordAttemptsWindow = time.Minute
+)
+
+// minAttemptsSweep is number of keys limiter holds before it drops windows that are over for the first time
+const minAttemptsSweep = 64
+
+// attemptLimiter limits failed attempts per key within fixed time windows
+type attemptLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	failures map[string]*attempts
+	// sweepAt is number of keys windows that are over are dropped at, it doubles number of keys kept by the last sweep
+	sweepAt int
+}
+
+// attempts defines failed attempts of key in window started at start
+type attempts struct {
+	start time.Time
+	count int
+}
+
+// newAttemptLimiter constructs limiter allowing limit failed attempts per key within window
+func newAttemptLimiter(limit int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		limit:    limit,
+		window:   window,
+		failures: map[string]*attempts{},
+		sweepAt:  minAttemptsSweep,
+	}
+}
+
+// reserve counts attempt of key before it is checked, so concurrent attempts can not exceed the limit.
+// It returns start of the window attempt is counted in and how long attempts of key are blocked for,
+// zero if the attempt is allowed. Attempts which turn out not to be failures are given back with refund
+func (l *attemptLimiter) reserve(key string, now time.Time) (time.Time, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.failures[key]
+	if ok && !now.Before(a.start.Add(l.window)) {
+		delete(l.failures, key)
+		ok = false
+	}
+	if !ok {
+		l.sweep(now)
+		a = &attempts{start: now}
+		l.failures[key] = a
+	}
+
+	if a.count >= l.limit {
+		return a.start, a.start.Add(l.window).Sub(now)
+	}
+	a.count++
+
+	return a.start, 0
+}
+
+// refund gives back attempt of key reserved in window started at start
+func (l *attemptLimiter) refund(key string, start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.failures[key]
+	if !ok || !a.start.Equal(start) || a.count == 0 {
+		return
+	}
+
+	a.count--
+	if a.count == 0 {
+		delete(l.failures, key)
+	}
+}
+
+// sweep drops windows that are over once number of keys reaches sweepAt, so keys of abandoned attempts
+// do not pile up while sweeps stay rare
+func (l *attemptLimiter) sweep(now time.Time) {
+	if len(l.failures) < l.sweepAt {
+		return
+	}
+
+	for k, a := range l.failures {
+		if !now.Before(a.start.Add(l.window)) {
+			delete(l.failures, k)
+		}
+	}
+
+	l.sweepAt = 2 * len(l.failures)
+	if l.sweepAt < minAttemptsSweep {
+		l.sweepAt = minAttemptsSweep
+	}
+}
+
+// passwordPage renders password form of protected link for browsers
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Protected link {{.Short}}</title>
+</head>
+<body>
+<h1>Protected link {{.Short}}</h1>
+<p>{{.Message}}</p>
+<form method="post">
+<input type="password" name="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// writePasswordForm writes password form as HTML page for clients preferring HTML (e.g. browsers)
+// and error response for others
+func writePasswordForm(ctx *fasthttp.RequestCtx, short string, e apiError) {
+	accept := string(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+	if negotiate(accept, contentTypeProblem, contentTypeJSON, "text/html") != "text/html" {
+		writeError(ctx, e, "")
+		return
+	}
+
+	var body bytes.Buffer
+	_ = passwordPage.Execute(&body, struct {
+		Short   string
+		Message string
+	}{short, e.title})
+
+	ctx.SetStatusCode(e.status)
+	ctx.SetContentType(contentTypeHTML)
+	ctx.SetBody(body.Bytes())
+}
+
+// unlock checks password of protected link sent in "X-Link-Password" header or in "password" field of form
+// posted by password page. It writes error response or password form and returns false unless password matches
+func (h *handler) unlock(ctx *fasthttp.RequestCtx, short string) bool {
+	password := ctx.Request.Header.Peek(headerLinkPassword)
+	if len(password) == 0 && ctx.IsPost() {
+		password = ctx.PostArgs().Peek("password")
+	}
+
+	if len(password) == 0 {
+		writePasswordForm(ctx, short, errPasswordRequired)
+		return false
+	}
+
+	// attempt is counted before password is checked and given back unless password is wrong,
+	// so concurrent guesses can not pass the limit while the check runs
+	start, wait := h.passwordAttempts.reserve(short, h.Storage.Now())
+	if wait > 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(ctx, errPasswordAttempts, "")
+		return false
+	}
+
+	err := h.Storage.CheckPassword(ctx.ID(), short, string(password))
+	if errors.Is(err, storage.ErrWrongPassword) {
+		writePasswordForm(ctx, short, errPasswordWrong)
+		return false
+	}
+	h.passwordAttempts.refund(short, start)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return false
+	}
+
+	return true
+}
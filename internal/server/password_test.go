@@ -0,0 +1,307 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	l := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	start, wait := l.reserve("jnegYbw", now)
+	require.Zero(t, wait)
+	require.Equal(t, now, start)
+
+	// refunded attempts are not counted
+	l.refund("jnegYbw", start)
+	require.Empty(t, l.failures)
+
+	_, wait = l.reserve("jnegYbw", now)
+	require.Zero(t, wait)
+
+	_, wait = l.reserve("jnegYbw", now.Add(10*time.Second))
+	require.Zero(t, wait)
+
+	_, wait = l.reserve("jnegYbw", now.Add(20*time.Second))
+	require.Equal(t, 40*time.Second, wait)
+	// attempts are limited per key
+	_, wait = l.reserve("Yqb9r0m", now.Add(20*time.Second))
+	require.Zero(t, wait)
+
+	start, wait = l.reserve("jnegYbw", now.Add(time.Minute))
+	require.Zero(t, wait)
+	require.Equal(t, now.Add(time.Minute), start)
+
+	// refunds of windows that are over do not touch new ones
+	l.refund("jnegYbw", now)
+	require.Equal(t, 1, l.failures["jnegYbw"].count)
+
+	// windows that are over are dropped once keys pile up
+	l = newAttemptLimiter(2, time.Minute)
+	for i := 0; i < minAttemptsSweep; i++ {
+		l.reserve(strconv.Itoa(i), now)
+	}
+	require.Len(t, l.failures, minAttemptsSweep)
+
+	l.reserve("jnegYbw", now.Add(2*time.Minute))
+	require.Len(t, l.failures, 1)
+	require.Equal(t, minAttemptsSweep, l.sweepAt)
+}
+
+func TestAttemptLimiter_Concurrent(t *testing.T) {
+	l := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	var (
+		wg      sync.WaitGroup
+		allowed int32
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, wait := l.reserve("jnegYbw", now); wait == 0 {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(2), allowed)
+}
+
+func TestGetUrl_Password(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	// attempts are limited by storage clock, so time left to wait does not depend on test speed
+	now := time.Now()
+	store.SetClock(func() time.Time { return now })
+
+	short, err := store.SaveLink(0, storage.Link{URL: "https://example.com/internal", Password: "s3cr3t"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:           logger,
+		Storage:          store,
+		passwordAttempts: newAttemptLimiter(2, time.Minute),
+	}
+
+	for _, tc := range []struct {
+		method   string
+		accept   string
+		header   string
+		form     string
+		status   int
+		code     string
+		location string
+	}{
+		{"GET", contentTypeJSON, "", "", fasthttp.StatusForbidden, "password_required", ""},
+		{"HEAD", contentTypeJSON, "", "", fasthttp.StatusForbidden, "", ""},
+		{"GET", "text/html", "", "", fasthttp.StatusForbidden, "", ""},
+		{"GET", contentTypeJSON, "s3cr3t", "", fasthttp.StatusMovedPermanently, "", "https://example.com/internal"},
+		{"POST", "text/html", "", "password=s3cr3t", fasthttp.StatusSeeOther, "", "https://example.com/internal"},
+		{"POST", "text/html", "", "password=secret", fasthttp.StatusForbidden, "", ""},
+		{"GET", contentTypeJSON, "secret", "", fasthttp.StatusForbidden, "password_wrong", ""},
+		// failed attempts are limited even for the right password
+		{"GET", contentTypeJSON, "s3cr3t", "", fasthttp.StatusTooManyRequests, "password_attempts_exceeded", ""},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(tc.method)
+		req.Header.SetHost("dab")
+		req.Header.Set(fasthttp.HeaderAccept, tc.accept)
+		req.SetRequestURI("/" + short)
+		if tc.header != "" {
+			req.Header.Set(headerLinkPassword, tc.header)
+		}
+		if tc.form != "" {
+			req.Header.SetContentType(contentTypeForm)
+			req.SetBodyString(tc.form)
+		}
+
+		res := fasthttp.AcquireResponse()
+		if tc.method == "HEAD" {
+			res.SkipBody = true
+		}
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		name := tc.method + " " + tc.header + tc.form
+		require.Equal(t, tc.status, res.StatusCode(), name)
+		require.Equal(t, tc.location, string(res.Header.Peek(fasthttp.HeaderLocation)), name)
+		if tc.code != "" {
+			require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"), name)
+		}
+		if tc.accept == "text/html" && tc.status == fasthttp.StatusForbidden {
+			require.Contains(t, string(res.Body()), `<form method="post">`, name)
+		}
+		if tc.location != "" {
+			// redirects of protected links are never cached
+			require.Equal(t, "no-store, max-age=0", string(res.Header.Peek(fasthttp.HeaderCacheControl)), name)
+		}
+		if tc.status == fasthttp.StatusTooManyRequests {
+			require.Equal(t, "60", string(res.Header.Peek(fasthttp.HeaderRetryAfter)))
+		}
+	}
+
+	// only redirects are counted as clicks
+	clicks, err := store.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), clicks.Total)
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set(fasthttp.HeaderAccept, contentTypeJSON)
+	req.SetRequestURI("/preview/" + short)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.True(t, fastjson.GetBool(res.Body(), "protected"))
+	require.False(t, fastjson.Exists(res.Body(), "url"))
+
+	// attempts are allowed again once window is over
+	now = now.Add(time.Minute)
+
+	req.SetRequestURI("/" + short)
+	req.Header.Set(headerLinkPassword, "s3cr3t")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusMovedPermanently, res.StatusCode())
+}
+
+func TestGetUrl_PasswordConcurrent(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveLink(0, storage.Link{URL: "https://example.com/internal", Password: "s3cr3t"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:           logger,
+		Storage:          store,
+		passwordAttempts: newAttemptLimiter(2, time.Minute),
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := fasthttp.AcquireRequest()
+			req.Header.SetMethod("GET")
+			req.Header.SetHost("dab")
+			req.Header.Set(fasthttp.HeaderAccept, contentTypeJSON)
+			req.Header.Set(headerLinkPassword, "secret")
+			req.SetRequestURI("/" + short)
+
+			res := fasthttp.AcquireResponse()
+
+			err := serve(h.router().dispatch, req, res)
+			require.NoError(t, err)
+
+			mu.Lock()
+			statuses[res.StatusCode()]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// guesses sent in parallel are limited as well
+	require.Equal(t, map[int]int{fasthttp.StatusForbidden: 2, fasthttp.StatusTooManyRequests: 48}, statuses)
+}
+
+func TestSaveUrl_Password(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{contentTypeJSON, `{"url":"https://example.com/","password":"s3cr3t"}`, fasthttp.StatusOK},
+		{contentTypeForm, "url=https%3A%2F%2Fexample.com%2F&password=s3cr3t", fasthttp.StatusOK},
+		{contentTypeJSON, `{"url":"https://example.com/","password":"` + strings.Repeat("x", 73) + `"}`, fasthttp.StatusBadRequest},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType(tc.contentType)
+		req.Header.Set(fasthttp.HeaderAccept, contentTypeJSON)
+		req.SetRequestURI("/api/v1/shorten")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.saveURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.body)
+		require.NotContains(t, string(res.Body()), "s3cr3t")
+		if tc.status == fasthttp.StatusOK {
+			require.True(t, fastjson.GetBool(res.Body(), "protected"))
+		} else {
+			require.Equal(t, "password_invalid", fastjson.GetString(res.Body(), "code"))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"auto/internal/policy"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+)
+
+// policyRulesPath is a path of policy rules endpoints, single rule is addressed by ID after it
+const policyRulesPath = apiPrefix + "/admin/policy/rules"
+
+// ruleResponse defines rule representation returned on rule creation
+type ruleResponse struct {
+	policy.Rule
+	// Disabled holds short forms of links disabled by retroactive rule
+	Disabled []string `json:"disabled"`
+}
+
+// allowed writes error response and returns false if URL is blocked by policy
+func (h *handler) allowed(ctx *fasthttp.RequestCtx, url string) bool {
+	if h.policy == nil {
+		return true
+	}
+
+	decision := h.policy.Evaluate(url)
+	if !decision.Allowed {
+		writeError(ctx, errURLBlocked, "URL is blocked by rule "+decision.Rule.ID)
+		return false
+	}
+
+	return true
+}
+
+// enforce disables existing links blocked by newly added retroactive rule on behalf of the rule
+func (h *handler) enforce(reqID uint64, rule policy.Rule) ([]string, error) {
+	if rule.Action != policy.ActionBlock || !rule.Retroactive {
+		return []string{}, nil
+	}
+
+	return h.Storage.DisableMatching(reqID, "policy:"+rule.ID, func(url string) bool {
+		// allow rules still exempt links matched by new block rule
+		return rule.MatchURL(url) && !h.policy.Evaluate(url).Allowed
+	})
+}
+
+// enforceFileRule enforces rule added to policy file unless the rule has been enforced already, even before restart,
+// so links re-enabled after enforcement are not disabled again
+func (h *handler) enforceFileRule(rule policy.Rule) error {
+	if rule.Action != policy.ActionBlock || !rule.Retroactive {
+		return nil
+	}
+
+	enforced, err := h.Storage.RuleEnforced(0, rule)
+	if err != nil || enforced {
+		return err
+	}
+
+	if _, err := h.enforce(0, rule); err != nil {
+		return err
+	}
+
+	return h.Storage.SetRuleEnforced(0, rule)
+}
+
+// listRules handles HTTP requests on "GET /api/admin/policy/rules" endpoint. Lists file rules followed by runtime ones
+func (h *handler) listRules(ctx *fasthttp.RequestCtx) {
+	if _, ok := h.authorize(ctx, roleAdmin); !ok {
+		return
+	}
+
+	body, _ := json.Marshal(struct {
+		Rules []policy.Rule `json:"rules"`
+	}{h.policy.Rules()})
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBody(body)
+}
+
+// addRule handles HTTP requests on "POST /api/admin/policy/rules" endpoint.
+// Retroactive block rule disables existing links it blocks
+func (h *handler) addRule(ctx *fasthttp.RequestCtx) {
+	p, ok := h.authorize(ctx, roleAdmin)
+	if !ok {
+		return
+	}
+
+	body := ctx.PostBody()
+	rule, err := policy.NewRule(
+		policy.Action(fastjson.GetString(body, "action")),
+		fastjson.GetString(body, "pattern"),
+		fastjson.GetBool(body, "retroactive"),
+	)
+	if err != nil {
+		writeError(ctx, errRuleInvalid, err.Error())
+		return
+	}
+
+	rule, err = h.Storage.AddPolicyRule(ctx.ID(), p.name, rule)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	if err := h.reloadRuntimeRules(ctx.ID()); err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	disabled, err := h.enforce(ctx.ID(), rule)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	res, _ := json.Marshal(ruleResponse{Rule: rule, Disabled: disabled})
+
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBody(res)
+}
+
+// removeRule handles HTTP requests on "DELETE /api/admin/policy/rules/{id}" endpoint.
+// Only runtime rules can be removed, file rules are changed by editing the file
+func (h *handler) removeRule(ctx *fasthttp.RequestCtx, id string) {
+	p, ok := h.authorize(ctx, roleAdmin)
+	if !ok {
+		return
+	}
+
+	if err := h.Storage.RemovePolicyRule(ctx.ID(), p.name, id); err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	if err := h.reloadRuntimeRules(ctx.ID()); err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// reloadRuntimeRules passes stored runtime rules to policy engine
+func (h *handler) reloadRuntimeRules(reqID uint64) error {
+	rules, err := h.Storage.PolicyRules(reqID)
+	if err != nil {
+		return err
+	}
+
+	h.policy.SetRuntime(rules)
+
+	return nil
+}
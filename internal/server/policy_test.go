@@ -0,0 +1,187 @@
+package server
+
+import (
+	"auto/internal/policy"
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestManagePolicy(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	phishing, err := store.SaveURL(0, "https://login.evil.com/")
+	require.NoError(t, err)
+
+	exempt, err := store.SaveURL(0, "https://good.evil.com/")
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"root:admin:admin-secret", "bob:editor:editor-secret"})
+	require.NoError(t, err)
+
+	engine, err := policy.New(logger, "")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+		policy:     engine,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer editor-secret")
+	req.SetRequestURI("/api/admin/policy/rules")
+	req.SetBody([]byte(`{"action":"allow","pattern":"good.evil.com"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusForbidden, res.StatusCode())
+
+	req.Header.Set("Authorization", "Bearer admin-secret")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusCreated, res.StatusCode())
+	require.Equal(t, "domain", fastjson.GetString(res.Body(), "kind"))
+
+	req.SetBody([]byte(`{"action":"block","pattern":"*.evil.com","retroactive":true}`))
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusCreated, res.StatusCode())
+	id := fastjson.GetString(res.Body(), "id")
+	require.Equal(t, "root", fastjson.GetString(res.Body(), "creator"))
+	// allow rule keeps exempt link redirecting
+	require.Equal(t, phishing, fastjson.GetString(res.Body(), "disabled", "0"))
+	require.False(t, fastjson.Exists(res.Body(), "disabled", "1"))
+
+	_, err = store.GetURL(0, phishing)
+	require.Equal(t, storage.ErrShortGone, err)
+
+	_, err = store.GetURL(0, exempt)
+	require.NoError(t, err)
+
+	saveReq := fasthttp.AcquireRequest()
+	saveReq.Header.SetMethod("POST")
+	saveReq.Header.SetHost("dab")
+	saveReq.Header.SetContentType("application/json")
+	saveReq.SetRequestURI("/api/shorten")
+	saveReq.SetBody([]byte(`{"url":"https://WWW.Evil.com/login"}`))
+
+	saveRes := fasthttp.AcquireResponse()
+
+	err = serve(h.saveURL, saveReq, saveRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusForbidden, saveRes.StatusCode())
+	require.Equal(t, "url_blocked", fastjson.GetString(saveRes.Body(), "code"))
+	require.Equal(t, "URL is blocked by rule "+id, fastjson.GetString(saveRes.Body(), "detail"))
+
+	listReq := fasthttp.AcquireRequest()
+	listReq.Header.SetMethod("GET")
+	listReq.Header.SetHost("dab")
+	listReq.Header.Set("Authorization", "Bearer admin-secret")
+	listReq.SetRequestURI("/api/admin/policy/rules")
+
+	err = serve(h.router().dispatch, listReq, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, id, fastjson.GetString(res.Body(), "rules", "1", "id"))
+	require.Equal(t, "api", fastjson.GetString(res.Body(), "rules", "1", "source"))
+
+	deleteReq := fasthttp.AcquireRequest()
+	deleteReq.Header.SetMethod("DELETE")
+	deleteReq.Header.SetHost("dab")
+	deleteReq.Header.Set("Authorization", "Bearer admin-secret")
+	deleteReq.SetRequestURI("/api/admin/policy/rules/" + id)
+
+	err = serve(h.router().dispatch, deleteReq, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNoContent, res.StatusCode())
+
+	err = serve(h.router().dispatch, deleteReq, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
+	require.Equal(t, "rule_not_found", fastjson.GetString(res.Body(), "code"))
+
+	err = serve(h.saveURL, saveReq, saveRes)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, saveRes.StatusCode())
+}
+
+func TestManagePolicy_InvalidRule(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"root:admin:secret"})
+	require.NoError(t, err)
+
+	engine, err := policy.New(logger, "")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+		policy:     engine,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.SetRequestURI("/api/admin/policy/rules")
+	req.SetBody([]byte(`{"action":"block","pattern":"/(/"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+	require.Equal(t, "rule_invalid", fastjson.GetString(res.Body(), "code"))
+
+	req.Header.SetMethod("PUT")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusMethodNotAllowed, res.StatusCode())
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"bytes"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"html/template"
+	"net/url"
+	"time"
+)
+
+// previewPrefix is a path prefix of link preview pages, "/{short}+" paths are previews too
+const previewPrefix = "/preview/"
+
+// previewResponse defines link representation shown instead of redirecting
+type previewResponse struct {
+	Short string `json:"short"`
+	// URL and Domain are hidden for links that do not redirect, are not active yet and for protected ones.
+	// Status is effective one, so expired and used up links are not reported active
+	URL       string             `json:"url,omitempty"`
+	Domain    string             `json:"domain,omitempty"`
+	Status    storage.LinkStatus `json:"status"`
+	Protected bool               `json:"protected,omitempty"`
+	// Scheduled is set for links whose activation time has not come yet
+	Scheduled bool      `json:"scheduled,omitempty"`
+	Redirect  int       `json:"redirect,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// previewPage renders link preview for browsers
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Preview of {{.Short}}</title>
+</head>
+<body>
+<h1>Preview of {{.Short}}</h1>
+<dl>
+{{- if .URL}}
+<dt>Destination</dt><dd><code>{{.URL}}</code></dd>
+<dt>Domain</dt><dd><strong>{{.Domain}}</strong></dd>
+{{- end}}
+<dt>Status</dt><dd>{{.Status}}</dd>
+{{- if not .CreatedAt.IsZero}}
+<dt>Created</dt><dd>{{.CreatedAt.Format "2006-01-02 15:04 MST"}}</dd>
+{{- end}}
+</dl>
+{{- if .URL}}
+<p><a href="{{.URL}}" rel="noopener noreferrer nofollow">Continue to {{.Domain}}</a></p>
+{{- else if and .Scheduled (eq .Status "active")}}
+<p>This link is not active yet.</p>
+{{- else if and .Protected (eq .Status "active")}}
+<p>This link is protected by password.</p>
+{{- else}}
+<p>This link does not redirect anymore.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// previewLink handles HTTP requests on "GET /preview/{short}" and "GET /{short}+" endpoints.
+// Describes link destination without redirecting as HTML page or as JSON for clients preferring it
+func (h *handler) previewLink(ctx *fasthttp.RequestCtx, short string) {
+	if len(short) != 7 {
+		writeError(ctx, errPathInvalid, "Short link path must be 7 characters long")
+		return
+	}
+
+	link, err := h.Storage.LinkState(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	preview := previewResponse{
+		Short:     short,
+		Status:    link.Status,
+		CreatedAt: link.CreatedAt,
+		UpdatedAt: link.UpdatedAt,
+	}
+	// targets of links which do not redirect are not shown: disabled and deleted ones may have been taken down
+	// because of them and expired and used up ones are not meant to be followed anymore,
+	// targets of protected links are shown to clients knowing password only and targets of scheduled links
+	// are not shown before their launch
+	preview.Protected = link.Protected
+	preview.Scheduled = link.NotBefore != nil && h.Storage.Now().Before(*link.NotBefore)
+	if link.Status == storage.StatusActive && !link.Protected && !preview.Scheduled {
+		preview.URL = link.URL
+		if u, err := url.Parse(link.URL); err == nil {
+			preview.Domain = u.Hostname()
+		}
+
+		preview.Redirect = h.redirectStatus(link)
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+
+	accept := string(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+	if negotiate(accept, "text/html", contentTypeJSON) == contentTypeJSON {
+		body, _ := json.Marshal(preview)
+
+		ctx.SetContentType(contentTypeJSON)
+		ctx.SetBody(body)
+	} else {
+		var body bytes.Buffer
+		_ = previewPage.Execute(&body, preview)
+
+		ctx.SetContentType(contentTypeHTML)
+		ctx.SetBody(body.Bytes())
+	}
+}
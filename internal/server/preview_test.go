@@ -0,0 +1,195 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestPreviewLink(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveLink(0, storage.Link{
+		URL:            "https://example.com/promo?a=<b>",
+		RedirectPolicy: storage.RedirectPolicy{Redirect: 302},
+	})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, path := range []string{"/preview/" + short, "/" + short + "+"} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.Header.Set(fasthttp.HeaderAccept, "text/html,application/xhtml+xml,*/*;q=0.8")
+		req.SetRequestURI(path)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), path)
+		require.Equal(t, contentTypeHTML, string(res.Header.ContentType()))
+		require.Empty(t, res.Header.Peek(fasthttp.HeaderLocation))
+		require.Contains(t, string(res.Body()), "https://example.com/promo?a=&lt;b&gt;")
+		require.Contains(t, string(res.Body()), "<strong>example.com</strong>")
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.Set(fasthttp.HeaderAccept, "application/json")
+	req.SetRequestURI("/preview/" + short)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, contentTypeJSON, string(res.Header.ContentType()))
+	require.Equal(t, short, fastjson.GetString(res.Body(), "short"))
+	require.Equal(t, "https://example.com/promo?a=<b>", fastjson.GetString(res.Body(), "url"))
+	require.Equal(t, "example.com", fastjson.GetString(res.Body(), "domain"))
+	require.Equal(t, "active", fastjson.GetString(res.Body(), "status"))
+	require.Equal(t, 302, fastjson.GetInt(res.Body(), "redirect"))
+	require.NotEmpty(t, fastjson.GetString(res.Body(), "created_at"))
+
+	// disabled links are previewed without their targets
+	_, err = store.Disable(0, short, "bob")
+	require.NoError(t, err)
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "disabled", fastjson.GetString(res.Body(), "status"))
+	require.False(t, fastjson.Exists(res.Body(), "url"))
+	require.False(t, fastjson.Exists(res.Body(), "domain"))
+}
+
+func TestPreviewLink_EffectiveStatus(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Now()
+	store.SetClock(func() time.Time { return now })
+
+	expiresAt := now.Add(time.Hour)
+	expired, err := store.SaveLink(0, storage.Link{URL: "https://example.com/expired", ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	exhausted, err := store.SaveLink(0, storage.Link{URL: "https://example.com/exhausted", MaxClicks: 1})
+	require.NoError(t, err)
+	_, err = store.Click(0, exhausted, "")
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	// links which do not redirect anymore are previewed without their targets
+	for short, status := range map[string]string{expired: "expired", exhausted: "exhausted"} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.Header.Set(fasthttp.HeaderAccept, "application/json")
+		req.SetRequestURI("/preview/" + short)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), short)
+		require.Equal(t, status, fastjson.GetString(res.Body(), "status"))
+		require.False(t, fastjson.Exists(res.Body(), "url"))
+
+		req.Header.Set(fasthttp.HeaderAccept, "text/html")
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), short)
+		require.NotContains(t, string(res.Body()), "Continue to")
+		require.Contains(t, string(res.Body()), "This link does not redirect anymore.")
+	}
+}
+
+func TestPreviewLink_Invalid(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"GET", "/preview/abcdefgh", fasthttp.StatusBadRequest, "path_invalid"},
+		{"GET", "/abcdefg+", fasthttp.StatusNotFound, "code_not_found"},
+		{"POST", "/preview/abcdefg", fasthttp.StatusMethodNotAllowed, "method_not_allowed"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(tc.method)
+		req.Header.SetHost("dab")
+		req.Header.Set(fasthttp.HeaderAccept, "application/json")
+		req.SetRequestURI(tc.path)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.path)
+		require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"))
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"auto/internal/qr"
+	"auto/internal/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+)
+
+// qrSuffix is a path suffix of short link QR code images
+const qrSuffix = "/qr"
+
+// qrContentTypes maps QR code image formats to their content types
+var qrContentTypes = map[qr.Format]string{
+	qr.FormatPNG: "image/png",
+	qr.FormatSVG: "image/svg+xml",
+}
+
+// parseQROptions reads QR code options from "format", "size", "ecc", "margin", "fg" and "bg" query parameters.
+// Omitted parameters keep their defaults
+func parseQROptions(args *fasthttp.Args) (qr.Options, error) {
+	o := qr.DefaultOptions()
+
+	if args.Has("format") {
+		o.Format = qr.Format(strings.ToLower(string(args.Peek("format"))))
+	}
+
+	for name, value := range map[string]*int{"size": &o.Size, "margin": &o.Margin} {
+		if !args.Has(name) {
+			continue
+		}
+
+		n, err := strconv.Atoi(string(args.Peek(name)))
+		if err != nil {
+			return o, fmt.Errorf("%w: %s must be an integer", qr.ErrInvalidOptions, name)
+		}
+		*value = n
+	}
+
+	if args.Has("ecc") {
+		o.Level = strings.ToUpper(string(args.Peek("ecc")))
+	}
+
+	var err error
+	if args.Has("fg") {
+		if o.Foreground, err = qr.ParseColor(string(args.Peek("fg"))); err != nil {
+			return o, err
+		}
+	}
+	if args.Has("bg") {
+		if o.Background, err = qr.ParseColor(string(args.Peek("bg"))); err != nil {
+			return o, err
+		}
+	}
+
+	return o, o.Validate()
+}
+
+// qrETag returns entity tag of QR code image, the image depends on encoded content and options only
+func qrETag(content string, o qr.Options) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%+v", content, o)))
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether If-None-Match header value lists entity tag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// qrCode handles HTTP requests on "GET /{short}/qr" endpoint. Returns PNG or SVG QR code of short link URL
+func (h *handler) qrCode(ctx *fasthttp.RequestCtx, short string) {
+	if len(short) != 7 {
+		writeError(ctx, errPathInvalid, "Short link path must be 7 characters long")
+		return
+	}
+
+	o, err := parseQROptions(ctx.QueryArgs())
+	if err != nil {
+		writeError(ctx, errQRInvalid, err.Error())
+		return
+	}
+
+	// codes of links which do not redirect anymore are not given out, ones of links not active yet are printed
+	// ahead of launch
+	link, err := h.Storage.LinkState(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+	if link.Status != storage.StatusActive {
+		writeStorageError(ctx, storage.ErrShortGone)
+		return
+	}
+
+	content := h.shortURL(ctx, short)
+
+	etag := qrETag(content, o)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "public, no-cache")
+
+	if etagMatches(string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)), etag) {
+		ctx.SetStatusCode(fasthttp.StatusNotModified)
+		return
+	}
+
+	img, err := qr.Encode(content, o)
+	if err != nil {
+		writeError(ctx, errQRInvalid, err.Error())
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(qrContentTypes[o.Format])
+	ctx.SetBody(img)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQROptions(t *testing.T) {
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	args.Parse("format=SVG&size=512&ecc=h&margin=2&fg=336699&bg=fff")
+	o, err := parseQROptions(args)
+	require.NoError(t, err)
+	require.Equal(t, "svg", string(o.Format))
+	require.Equal(t, 512, o.Size)
+	require.Equal(t, "H", o.Level)
+	require.Equal(t, 2, o.Margin)
+	require.Equal(t, uint8(0x66), o.Foreground.G)
+	require.Equal(t, uint8(0xff), o.Background.B)
+
+	for _, query := range []string{"size=big", "size=10", "margin=-1", "ecc=X", "format=gif", "fg=red", "fg=000&bg=000"} {
+		args.Parse(query)
+		_, err = parseQROptions(args)
+		require.Error(t, err, query)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	require.True(t, etagMatches(`"a", "b"`, `"b"`))
+	require.True(t, etagMatches(`W/"b"`, `"b"`))
+	require.True(t, etagMatches(`*`, `"b"`))
+	require.False(t, etagMatches(``, `"b"`))
+	require.False(t, etagMatches(`"a"`, `"b"`))
+}
+
+func TestQRCode(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("sho.rt")
+	req.SetRequestURI("/" + short + "/qr?size=300")
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "image/png", string(res.Header.ContentType()))
+
+	img, err := png.Decode(bytes.NewReader(res.Body()))
+	require.NoError(t, err)
+	require.Equal(t, 300, img.Bounds().Dx())
+
+	etag := string(res.Header.Peek(fasthttp.HeaderETag))
+	require.NotEmpty(t, etag)
+
+	// the same code is not sent again
+	req.Header.Set(fasthttp.HeaderIfNoneMatch, etag)
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotModified, res.StatusCode())
+	require.Empty(t, res.Body())
+
+	// other options make other image
+	req.SetRequestURI("/" + short + "/qr?format=svg")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, "image/svg+xml", string(res.Header.ContentType()))
+	require.True(t, strings.HasPrefix(string(res.Body()), "<svg"))
+	require.NotEqual(t, etag, string(res.Header.Peek(fasthttp.HeaderETag)))
+}
+
+func TestQRCode_Status(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	notBefore := time.Now().Add(time.Hour)
+	scheduled, err := store.SaveLink(0, storage.Link{URL: "https://example.com/launch", NotBefore: &notBefore})
+	require.NoError(t, err)
+
+	disabled, err := store.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+	_, err = store.Disable(0, disabled, "bob")
+	require.NoError(t, err)
+
+	exhausted, err := store.SaveLink(0, storage.Link{URL: "https://example.com/once", MaxClicks: 1})
+	require.NoError(t, err)
+	_, err = store.Click(0, exhausted, "")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		short  string
+		status int
+	}{
+		// codes of scheduled links are printed ahead of launch
+		{scheduled, fasthttp.StatusOK},
+		{disabled, fasthttp.StatusGone},
+		{exhausted, fasthttp.StatusGone},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("sho.rt")
+		req.SetRequestURI("/" + tc.short + "/qr")
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.short)
+	}
+}
+
+func TestQRCode_Invalid(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	disabled, err := store.SaveURL(0, "https://example.com/disabled")
+	require.NoError(t, err)
+	_, err = store.Disable(0, disabled, "bob")
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/" + short + "/qr?size=huge", fasthttp.StatusBadRequest, "qr_invalid"},
+		{"/abcdefgh/qr", fasthttp.StatusBadRequest, "path_invalid"},
+		{"/abcdefg/qr", fasthttp.StatusNotFound, "code_not_found"},
+		{"/" + disabled + "/qr", fasthttp.StatusGone, "code_gone"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("sho.rt")
+		req.SetRequestURI(tc.path)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.path)
+		require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"))
+	}
+}
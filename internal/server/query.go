@@ -0,0 +1,87 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"github.com/rs/xid"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// expandQuery returns query parameters with placeholders replaced by short form of the link
+// and unique ID generated for the click
+func expandQuery(params map[string]string, short string) map[string]string {
+	var clickID string
+
+	expanded := make(map[string]string, len(params))
+	for name, value := range params {
+		if clickID == "" && strings.Contains(value, storage.PlaceholderClickID) {
+			clickID = xid.New().String()
+		}
+
+		expanded[name] = strings.NewReplacer(
+			storage.PlaceholderCode, short,
+			storage.PlaceholderClickID, clickID,
+		).Replace(value)
+	}
+
+	return expanded
+}
+
+// hasClickID reports whether query parameters hold click ID placeholder, redirects to them must not be cached
+// as every cached one would hand the same click ID out
+func hasClickID(params map[string]string) bool {
+	for _, value := range params {
+		if strings.Contains(value, storage.PlaceholderClickID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// queryURL sets query parameters on target replacing ones target already has.
+// Other parameters of target keep their order and encoding, set ones follow them sorted by name
+func queryURL(target string, params map[string]string) (string, error) {
+	if len(params) == 0 {
+		return target, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	var pairs []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key := pair
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key = pair[:i]
+		}
+		if name, err := url.QueryUnescape(key); err == nil {
+			if _, ok := params[name]; ok {
+				continue
+			}
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pairs = append(pairs, url.QueryEscape(name)+"="+url.QueryEscape(params[name]))
+	}
+
+	u.RawQuery = strings.Join(pairs, "&")
+
+	return u.String(), nil
+}
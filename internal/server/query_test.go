@@ -0,0 +1,200 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+	"net/url"
+	"testing"
+)
+
+func TestExpandQuery(t *testing.T) {
+	params := expandQuery(map[string]string{
+		"utm_source": "mail",
+		"ref":        "{code}",
+		"click":      "{click_id}",
+		"again":      "{code}-{click_id}",
+	}, "jnegYbw")
+
+	require.Equal(t, "mail", params["utm_source"])
+	require.Equal(t, "jnegYbw", params["ref"])
+	require.NotEmpty(t, params["click"])
+	// every placeholder of one click is replaced with the same ID
+	require.Equal(t, "jnegYbw-"+params["click"], params["again"])
+
+	other := expandQuery(map[string]string{"click": "{click_id}"}, "jnegYbw")
+	require.NotEqual(t, params["click"], other["click"])
+}
+
+func TestQueryURL(t *testing.T) {
+	for _, tc := range []struct {
+		target   string
+		params   map[string]string
+		expected string
+	}{
+		{"https://example.com/docs?b=2&a=1", nil, "https://example.com/docs?b=2&a=1"},
+		{"https://example.com/docs", map[string]string{"utm_source": "mail", "utm_medium": "email"}, "https://example.com/docs?utm_medium=email&utm_source=mail"},
+		{"https://example.com/docs?b=2&utm_source=site&a=1", map[string]string{"utm_source": "mail"}, "https://example.com/docs?b=2&a=1&utm_source=mail"},
+		{"https://example.com/docs?q=a%20b#top", map[string]string{"ref": "a&b c"}, "https://example.com/docs?q=a%20b&ref=a%26b+c#top"},
+		{"https://example.com/docs?utm_source", map[string]string{"utm_source": ""}, "https://example.com/docs?utm_source="},
+	} {
+		u, err := queryURL(tc.target, tc.params)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, u, tc.target)
+	}
+}
+
+func TestGetUrl_Query(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = store.PutQueryTemplate(0, "bob", storage.QueryTemplate{
+		Name:   "spring",
+		Params: map[string]string{"utm_source": "newsletter", "utm_campaign": "spring"},
+	})
+	require.NoError(t, err)
+
+	short, err := store.SaveLink(0, storage.Link{
+		URL: "https://example.com/sale?utm_source=site&page=1",
+		RedirectPolicy: storage.RedirectPolicy{
+			Passthrough: true,
+			Query:       map[string]string{"utm_content": "{code}", "click": "{click_id}"},
+			Template:    "spring",
+		},
+	})
+	require.NoError(t, err)
+
+	defaultAge := int64(600)
+	h := &handler{
+		logger:   logger,
+		Storage:  store,
+		redirect: storage.RedirectPolicy{MaxAge: &defaultAge},
+	}
+
+	redirect := func() url.Values {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		// request parameters do not override link and template ones
+		req.SetRequestURI("/" + short + "?utm_campaign=other&lang=en")
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+		require.Equal(t, fasthttp.StatusMovedPermanently, res.StatusCode())
+		// every click gets its own ID, so redirects are not cached
+		require.Equal(t, "no-store, max-age=0", string(res.Header.Peek(fasthttp.HeaderCacheControl)))
+
+		u, err := url.Parse(string(res.Header.Peek(fasthttp.HeaderLocation)))
+		require.NoError(t, err)
+		require.Equal(t, "/sale", u.Path)
+
+		return u.Query()
+	}
+
+	query := redirect()
+	require.Equal(t, "newsletter", query.Get("utm_source"))
+	require.Equal(t, "spring", query.Get("utm_campaign"))
+	require.Equal(t, short, query.Get("utm_content"))
+	require.Equal(t, "1", query.Get("page"))
+	require.Equal(t, "en", query.Get("lang"))
+	require.NotEmpty(t, query.Get("click"))
+
+	// campaign change does not require re-creating links
+	_, err = store.PutQueryTemplate(0, "bob", storage.QueryTemplate{
+		Name:   "spring",
+		Params: map[string]string{"utm_campaign": "spring-2"},
+	})
+	require.NoError(t, err)
+
+	next := redirect()
+	require.Equal(t, "site", next.Get("utm_source"))
+	require.Equal(t, "spring-2", next.Get("utm_campaign"))
+	require.NotEqual(t, query.Get("click"), next.Get("click"))
+
+	err = store.RemoveQueryTemplate(0, "bob", "spring")
+	require.NoError(t, err)
+
+	next = redirect()
+	require.Equal(t, "site", next.Get("utm_source"))
+	require.Equal(t, "other", next.Get("utm_campaign"))
+	require.Equal(t, short, next.Get("utm_content"))
+
+	// redirects without click IDs keep cache policy
+	tagged, err := store.SaveLink(0, storage.Link{
+		URL:            "https://example.com/sale",
+		RedirectPolicy: storage.RedirectPolicy{Query: map[string]string{"utm_content": "{code}"}},
+	})
+	require.NoError(t, err)
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/" + tagged)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/sale?utm_content="+tagged, string(res.Header.Peek(fasthttp.HeaderLocation)))
+	require.Equal(t, "public, max-age=600", string(res.Header.Peek(fasthttp.HeaderCacheControl)))
+}
+
+func TestGetUrl_QueryISE(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	err := failpoint.Enable("auto/internal/storage/queryTemplateErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable("auto/internal/storage/queryTemplateErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveLink(0, storage.Link{
+		URL:            "https://example.com/sale",
+		RedirectPolicy: storage.RedirectPolicy{Template: "spring"},
+	})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/" + short)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.getURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusInternalServerError, res.StatusCode())
+}
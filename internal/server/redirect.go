@@ -0,0 +1,175 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"errors"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"strconv"
+	"time"
+)
+
+// parseRedirectPolicy reads optional "redirect", "max_age", "passthrough", "query" and "template" fields of JSON body
+func parseRedirectPolicy(body []byte) (storage.RedirectPolicy, error) {
+	var p storage.RedirectPolicy
+
+	v, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return p, err
+	}
+
+	if field := v.Get("redirect"); field != nil {
+		if p.Redirect, err = field.Int(); err != nil {
+			return p, errors.New("field \"redirect\" must be an integer")
+		}
+	}
+
+	if field := v.Get("max_age"); field != nil {
+		maxAge, err := field.Int64()
+		if err != nil {
+			return p, errors.New("field \"max_age\" must be an integer")
+		}
+		p.MaxAge = &maxAge
+	}
+
+	if field := v.Get("passthrough"); field != nil {
+		if p.Passthrough, err = field.Bool(); err != nil {
+			return p, errors.New("field \"passthrough\" must be a boolean")
+		}
+	}
+
+	if field := v.Get("query"); field != nil {
+		if p.Query, err = parseQueryParams(field); err != nil {
+			return p, err
+		}
+	}
+
+	if field := v.Get("template"); field != nil {
+		template, err := field.StringBytes()
+		if err != nil {
+			return p, errors.New("field \"template\" must be a string")
+		}
+		p.Template = string(template)
+	}
+
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// parseQueryParams reads JSON object of query parameters holding string values
+func parseQueryParams(v *fastjson.Value) (map[string]string, error) {
+	object, err := v.Object()
+	if err != nil {
+		return nil, errors.New("field \"query\" must be an object")
+	}
+
+	params := make(map[string]string, object.Len())
+	object.Visit(func(key []byte, value *fastjson.Value) {
+		if err != nil {
+			return
+		}
+
+		var b []byte
+		if b, err = value.StringBytes(); err != nil {
+			err = fmt.Errorf("query parameter %q must be a string", key)
+			return
+		}
+		params[string(key)] = string(b)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// writeRedirect redirects to link target using link redirect policy falling back to deployment defaults
+func (h *handler) writeRedirect(ctx *fasthttp.RequestCtx, link storage.Link) {
+	status := h.redirectStatus(link)
+	// browsers follow redirects of posted password forms with GET requests
+	if ctx.IsPost() {
+		status = fasthttp.StatusSeeOther
+	}
+
+	maxAge := link.MaxAge
+	if maxAge == nil {
+		maxAge = h.redirect.MaxAge
+	}
+	// cached redirects of protected links would skip password check, ones of split links would keep
+	// visitors from weight changes, ones of click limited links would outlive the limit, clicks of all
+	// of them would be left uncounted and shared caches would serve destination chosen by targeting rules
+	// to other devices
+	if link.Protected || len(link.Variants) > 0 || len(link.Targets) > 0 || link.MaxClicks > 0 {
+		noCache := int64(0)
+		maxAge = &noCache
+	}
+	// cached redirects of expiring links must not outlive them
+	if link.ExpiresAt != nil {
+		left := int64(link.ExpiresAt.Sub(h.Storage.Now()) / time.Second)
+		if left < 0 {
+			left = 0
+		}
+		if maxAge == nil || left < *maxAge {
+			maxAge = &left
+		}
+	}
+	if maxAge != nil {
+		setCacheHeaders(ctx, h.Storage.Now(), *maxAge)
+	}
+
+	// stored URLs are normalized already, so Location is set as is instead of being reparsed by ctx.Redirect
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, link.URL)
+	ctx.SetStatusCode(status)
+}
+
+// redirectStatus returns HTTP status code link redirects with
+func (h *handler) redirectStatus(link storage.Link) int {
+	if link.Redirect != 0 {
+		return link.Redirect
+	}
+	if h.redirect.Redirect != 0 {
+		return h.redirect.Redirect
+	}
+
+	return fasthttp.StatusMovedPermanently
+}
+
+// setCacheHeaders allows caching response for maxAge seconds since now, zero forbids caching
+func setCacheHeaders(ctx *fasthttp.RequestCtx, now time.Time, maxAge int64) {
+	if maxAge == 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-store, max-age=0")
+		ctx.Response.Header.Set(fasthttp.HeaderExpires, string(fasthttp.AppendHTTPDate(nil, time.Unix(0, 0))))
+		return
+	}
+
+	expires := now.Add(time.Duration(maxAge) * time.Second)
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "public, max-age="+strconv.FormatInt(maxAge, 10))
+	ctx.Response.Header.Set(fasthttp.HeaderExpires, string(fasthttp.AppendHTTPDate(nil, expires)))
+}
+
+// setRedirect handles HTTP requests on "PUT /api/links/{short}/redirect" endpoint.
+// Replaces link redirect policy, omitted fields fall back to deployment defaults
+func (h *handler) setRedirect(ctx *fasthttp.RequestCtx, short string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	policy, err := parseRedirectPolicy(ctx.PostBody())
+	if err != nil {
+		writeError(ctx, errRedirectInvalid, err.Error())
+		return
+	}
+
+	link, err := h.Storage.SetRedirect(ctx.ID(), short, policy, p.name)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	h.writeLink(ctx, short, link)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestParseRedirectPolicy(t *testing.T) {
+	p, err := parseRedirectPolicy([]byte(`{"url":"https://example.com/"}`))
+	require.NoError(t, err)
+	require.Equal(t, storage.RedirectPolicy{}, p)
+
+	p, err = parseRedirectPolicy([]byte(`{"redirect":307,"max_age":0}`))
+	require.NoError(t, err)
+	require.Equal(t, 307, p.Redirect)
+	require.Equal(t, int64(0), *p.MaxAge)
+
+	p, err = parseRedirectPolicy([]byte(`{"passthrough":true}`))
+	require.NoError(t, err)
+	require.True(t, p.Passthrough)
+
+	for _, body := range []string{`{"redirect":"302"}`, `{"redirect":200}`, `{"max_age":-5}`, `{"max_age":1.5}`, `{"passthrough":"yes"}`, `[`} {
+		_, err = parseRedirectPolicy([]byte(body))
+		require.Error(t, err, body)
+	}
+}
+
+func TestGetUrl_RedirectPolicy(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	defaultAge := int64(600)
+	shortAge := int64(60)
+	noCache := int64(0)
+
+	plain, err := store.SaveURL(0, "https://example.com/plain")
+	require.NoError(t, err)
+
+	temporary, err := store.SaveLink(0, storage.Link{
+		URL:            "https://example.com/temporary",
+		RedirectPolicy: storage.RedirectPolicy{Redirect: 307, MaxAge: &noCache},
+	})
+	require.NoError(t, err)
+
+	// Expires is stamped by storage clock
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.SetClock(func() time.Time { return now })
+	httpDate := func(t time.Time) string {
+		return string(fasthttp.AppendHTTPDate(nil, t))
+	}
+
+	limited, err := store.SaveLink(0, storage.Link{URL: "https://example.com/limited", MaxClicks: 100})
+	require.NoError(t, err)
+
+	expiresAt := now.Add(100 * time.Second)
+	expiring, err := store.SaveLink(0, storage.Link{URL: "https://example.com/expiring", ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		redirect     storage.RedirectPolicy
+		short        string
+		status       int
+		cacheControl string
+		expires      string
+	}{
+		{storage.RedirectPolicy{}, plain, fasthttp.StatusMovedPermanently, "", ""},
+		{storage.RedirectPolicy{Redirect: 302, MaxAge: &defaultAge}, plain, fasthttp.StatusFound, "public, max-age=600", httpDate(now.Add(600 * time.Second))},
+		{storage.RedirectPolicy{Redirect: 302, MaxAge: &defaultAge}, temporary, fasthttp.StatusTemporaryRedirect, "no-store, max-age=0", httpDate(time.Unix(0, 0))},
+		// click limited links are never cached
+		{storage.RedirectPolicy{}, limited, fasthttp.StatusMovedPermanently, "no-store, max-age=0", httpDate(time.Unix(0, 0))},
+		{storage.RedirectPolicy{MaxAge: &defaultAge}, limited, fasthttp.StatusMovedPermanently, "no-store, max-age=0", httpDate(time.Unix(0, 0))},
+		// expiring links are cached until they expire at most
+		{storage.RedirectPolicy{}, expiring, fasthttp.StatusMovedPermanently, "public, max-age=100", httpDate(expiresAt)},
+		{storage.RedirectPolicy{MaxAge: &defaultAge}, expiring, fasthttp.StatusMovedPermanently, "public, max-age=100", httpDate(expiresAt)},
+		{storage.RedirectPolicy{MaxAge: &shortAge}, expiring, fasthttp.StatusMovedPermanently, "public, max-age=60", httpDate(now.Add(60 * time.Second))},
+	} {
+		h := &handler{
+			logger:   logger,
+			Storage:  store,
+			redirect: tc.redirect,
+		}
+
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.SetRequestURI("/" + tc.short)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.getURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode())
+		require.NotEmpty(t, res.Header.Peek(fasthttp.HeaderLocation))
+		require.Equal(t, tc.cacheControl, string(res.Header.Peek(fasthttp.HeaderCacheControl)))
+		require.Equal(t, tc.expires, string(res.Header.Peek(fasthttp.HeaderExpires)))
+	}
+}
+
+func TestSaveUrl_RedirectPolicy(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI("/api/shorten")
+	req.SetBody([]byte(`{"url":"https://example.com/","redirect":302,"max_age":60}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+
+	link, err := store.GetLink(0, fastjson.GetString(res.Body(), "short"))
+	require.NoError(t, err)
+	require.Equal(t, 302, link.Redirect)
+	require.Equal(t, int64(60), *link.MaxAge)
+
+	req.SetBody([]byte(`{"url":"https://example.com/","redirect":303}`))
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+	require.Equal(t, "redirect_invalid", fastjson.GetString(res.Body(), "code"))
+}
+
+func TestManageLink_Redirect(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveLink(0, storage.Link{
+		URL:            "https://example.com/",
+		RedirectPolicy: storage.RedirectPolicy{Redirect: 302},
+	})
+	require.NoError(t, err)
+
+	principals, err := parseTokens([]string{"bob:editor:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("PUT")
+	req.Header.SetHost("dab")
+	req.SetRequestURI("/api/links/" + short + "/redirect")
+	req.SetBody([]byte(`{"max_age":3600}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusUnauthorized, res.StatusCode())
+
+	req.Header.Set("Authorization", "Bearer secret")
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+	require.Equal(t, 3600, fastjson.GetInt(res.Body(), "max_age"))
+	// omitted status falls back to deployment default
+	require.False(t, fastjson.Exists(res.Body(), "redirect"))
+
+	req.SetBody([]byte(`{"redirect":418}`))
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusBadRequest, res.StatusCode())
+	require.Equal(t, "redirect_invalid", fastjson.GetString(res.Body(), "code"))
+
+	req.SetRequestURI("/api/links/abcdefg/redirect")
+	req.SetBody([]byte(`{}`))
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusNotFound, res.StatusCode())
+	require.Equal(t, "code_not_found", fastjson.GetString(res.Body(), "code"))
+}
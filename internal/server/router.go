@@ -0,0 +1,174 @@
+package server
+
+import (
+	"github.com/valyala/fasthttp"
+	"sort"
+	"strings"
+)
+
+const (
+	// apiPrefix is a path prefix of current API version
+	apiPrefix = "/api/v1"
+	// legacyAPIPrefix is a path prefix of unversioned API kept as alias of the current version
+	legacyAPIPrefix = "/api"
+)
+
+// route defines handler of requests with method and path matching pattern
+type route struct {
+	method string
+	// pattern is OpenAPI path template of the route, "{name}" segments match any non-empty segment
+	// and may be followed by literal suffix as in "/{short}+", the last "{name...}" segment matches the rest of path
+	pattern string
+	handle  fasthttp.RequestHandler
+}
+
+// router dispatches requests to routes by method and path.
+// Routes are tried in registration order, so more specific patterns are registered first
+type router struct {
+	routes   []route
+	segments [][]string
+}
+
+// newRouter constructs a router of routes. Routes of current API version are also served under legacy prefix,
+// each alias follows its route, so catch-all patterns registered later do not own legacy paths
+func newRouter(routes []route) *router {
+	r := &router{}
+
+	for _, rt := range routes {
+		r.add(rt)
+
+		if strings.HasPrefix(rt.pattern, apiPrefix+"/") {
+			rt.pattern = legacyAPIPrefix + strings.TrimPrefix(rt.pattern, apiPrefix)
+			r.add(rt)
+		}
+	}
+
+	return r
+}
+
+// add registers route
+func (r *router) add(rt route) {
+	r.routes = append(r.routes, rt)
+	r.segments = append(r.segments, strings.Split(strings.TrimPrefix(rt.pattern, "/"), "/"))
+}
+
+// matchSegments matches path segments against pattern ones and returns values of path parameters.
+// The last "{name...}" pattern segment matches the rest of path
+func matchSegments(pattern, path []string) (map[string]string, bool) {
+	var params map[string]string
+
+	if last := pattern[len(pattern)-1]; strings.HasPrefix(last, "{") && strings.HasSuffix(last, "...}") {
+		if len(path) < len(pattern) {
+			return nil, false
+		}
+
+		params = map[string]string{last[1 : len(last)-4]: strings.Join(path[len(pattern)-1:], "/")}
+		pattern, path = pattern[:len(pattern)-1], path[:len(pattern)-1]
+	}
+
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+
+	for i, p := range pattern {
+		end := strings.IndexByte(p, '}')
+		if !strings.HasPrefix(p, "{") || end < 0 {
+			if p != path[i] {
+				return nil, false
+			}
+			continue
+		}
+
+		suffix := p[end+1:]
+		if len(path[i]) <= len(suffix) || !strings.HasSuffix(path[i], suffix) {
+			return nil, false
+		}
+
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[p[1:end]] = strings.TrimSuffix(path[i], suffix)
+	}
+
+	return params, true
+}
+
+// lookup finds route serving method and path. If path matches no route with the method,
+// it returns methods allowed on the path, which are empty if the path matches no route at all
+func (r *router) lookup(method, path string) (route, map[string]string, []string) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	var (
+		pattern []string
+		allowed []string
+	)
+	for i, rt := range r.routes {
+		params, ok := matchSegments(r.segments[i], segments)
+		if !ok {
+			continue
+		}
+
+		// the first matching pattern owns the path, so routes registered later for other methods
+		// of less specific patterns do not make requests allowed
+		if pattern == nil {
+			pattern = r.segments[i]
+		} else if strings.Join(pattern, "/") != strings.Join(r.segments[i], "/") {
+			continue
+		}
+
+		if rt.method == method || method == fasthttp.MethodHead && rt.method == fasthttp.MethodGet {
+			return rt, params, nil
+		}
+		allowed = append(allowed, rt.method)
+	}
+
+	if len(allowed) == 0 {
+		return route{}, nil, nil
+	}
+
+	for _, m := range allowed {
+		if m == fasthttp.MethodGet {
+			allowed = append(allowed, fasthttp.MethodHead)
+			break
+		}
+	}
+	allowed = append(allowed, fasthttp.MethodOptions)
+	sort.Strings(allowed)
+
+	return route{}, nil, allowed
+}
+
+// dispatch serves request by matching route. Answers 404 if no route matches path, 405 with "Allow" header
+// if no route matches method and lists allowed methods in response to OPTIONS requests.
+// HEAD requests are served by GET routes, fasthttp drops body of responses to them
+func (r *router) dispatch(ctx *fasthttp.RequestCtx) {
+	method := string(ctx.Method())
+
+	rt, params, allowed := r.lookup(method, string(ctx.Path()))
+	if rt.handle != nil {
+		for name, value := range params {
+			ctx.SetUserValue(name, value)
+		}
+		rt.handle(ctx)
+		return
+	}
+
+	if len(allowed) == 0 {
+		writeError(ctx, errNotFound, "")
+		return
+	}
+
+	ctx.Response.Header.Set(fasthttp.HeaderAllow, strings.Join(allowed, ", "))
+	if method == fasthttp.MethodOptions {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+
+	writeError(ctx, errMethodNotAllowed, "")
+}
+
+// pathParam returns value of path parameter matched by router
+func pathParam(ctx *fasthttp.RequestCtx, name string) string {
+	value, _ := ctx.UserValue(name).(string)
+	return value
+}
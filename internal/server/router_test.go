@@ -0,0 +1,170 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	params, ok := matchSegments([]string{"api", "v1", "links", "{short}", "history"}, []string{"api", "v1", "links", "jnegYbw", "history"})
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"short": "jnegYbw"}, params)
+
+	params, ok = matchSegments([]string{"{short}+"}, []string{"jnegYbw+"})
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"short": "jnegYbw"}, params)
+
+	for _, path := range [][]string{{"+"}, {"jnegYbw"}, {"jnegYbw+", "qr"}} {
+		_, ok = matchSegments([]string{"{short}+"}, path)
+		require.False(t, ok, path)
+	}
+
+	_, ok = matchSegments([]string{"{short}"}, []string{""})
+	require.False(t, ok)
+
+	params, ok = matchSegments([]string{"{short}", "{path...}"}, []string{"jnegYbw", "docs", "intro"})
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"short": "jnegYbw", "path": "docs/intro"}, params)
+
+	_, ok = matchSegments([]string{"{short}", "{path...}"}, []string{"jnegYbw"})
+	require.False(t, ok)
+}
+
+func TestRouter_Lookup(t *testing.T) {
+	r := newRouter([]route{
+		{fasthttp.MethodGet, apiPrefix + "/items", nil},
+		{fasthttp.MethodPost, apiPrefix + "/items", nil},
+		{fasthttp.MethodDelete, apiPrefix + "/items/{id}", nil},
+		{fasthttp.MethodGet, "/preview/{short}", nil},
+		{fasthttp.MethodGet, "/{short}/qr", nil},
+		{fasthttp.MethodPost, "/{short}/qr", nil},
+	})
+
+	rt, params, allowed := r.lookup(fasthttp.MethodDelete, "/api/v1/items/42")
+	require.Equal(t, apiPrefix+"/items/{id}", rt.pattern)
+	require.Equal(t, map[string]string{"id": "42"}, params)
+	require.Empty(t, allowed)
+
+	rt, _, _ = r.lookup(fasthttp.MethodDelete, "/api/items/42")
+	require.Equal(t, "/api/items/{id}", rt.pattern)
+
+	rt, _, _ = r.lookup(fasthttp.MethodHead, "/api/v1/items")
+	require.Equal(t, fasthttp.MethodGet, rt.method)
+
+	_, _, allowed = r.lookup(fasthttp.MethodPut, "/api/v1/items")
+	require.Equal(t, []string{"GET", "HEAD", "OPTIONS", "POST"}, allowed)
+
+	// the first matching pattern owns the path
+	_, _, allowed = r.lookup(fasthttp.MethodPost, "/preview/qr")
+	require.Equal(t, []string{"GET", "HEAD", "OPTIONS"}, allowed)
+
+	rt, _, allowed = r.lookup(fasthttp.MethodGet, "/api/v1/typo")
+	require.Nil(t, rt.handle)
+	require.Empty(t, allowed)
+}
+
+func TestRouter_Dispatch(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveLink(0, storage.Link{URL: "https://example.com/target"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+		code   string
+		allow  string
+	}{
+		{"GET", "/api/typo", fasthttp.StatusNotFound, "not_found", ""},
+		{"GET", "/api/v1/links/" + short + "/typo", fasthttp.StatusNotFound, "not_found", ""},
+		{"GET", "/api/shorten", fasthttp.StatusMethodNotAllowed, "method_not_allowed", "OPTIONS, POST"},
+		{"PUT", "/api/v1/links/" + short, fasthttp.StatusMethodNotAllowed, "method_not_allowed", "DELETE, GET, HEAD, OPTIONS, PATCH"},
+		{"OPTIONS", "/api/v1/links/" + short, fasthttp.StatusNoContent, "", "DELETE, GET, HEAD, OPTIONS, PATCH"},
+		{"OPTIONS", "/" + short, fasthttp.StatusNoContent, "", "GET, HEAD, OPTIONS, POST"},
+		{"PUT", "/" + short, fasthttp.StatusMethodNotAllowed, "method_not_allowed", "GET, HEAD, OPTIONS, POST"},
+		{"GET", "/" + short, fasthttp.StatusMovedPermanently, "", ""},
+		{"HEAD", "/" + short, fasthttp.StatusMovedPermanently, "", ""},
+		{"GET", "/short", fasthttp.StatusBadRequest, "path_invalid", ""},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(tc.method)
+		req.Header.SetHost("dab")
+		req.SetRequestURI(tc.path)
+
+		res := fasthttp.AcquireResponse()
+		if tc.method == "HEAD" {
+			res.SkipBody = true
+		}
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.method+" "+tc.path)
+		require.Equal(t, tc.allow, string(res.Header.Peek(fasthttp.HeaderAllow)))
+		if tc.code != "" {
+			require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"))
+		}
+		if tc.status == fasthttp.StatusMovedPermanently {
+			require.Equal(t, "https://example.com/target", string(res.Header.Peek(fasthttp.HeaderLocation)))
+		}
+	}
+}
+
+func TestRouter_Versions(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, path := range []string{"/api/v1/shorten", "/api/shorten"} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType("application/json")
+		req.SetRequestURI(path)
+		req.SetBody([]byte(`{"url":"https://example.com/versioned"}`))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), path)
+		require.Equal(t, "https://example.com/versioned", fastjson.GetString(res.Body(), "url"))
+	}
+}
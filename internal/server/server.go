@@ -1,7 +1,9 @@
 package server
 
 import (
+	"auto/internal/policy"
 	"auto/internal/storage"
+	"auto/internal/unwrap"
 	"errors"
 	"fmt"
 	"github.com/pingcap/failpoint"
@@ -15,10 +17,12 @@ import (
 
 // Server defines fields used in HTTP processing.
 type Server struct {
-	logger        *zap.Logger
-	addr          string
-	httpServer    *fasthttp.Server
-	afterShutdown func() error
+	logger         *zap.Logger
+	addr           string
+	httpServer     *fasthttp.Server
+	policy         *policy.Engine
+	policyInterval time.Duration
+	afterShutdown  func() error
 }
 
 // New constructs a Server. See the various Options for available customizations.
@@ -33,39 +37,153 @@ func New(logger *zap.Logger, storage *storage.Storage, options ...Option) (Serve
 
 	config := &config{}
 
-	h := handler{logger: logger, Storage: storage}
-	m := func(ctx *fasthttp.RequestCtx) {
-		switch string(ctx.Path()) {
-		case "/api/shorten":
-			h.saveURL(ctx)
-		default:
-			h.getURL(ctx)
-		}
+	for _, o := range options {
+		o.apply(config)
+	}
+
+	principals, err := parseTokens(config.tokens)
+	if err != nil {
+		return Server{}, err
+	}
+
+	engine, err := policy.New(logger, config.policyFile)
+	if err != nil {
+		return Server{}, err
+	}
+
+	base, err := parseBaseURLs(config.baseURL, config.baseURLs)
+	if err != nil {
+		return Server{}, err
 	}
 
+	// links to hosts of base URLs would point to short links too
+	publicHosts, err := parsePublicHosts(append(config.publicHosts, base.publicHosts()...))
+	if err != nil {
+		return Server{}, err
+	}
+
+	if err := config.redirect.Validate(); err != nil {
+		return Server{}, err
+	}
+
+	if config.passwordAttempts <= 0 || config.passwordWindow <= 0 {
+		config.passwordAttempts, config.passwordWindow = defaultPasswordAttempts, defaultPasswordAttemptsWindow
+	}
+
+	comingSoon, err := parseComingSoonPage(config.comingSoonPage)
+	if err != nil {
+		return Server{}, err
+	}
+
+	h := handler{
+		logger:           logger,
+		Storage:          storage,
+		principals:       principals,
+		policy:           engine,
+		publicHosts:      publicHosts,
+		redirect:         config.redirect,
+		baseURLs:         base,
+		passwordAttempts: newAttemptLimiter(config.passwordAttempts, config.passwordWindow),
+		comingSoon:       comingSoon,
+		countryHeader:    config.countryHeader,
+		countryLookup:    config.countryLookup,
+	}
+	if len(config.unwrapHosts) > 0 {
+		h.unwrapper = unwrap.New(config.unwrapHosts, unwrap.WithTimeout(config.unwrapTimeout))
+	}
+
+	if err := h.reloadRuntimeRules(0); err != nil {
+		return Server{}, err
+	}
+
+	// retroactive rules added to the file disable links once, when they are loaded for the first time
+	engine.OnAdded(func(rules []policy.Rule) {
+		for _, rule := range rules {
+			if err := h.enforceFileRule(rule); err != nil {
+				logger.Error("enforcing policy rule", zap.String("id", rule.ID), zap.Error(err))
+			}
+		}
+	})
+
+	if _, err := engine.Reload(); err != nil {
+		return Server{}, fmt.Errorf("loading policy rules: %w", err)
+	}
 	s := &fasthttp.Server{
-		Handler:          m,
+		Handler:          chain(h.router().dispatch, h.middlewares(config.middlewares)...),
 		DisableKeepalive: true,
 		ReadTimeout:      5 * time.Second,
 	}
 
-	for _, o := range options {
-		o.apply(config)
-	}
-
 	return Server{
-		logger:        logger,
-		addr:          config.addr,
-		httpServer:    s,
-		afterShutdown: storage.Close,
+		logger:         logger,
+		addr:           config.addr,
+		httpServer:     s,
+		policy:         engine,
+		policyInterval: config.policyInterval,
+		afterShutdown:  storage.Close,
 	}, nil
 }
 
+// router constructs router of the API routes
+func (h *handler) router() *router {
+	return newRouter(h.routes())
+}
+
+// routes lists routes of the API in matching order
+func (h *handler) routes() []route {
+	// link management handlers take short string ID matched in path
+	withShort := func(handle func(*fasthttp.RequestCtx, string)) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) { handle(ctx, pathParam(ctx, "short")) }
+	}
+	// query template handlers take template name matched in path
+	withName := func(handle func(*fasthttp.RequestCtx, string)) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) { handle(ctx, pathParam(ctx, "name")) }
+	}
+	toggle := func(enabled bool) fasthttp.RequestHandler {
+		return withShort(func(ctx *fasthttp.RequestCtx, short string) { h.toggleLink(ctx, short, enabled) })
+	}
+
+	return []route{
+		{fasthttp.MethodPost, apiPrefix + "/shorten", h.saveURL},
+		{fasthttp.MethodGet, openAPIPath, h.openAPI},
+		{fasthttp.MethodGet, docsPath, h.docs},
+		{fasthttp.MethodGet, apiPrefix + "/admin/links/search", h.searchLinks},
+		{fasthttp.MethodGet, apiPrefix + "/admin/audit", h.auditLog},
+		{fasthttp.MethodGet, policyRulesPath, h.listRules},
+		{fasthttp.MethodPost, policyRulesPath, h.addRule},
+		{fasthttp.MethodDelete, policyRulesPath + "/{id}", func(ctx *fasthttp.RequestCtx) { h.removeRule(ctx, pathParam(ctx, "id")) }},
+		{fasthttp.MethodGet, templatesPath, h.listTemplates},
+		{fasthttp.MethodGet, templatesPath + "/{name}", withName(h.getTemplate)},
+		{fasthttp.MethodPut, templatesPath + "/{name}", withName(h.putTemplate)},
+		{fasthttp.MethodDelete, templatesPath + "/{name}", withName(h.removeTemplate)},
+		{fasthttp.MethodGet, linksPath, withShort(h.linkMetadata)},
+		{fasthttp.MethodPatch, linksPath, withShort(h.updateLink)},
+		{fasthttp.MethodDelete, linksPath, withShort(h.deleteLink)},
+		{fasthttp.MethodGet, linksPath + "/history", withShort(h.linkHistory)},
+		{fasthttp.MethodPost, linksPath + "/revert", withShort(h.revertLink)},
+		{fasthttp.MethodPost, linksPath + "/disable", toggle(false)},
+		{fasthttp.MethodPost, linksPath + "/enable", toggle(true)},
+		{fasthttp.MethodPut, linksPath + "/redirect", withShort(h.setRedirect)},
+		{fasthttp.MethodPut, linksPath + "/variants", withShort(h.setVariantWeights)},
+		{fasthttp.MethodPut, linksPath + "/targets", withShort(h.setTargets)},
+		{fasthttp.MethodGet, linksPath + "/targets/test", withShort(h.testTargets)},
+		{fasthttp.MethodGet, previewPrefix + "{short}", withShort(h.previewLink)},
+		{fasthttp.MethodGet, "/{short}+", withShort(h.previewLink)},
+		{fasthttp.MethodGet, "/{short}" + qrSuffix, withShort(h.qrCode)},
+		{fasthttp.MethodGet, "/{short}/{path...}", h.getURL},
+		{fasthttp.MethodPost, "/{short}/{path...}", h.getURL},
+		{fasthttp.MethodGet, "/{short}", h.getURL},
+		{fasthttp.MethodPost, "/{short}", h.getURL},
+	}
+}
+
 // Start calls ListenAndServe on fasthttp.Server instance inside Server struct
 // and implements graceful shutdown via goroutine waiting for signals.
 func (s *Server) Start() error {
 	idleConnsClosed := make(chan struct{})
 
+	go s.policy.Watch(s.policyInterval, idleConnsClosed)
+
 	go func() {
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
@@ -11,7 +11,9 @@ import (
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttputil"
 	"go.uber.org/zap"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
@@ -265,3 +267,88 @@ func TestStart_ErrAfterShutdown(t *testing.T) {
 
 	require.Equal(t, errors.New("mock release sequence error"), startErr)
 }
+
+func TestNew_InvalidTokens(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = New(logger, store, WithTokens("root"))
+	require.Equal(t, errors.New("API token #1 must be in \"name:role:secret\" form"), err)
+}
+
+func TestNew_InvalidRedirect(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = New(logger, store, WithRedirect(303, -1))
+	require.True(t, errors.Is(err, storage.ErrInvalidRedirect))
+
+	_, err = New(logger, store, WithRedirect(302, storage.MaxCacheAge+1))
+	require.True(t, errors.Is(err, storage.ErrInvalidRedirect))
+}
+
+func TestNew_PolicyFile(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := store.SaveURL(0, "https://evil.com/")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(path, []byte("block evil_com\n"), 0644)
+	require.NoError(t, err)
+
+	_, err = New(logger, store, WithPolicyFile(path, time.Second))
+	require.EqualError(t, err, "loading policy rules: line 1: rule pattern is invalid: URL host is invalid: idna: disallowed rune U+005F")
+
+	err = ioutil.WriteFile(path, []byte("block evil.com retroactive\n"), 0644)
+	require.NoError(t, err)
+
+	srv, err := New(logger, store, WithPolicyFile(path, time.Second))
+	require.NoError(t, err)
+	require.Equal(t, time.Second, srv.policyInterval)
+
+	// retroactive rule disables existing link on the first load
+	_, err = store.GetURL(0, short)
+	require.Equal(t, storage.ErrShortGone, err)
+
+	// links re-enabled after enforcement stay enabled after restart
+	_, err = store.Enable(0, short, "root")
+	require.NoError(t, err)
+
+	_, err = New(logger, store, WithPolicyFile(path, time.Second))
+	require.NoError(t, err)
+
+	_, err = store.GetURL(0, short)
+	require.NoError(t, err)
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"bytes"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shortenMediaTypes lists media types of link creation requests and responses in server preference order
+var shortenMediaTypes = []string{contentTypeJSON, contentTypeForm, "text/plain"}
+
+// shortenRequest defines fields of link creation request whatever its media type is
+type shortenRequest struct {
+	url       string
+	redirect  storage.RedirectPolicy
+	notBefore *time.Time
+	fallback  string
+	expiresAt *time.Time
+	maxClicks uint64
+	password  string
+	variants  []storage.Variant
+	targets   []storage.TargetRule
+}
+
+// mediaType returns lowercased media type of Content-Type header value without parameters
+func mediaType(contentType []byte) string {
+	t := string(contentType)
+	if i := strings.IndexByte(t, ';'); i >= 0 {
+		t = t[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// readShortenRequest parses link creation request body of JSON, form or plain text media type.
+// It writes error response and returns false if request can not be parsed
+func readShortenRequest(ctx *fasthttp.RequestCtx) (shortenRequest, bool) {
+	var (
+		r   shortenRequest
+		err error
+	)
+
+	t := mediaType(ctx.Request.Header.ContentType())
+	// clients ignoring Content-Type (e.g. "curl --data") send JSON as form or without media type at all,
+	// such bodies have been read as JSON before other media types were supported
+	if t == "" || t == contentTypeForm && bytes.HasPrefix(bytes.TrimSpace(ctx.PostBody()), []byte("{")) {
+		t = contentTypeJSON
+	}
+
+	switch t {
+	case contentTypeJSON:
+		body := ctx.PostBody()
+		// the first variant of split link is its target
+		if fastjson.Exists(body, "variants") {
+			if r.variants, err = parseVariants(body); err != nil {
+				writeError(ctx, errVariantsInvalid, err.Error())
+				return r, false
+			}
+			r.url = r.variants[0].URL
+		} else {
+			if !fastjson.Exists(body, "url") {
+				writeError(ctx, errURLMissing, "")
+				return r, false
+			}
+
+			r.url = fastjson.GetString(body, "url")
+			if len(r.url) == 0 {
+				writeError(ctx, errURLInvalid, "Field \"url\" must be a string and have non-zero length")
+				return r, false
+			}
+		}
+
+		r.redirect, err = parseRedirectPolicy(body)
+		if err == nil && fastjson.Exists(body, "expires_at") {
+			r.expiresAt, err = parseExpiry(fastjson.GetString(body, "expires_at"))
+		}
+		if err == nil && fastjson.Exists(body, "not_after") {
+			r.expiresAt, err = parseNotAfter(r.expiresAt, fastjson.GetString(body, "not_after"))
+		}
+		if err == nil && fastjson.Exists(body, "not_before") {
+			r.notBefore, err = parseNotBefore(fastjson.GetString(body, "not_before"))
+		}
+		r.fallback = fastjson.GetString(body, "fallback_url")
+		if err == nil && fastjson.Exists(body, "targets") {
+			// body is valid JSON as redirect policy has been parsed from it
+			v, _ := fastjson.ParseBytes(body)
+			r.targets, err = parseTargets(v.Get("targets"))
+		}
+		if err == nil && fastjson.Exists(body, "max_clicks") {
+			// body is valid JSON as redirect policy has been parsed from it, raw value keeps number as is
+			v, _ := fastjson.ParseBytes(body)
+			r.maxClicks, err = parseMaxClicks(v.Get("max_clicks").String())
+		}
+		r.password = fastjson.GetString(body, "password")
+	case contentTypeForm:
+		args := ctx.PostArgs()
+		if !args.Has("url") {
+			writeError(ctx, errURLMissing, "")
+			return r, false
+		}
+
+		r.url = string(args.Peek("url"))
+		if len(r.url) == 0 {
+			writeError(ctx, errURLInvalid, "Field \"url\" must have non-zero length")
+			return r, false
+		}
+
+		r.redirect, err = formRedirectPolicy(args)
+		if err == nil && args.Has("expires_at") {
+			r.expiresAt, err = parseExpiry(string(args.Peek("expires_at")))
+		}
+		if err == nil && args.Has("not_after") {
+			r.expiresAt, err = parseNotAfter(r.expiresAt, string(args.Peek("not_after")))
+		}
+		if err == nil && args.Has("not_before") {
+			r.notBefore, err = parseNotBefore(string(args.Peek("not_before")))
+		}
+		r.fallback = string(args.Peek("fallback_url"))
+		if err == nil && args.Has("max_clicks") {
+			r.maxClicks, err = parseMaxClicks(string(args.Peek("max_clicks")))
+		}
+		r.password = string(args.Peek("password"))
+	case "text/plain":
+		// the whole body is URL, trailing newline of shell tools is dropped
+		r.url = strings.TrimSpace(string(ctx.PostBody()))
+		if len(r.url) == 0 {
+			writeError(ctx, errURLMissing, "")
+			return r, false
+		}
+	default:
+		ctx.Response.Header.Set("Accept-Post", strings.Join(shortenMediaTypes, ", "))
+		writeError(ctx, errMediaTypeUnsupported, "Media type "+strconv.Quote(t)+" is not supported")
+		return r, false
+	}
+
+	if errors.Is(err, errInvalidExpiry) || errors.Is(err, errInvalidNotAfter) {
+		writeError(ctx, errExpiryInvalid, err.Error())
+		return r, false
+	}
+	if errors.Is(err, errInvalidNotBefore) {
+		writeError(ctx, errActivationInvalid, err.Error())
+		return r, false
+	}
+	if errors.Is(err, errInvalidTargetsField) {
+		writeError(ctx, errTargetsInvalid, err.Error())
+		return r, false
+	}
+	if errors.Is(err, errInvalidMaxClicks) {
+		writeError(ctx, errMaxClicksInvalid, err.Error())
+		return r, false
+	}
+	if err != nil {
+		writeError(ctx, errRedirectInvalid, err.Error())
+		return r, false
+	}
+
+	return r, true
+}
+
+// errInvalidExpiry describes "expires_at" field that is not RFC 3339 time
+var errInvalidExpiry = errors.New("field \"expires_at\" must be RFC 3339 time")
+
+// parseExpiry parses RFC 3339 link expiry time, whether it is in the future is checked by storage
+func parseExpiry(value string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errInvalidExpiry
+	}
+
+	return &t, nil
+}
+
+// errInvalidNotAfter describes "not_after" field that is not RFC 3339 time or is sent along with "expires_at" one
+var errInvalidNotAfter = errors.New("field \"not_after\" must be RFC 3339 time and can not be sent along with \"expires_at\"")
+
+// parseNotAfter parses link deactivation time, which is another name of expiry time
+func parseNotAfter(expiresAt *time.Time, value string) (*time.Time, error) {
+	if expiresAt != nil {
+		return nil, errInvalidNotAfter
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errInvalidNotAfter
+	}
+
+	return &t, nil
+}
+
+// errInvalidNotBefore describes "not_before" field that is not RFC 3339 time
+var errInvalidNotBefore = errors.New("field \"not_before\" must be RFC 3339 time")
+
+// parseNotBefore parses RFC 3339 link activation time
+func parseNotBefore(value string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errInvalidNotBefore
+	}
+
+	return &t, nil
+}
+
+// errInvalidMaxClicks describes "max_clicks" field that is not positive integer
+var errInvalidMaxClicks = errors.New("field \"max_clicks\" must be positive integer")
+
+// parseMaxClicks parses number of clicks link stays active for
+func parseMaxClicks(value string) (uint64, error) {
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 {
+		return 0, errInvalidMaxClicks
+	}
+
+	return n, nil
+}
+
+// formQueryPrefix prefixes names of form fields holding query parameters of link
+const formQueryPrefix = "query."
+
+// formRedirectPolicy reads optional "redirect", "max_age", "passthrough", "template" and "query.<name>" fields of form body
+func formRedirectPolicy(args *fasthttp.Args) (storage.RedirectPolicy, error) {
+	var p storage.RedirectPolicy
+
+	if args.Has("redirect") {
+		redirect, err := strconv.Atoi(string(args.Peek("redirect")))
+		if err != nil {
+			return p, errors.New("field \"redirect\" must be an integer")
+		}
+		p.Redirect = redirect
+	}
+
+	if args.Has("max_age") {
+		maxAge, err := strconv.ParseInt(string(args.Peek("max_age")), 10, 64)
+		if err != nil {
+			return p, errors.New("field \"max_age\" must be an integer")
+		}
+		p.MaxAge = &maxAge
+	}
+
+	if args.Has("passthrough") {
+		passthrough, err := strconv.ParseBool(string(args.Peek("passthrough")))
+		if err != nil {
+			return p, errors.New("field \"passthrough\" must be a boolean")
+		}
+		p.Passthrough = passthrough
+	}
+
+	p.Template = string(args.Peek("template"))
+
+	// query parameters are sent as "query.<name>" fields
+	args.VisitAll(func(key, value []byte) {
+		if name := strings.TrimPrefix(string(key), formQueryPrefix); len(name) < len(key) {
+			if p.Query == nil {
+				p.Query = map[string]string{}
+			}
+			p.Query[name] = string(value)
+		}
+	})
+
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// writeCreated writes created link as JSON, form or plain text short URL depending on Accept header.
+// JSON is written if nothing else is acceptable
+func (h *handler) writeCreated(ctx *fasthttp.RequestCtx, short string, link storage.Link) {
+	accept := string(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+
+	switch negotiate(accept, shortenMediaTypes...) {
+	case contentTypeForm:
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType(contentTypeForm)
+		ctx.SetBodyString(url.Values{
+			"short":     {short},
+			"short_url": {h.shortURL(ctx, short)},
+			"url":       {link.URL},
+		}.Encode())
+	case "text/plain":
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType(contentTypeText)
+		ctx.SetBodyString(h.shortURL(ctx, short) + "\n")
+	default:
+		h.writeLink(ctx, short, link)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"net/url"
+	"testing"
+)
+
+func TestMediaType(t *testing.T) {
+	require.Equal(t, "application/json", mediaType([]byte("Application/JSON; charset=utf-8")))
+	require.Equal(t, "text/plain", mediaType([]byte(" text/plain ")))
+	require.Equal(t, "", mediaType(nil))
+}
+
+func TestSaveUrl_MediaTypes(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	base, err := parseBaseURLs("https://sho.rt", nil)
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:   logger,
+		Storage:  store,
+		baseURLs: base,
+	}
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		accept      string
+		resType     string
+		url         string
+		redirect    int
+	}{
+		{"application/json; charset=utf-8", `{"url":"https://example.com/json"}`, "", contentTypeJSON, "https://example.com/json", 0},
+		{"application/x-www-form-urlencoded", "url=https%3A%2F%2Fexample.com%2Fform&redirect=302", "", contentTypeJSON, "https://example.com/form", 302},
+		// JSON sent as form by clients ignoring Content-Type
+		{"application/x-www-form-urlencoded", ` {"url":"https://example.com/legacy"}`, "", contentTypeJSON, "https://example.com/legacy", 0},
+		{"text/plain", "https://example.com/text\n", "text/plain", contentTypeText, "https://example.com/text", 0},
+		{"text/plain", "https://example.com/text", "application/x-www-form-urlencoded", contentTypeForm, "https://example.com/text", 0},
+		// nothing acceptable falls back to JSON
+		{"text/plain", "https://example.com/text", "image/png", contentTypeJSON, "https://example.com/text", 0},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType(tc.contentType)
+		if tc.accept != "" {
+			req.Header.Set(fasthttp.HeaderAccept, tc.accept)
+		}
+		req.SetRequestURI("/api/shorten")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.saveURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), tc.body)
+		require.Equal(t, tc.resType, string(res.Header.ContentType()))
+
+		var short string
+		switch tc.resType {
+		case contentTypeJSON:
+			short = fastjson.GetString(res.Body(), "short")
+		case contentTypeForm:
+			values, err := url.ParseQuery(string(res.Body()))
+			require.NoError(t, err)
+			short = values.Get("short")
+			require.Equal(t, "https://sho.rt/"+short, values.Get("short_url"))
+			require.Equal(t, "https://example.com/text", values.Get("url"))
+		case contentTypeText:
+			require.Regexp(t, "^https://sho.rt/[a-zA-Z0-9]{7}\n$", string(res.Body()))
+			short = string(res.Body()[len("https://sho.rt/") : len(res.Body())-1])
+		}
+
+		link, err := store.GetLink(0, short)
+		require.NoError(t, err)
+		require.Equal(t, tc.url, link.URL)
+		require.Equal(t, tc.redirect, link.Redirect)
+	}
+}
+
+func TestSaveUrl_InvalidBody(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"application/x-www-form-urlencoded", "target=https%3A%2F%2Fexample.com", fasthttp.StatusBadRequest, "url_missing"},
+		{"application/x-www-form-urlencoded", "url=", fasthttp.StatusBadRequest, "url_invalid"},
+		{"application/x-www-form-urlencoded", "url=https%3A%2F%2Fexample.com&max_age=soon", fasthttp.StatusBadRequest, "redirect_invalid"},
+		{"text/plain", " \n", fasthttp.StatusBadRequest, "url_missing"},
+		{"application/json", `{"url":"https://example.com","expires_at":"tomorrow"}`, fasthttp.StatusBadRequest, "expiry_invalid"},
+		{"application/json", `{"url":"https://example.com","expires_at":"2001-01-01T00:00:00Z"}`, fasthttp.StatusBadRequest, "expiry_invalid"},
+		{"application/x-www-form-urlencoded", "url=https%3A%2F%2Fexample.com&expires_at=2001-01-01", fasthttp.StatusBadRequest, "expiry_invalid"},
+		{"application/json", `{"url":"https://example.com","max_clicks":0}`, fasthttp.StatusBadRequest, "max_clicks_invalid"},
+		{"application/json", `{"url":"https://example.com","max_clicks":1.5}`, fasthttp.StatusBadRequest, "max_clicks_invalid"},
+		{"application/json", `{"url":"https://example.com","max_clicks":"3"}`, fasthttp.StatusBadRequest, "max_clicks_invalid"},
+		{"application/x-www-form-urlencoded", "url=https%3A%2F%2Fexample.com&max_clicks=-1", fasthttp.StatusBadRequest, "max_clicks_invalid"},
+		{"application/xml", "<url>https://example.com</url>", fasthttp.StatusUnsupportedMediaType, "media_type_unsupported"},
+		{"image/png", "https://example.com", fasthttp.StatusUnsupportedMediaType, "media_type_unsupported"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType(tc.contentType)
+		req.SetRequestURI("/api/shorten")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.saveURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.body)
+		require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"))
+		if tc.status == fasthttp.StatusUnsupportedMediaType {
+			require.Equal(t, "application/json, application/x-www-form-urlencoded, text/plain", string(res.Header.Peek("Accept-Post")))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"auto/internal/linkurl"
+	"auto/internal/unwrap"
+	"errors"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parsePublicHosts normalizes hosts short links are served on. Host without port matches any port
+func parsePublicHosts(hosts []string) (map[string]bool, error) {
+	public := make(map[string]bool, len(hosts))
+	for i, h := range hosts {
+		h = strings.TrimSpace(h)
+
+		host, port, err := net.SplitHostPort(h)
+		if err != nil {
+			host, port = h, ""
+		}
+
+		normalized, err := linkurl.NormalizeHost(strings.Trim(host, "[]"))
+		if err != nil {
+			return nil, fmt.Errorf("public host #%d: %w", i+1, err)
+		}
+
+		if port != "" {
+			normalized = net.JoinHostPort(strings.Trim(normalized, "[]"), port)
+		}
+		public[normalized] = true
+	}
+
+	return public, nil
+}
+
+// ownURL reports whether normalized URL points to host short links are served on
+func (h *handler) ownURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	host := u.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return h.publicHosts[u.Host] || h.publicHosts[host]
+}
+
+// resolveTarget validates and normalizes link target, replaces links of known shorteners with their targets
+// and checks every URL of redirect chain against loops and policy.
+// It writes error response and returns false if target can not be stored
+func (h *handler) resolveTarget(ctx *fasthttp.RequestCtx, rawURL string) (string, bool) {
+	normalized, err := linkurl.Normalize(rawURL)
+	if err != nil {
+		writeError(ctx, errURLInvalid, err.Error())
+		return "", false
+	}
+
+	chain := []string{normalized}
+	if h.unwrapper != nil {
+		chain, err = h.unwrapper.Unwrap(ctx, normalized)
+		if err != nil {
+			if errors.Is(err, unwrap.ErrLoop) {
+				writeError(ctx, errURLLoop, err.Error())
+			} else {
+				writeError(ctx, errURLUnresolved, err.Error())
+			}
+			return "", false
+		}
+	}
+
+	for _, u := range chain {
+		if h.ownURL(u) {
+			writeError(ctx, errURLLoop, u+" is a link of this shortener")
+			return "", false
+		}
+
+		if !h.allowed(ctx, u) {
+			return "", false
+		}
+	}
+
+	return chain[len(chain)-1], true
+}
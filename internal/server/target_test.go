@@ -0,0 +1,158 @@
+package server
+
+import (
+	"auto/internal/linkurl"
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"auto/internal/unwrap"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParsePublicHosts(t *testing.T) {
+	hosts, err := parsePublicHosts([]string{"Sho.rt", " localhost:9000", "[::1]:9000", "bücher.example"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{
+		"sho.rt":                true,
+		"localhost:9000":        true,
+		"[::1]:9000":            true,
+		"xn--bcher-kva.example": true,
+	}, hosts)
+
+	_, err = parsePublicHosts([]string{"sho.rt", "sho_rt"})
+	require.True(t, errors.Is(err, linkurl.ErrHost))
+	require.Contains(t, err.Error(), "public host #2")
+}
+
+func TestSaveUrl_Loop(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	publicHosts, err := parsePublicHosts([]string{"sho.rt", "localhost:9000"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:      logger,
+		Storage:     store,
+		publicHosts: publicHosts,
+	}
+
+	for _, url := range []string{"https://SHO.RT/jnegYbw", "http://localhost:9000/jnegYbw"} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType("application/json")
+		req.SetRequestURI("/api/shorten")
+		req.SetBody([]byte(`{"url":"` + url + `"}`))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.saveURL, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusUnprocessableEntity, res.StatusCode(), url)
+		require.Equal(t, "url_loop", fastjson.GetString(res.Body(), "code"))
+	}
+
+	// other ports of public host with port are not ours
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI("/api/shorten")
+	req.SetBody([]byte(`{"url":"http://localhost:8080/jnegYbw"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+}
+
+func TestSaveUrl_Unwrap(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	// target is changed while shortener is serving
+	var target atomic.Value
+	target.Store("https://example.com/target")
+	shortener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.Load().(string), http.StatusMovedPermanently)
+	}))
+	defer shortener.Close()
+
+	publicHosts, err := parsePublicHosts([]string{"sho.rt"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:      logger,
+		Storage:     store,
+		publicHosts: publicHosts,
+		unwrapper:   unwrap.New([]string{"127.0.0.1"}),
+	}
+
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("dab")
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI("/api/shorten")
+	req.SetBody([]byte(`{"url":"` + shortener.URL + `/abc"}`))
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusOK, res.StatusCode())
+
+	// link points to the final target instead of other shortener
+	actual, err := store.GetURL(0, fastjson.GetString(res.Body(), "short"))
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/target", actual)
+
+	// other shortener pointing back to us makes a loop
+	target.Store("https://sho.rt/jnegYbw")
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusUnprocessableEntity, res.StatusCode())
+	require.Equal(t, "url_loop", fastjson.GetString(res.Body(), "code"))
+	require.Equal(t, "https://sho.rt/jnegYbw is a link of this shortener", fastjson.GetString(res.Body(), "detail"))
+
+	target.Store("ftp://example.com/")
+
+	err = serve(h.saveURL, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, fasthttp.StatusUnprocessableEntity, res.StatusCode())
+	require.Equal(t, "url_unresolved", fastjson.GetString(res.Body(), "code"))
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"encoding/json"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CountryLookup resolves country of client IP address, e.g. with GeoIP database
+type CountryLookup interface {
+	// Country returns ISO 3166-1 alpha-2 code of country ip belongs to, false if it is not known
+	Country(ip net.IP) (string, bool)
+}
+
+// parseUserAgent detects operating system and device type of User-Agent header value
+// with the same names targeting rules use, empty strings if they are not recognized
+func parseUserAgent(ua string) (os, device string) {
+	lower := strings.ToLower(ua)
+
+	// order matters as User-Agent values mention other platforms for compatibility,
+	// e.g. Android ones mention Linux and iPhone ones mention Mac OS X
+	switch {
+	case strings.Contains(lower, "ipad"):
+		os, device = "ios", "tablet"
+	case strings.Contains(lower, "iphone"), strings.Contains(lower, "ipod"):
+		os, device = "ios", "mobile"
+	case strings.Contains(lower, "android"):
+		// Android tablets leave "Mobile" token out
+		os, device = "android", "tablet"
+		if strings.Contains(lower, "mobile") {
+			device = "mobile"
+		}
+	case strings.Contains(lower, "windows"):
+		os, device = "windows", "desktop"
+	case strings.Contains(lower, "; cros"):
+		os, device = "chromeos", "desktop"
+	case strings.Contains(lower, "macintosh"), strings.Contains(lower, "mac os x"):
+		os, device = "macos", "desktop"
+	case strings.Contains(lower, "linux"):
+		os, device = "linux", "desktop"
+	}
+
+	// crawlers often pretend to run on some platform
+	for _, token := range []string{"bot", "crawler", "spider", "slurp"} {
+		if strings.Contains(lower, token) {
+			device = "bot"
+		}
+	}
+
+	return os, device
+}
+
+// preferredLanguage returns lowercased language tag Accept-Language header value gives the highest weight,
+// the earliest one wins ties. Wildcard and malformed entries are skipped
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, entry := range strings.Split(header, ",") {
+		params := strings.Split(entry, ";")
+
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil && v >= 0 && v <= 1 {
+					q = v
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+
+	return best
+}
+
+// country returns uppercased country code of request from country header set by trusted proxy
+// falling back to country lookup, empty string if neither knows it
+func (h *handler) country(ctx *fasthttp.RequestCtx) string {
+	var country string
+	if h.countryHeader != "" {
+		country = string(ctx.Request.Header.Peek(h.countryHeader))
+	}
+	if country == "" && h.countryLookup != nil {
+		country, _ = h.countryLookup.Country(ctx.RemoteIP())
+	}
+
+	country = strings.ToUpper(strings.TrimSpace(country))
+	// proxies mark unknown countries with codes like "XX" or "T1", rules just never match them
+	if len(country) != 2 {
+		return ""
+	}
+
+	return country
+}
+
+// visit describes redirect request for targeting rules
+func (h *handler) visit(ctx *fasthttp.RequestCtx) storage.Visit {
+	var v storage.Visit
+	v.OS, v.Device = parseUserAgent(string(ctx.Request.Header.UserAgent()))
+	v.Language = preferredLanguage(string(ctx.Request.Header.Peek(fasthttp.HeaderAcceptLanguage)))
+	v.Country = h.country(ctx)
+
+	return v
+}
+
+// errInvalidTargetsField describes "targets" field that is not an array of targeting rules
+var errInvalidTargetsField = errors.New("field \"targets\" must be an array of objects with \"url\" string" +
+	" and \"os\", \"device\", \"language\" and \"country\" arrays of strings")
+
+// parseTargets parses targeting rules from JSON value, language tags are lowercased and country codes are uppercased.
+// Conditions are checked by storage
+func parseTargets(v *fastjson.Value) ([]storage.TargetRule, error) {
+	items, err := v.Array()
+	if err != nil {
+		return nil, errInvalidTargetsField
+	}
+
+	targets := make([]storage.TargetRule, len(items))
+	for i, item := range items {
+		if item.Type() != fastjson.TypeObject {
+			return nil, errInvalidTargetsField
+		}
+
+		t := &targets[i]
+		t.URL = string(item.GetStringBytes("url"))
+		for _, field := range []struct {
+			name   string
+			values *[]string
+			norm   func(string) string
+		}{
+			{"os", &t.OS, strings.ToLower},
+			{"device", &t.Device, strings.ToLower},
+			{"language", &t.Language, strings.ToLower},
+			{"country", &t.Country, strings.ToUpper},
+		} {
+			value := item.Get(field.name)
+			if value == nil {
+				continue
+			}
+
+			values, err := value.Array()
+			if err != nil {
+				return nil, errInvalidTargetsField
+			}
+			for _, v := range values {
+				s, err := v.StringBytes()
+				if err != nil {
+					return nil, errInvalidTargetsField
+				}
+				*field.values = append(*field.values, field.norm(string(s)))
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// resolveTargets validates and normalizes destinations of targeting rules like link targets.
+// It writes error response and returns false if some destination can not be stored
+func (h *handler) resolveTargets(ctx *fasthttp.RequestCtx, targets []storage.TargetRule) bool {
+	for i := range targets {
+		url, ok := h.resolveTarget(ctx, targets[i].URL)
+		if !ok {
+			return false
+		}
+		targets[i].URL = url
+	}
+
+	return true
+}
+
+// setTargets handles HTTP requests on "PUT /api/links/{short}/targets" endpoint.
+// Replaces link targeting rules, empty rules make link redirect to its URL only
+func (h *handler) setTargets(ctx *fasthttp.RequestCtx, short string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	v, err := fastjson.ParseBytes(ctx.PostBody())
+	if err != nil {
+		writeError(ctx, errTargetsInvalid, err.Error())
+		return
+	}
+
+	field := v.Get("targets")
+	if field == nil {
+		writeError(ctx, errTargetsInvalid, errInvalidTargetsField.Error())
+		return
+	}
+
+	targets, err := parseTargets(field)
+	if err != nil {
+		writeError(ctx, errTargetsInvalid, err.Error())
+		return
+	}
+
+	if !h.resolveTargets(ctx, targets) {
+		return
+	}
+
+	link, err := h.Storage.SetTargets(ctx.ID(), short, targets, p.name)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	h.writeLink(ctx, short, link)
+}
+
+// targetTest defines destination targeting rules choose for visit
+type targetTest struct {
+	Visit storage.Visit `json:"visit"`
+	// Rule is index of matching targeting rule, nil when visit matches none and gets link URL
+	Rule *int   `json:"rule,omitempty"`
+	URL  string `json:"url"`
+}
+
+// testTargets handles HTTP requests on "GET /api/links/{short}/targets/test" endpoint.
+// Shows destination link targeting rules choose for request described by "user_agent", "accept_language"
+// and "country" query parameters, the request's own headers and country are used for omitted ones
+func (h *handler) testTargets(ctx *fasthttp.RequestCtx, short string) {
+	if _, ok := h.authorize(ctx, roleEditor); !ok {
+		return
+	}
+
+	link, err := h.Storage.LookupLink(ctx.ID(), short)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	visit := h.visit(ctx)
+	args := ctx.QueryArgs()
+	if args.Has("user_agent") {
+		visit.OS, visit.Device = parseUserAgent(string(args.Peek("user_agent")))
+	}
+	if args.Has("accept_language") {
+		visit.Language = preferredLanguage(string(args.Peek("accept_language")))
+	}
+	if args.Has("country") {
+		visit.Country = strings.ToUpper(string(args.Peek("country")))
+	}
+
+	target, rule := link.Target(visit)
+	test := targetTest{Visit: visit, URL: target.URL}
+	if rule >= 0 {
+		test.Rule = &rule
+	}
+
+	body, _ := json.Marshal(test)
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBody(body)
+}
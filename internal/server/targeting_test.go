@@ -0,0 +1,232 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"net"
+	"testing"
+)
+
+// staticCountry resolves every address to the same country
+type staticCountry string
+
+func (c staticCountry) Country(net.IP) (string, bool) {
+	return string(c), c != ""
+}
+
+func TestParseUserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		ua     string
+		os     string
+		device string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Mobile/15E148 Safari/604.1", "ios", "mobile"},
+		{"Mozilla/5.0 (iPad; CPU OS 14_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Mobile/15E148 Safari/604.1", "ios", "tablet"},
+		{"Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.101 Mobile Safari/537.36", "android", "mobile"},
+		{"Mozilla/5.0 (Linux; Android 9; SM-T820) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.101 Safari/537.36", "android", "tablet"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.102 Safari/537.36", "windows", "desktop"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Safari/605.1.15", "macos", "desktop"},
+		{"Mozilla/5.0 (X11; CrOS x86_64 13310.93.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.133 Safari/537.36", "chromeos", "desktop"},
+		{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:80.0) Gecko/20100101 Firefox/80.0", "linux", "desktop"},
+		{"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.102 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "android", "bot"},
+		{"curl/7.68.0", "", ""},
+		{"", "", ""},
+	} {
+		os, device := parseUserAgent(tc.ua)
+		require.Equal(t, tc.os, os, tc.ua)
+		require.Equal(t, tc.device, device, tc.ua)
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	for header, language := range map[string]string{
+		"":                                "",
+		"*":                               "",
+		"de":                              "de",
+		"pt-BR, pt;q=0.9, en;q=0.8":       "pt-br",
+		"en;q=0.5, fr-CH;q=0.9, de;q=0.9": "fr-ch",
+		"*;q=1, es;q=0.1":                 "es",
+		"de;q=0, en;q=abc":                "en",
+	} {
+		require.Equal(t, language, preferredLanguage(header), header)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	v := fastjson.MustParse(`[{"os":["iOS"],"device":["mobile"],"url":"https://apps.apple.com/app"},{"language":["PT-br"],"country":["br"],"url":"https://example.com/br"}]`)
+	targets, err := parseTargets(v)
+	require.NoError(t, err)
+	require.Equal(t, []storage.TargetRule{
+		{OS: []string{"ios"}, Device: []string{"mobile"}, URL: "https://apps.apple.com/app"},
+		{Language: []string{"pt-br"}, Country: []string{"BR"}, URL: "https://example.com/br"},
+	}, targets)
+
+	for _, body := range []string{
+		`{"os":["ios"]}`,
+		`["https://example.com/"]`,
+		`[{"os":"ios","url":"https://example.com/"}]`,
+		`[{"os":[1],"url":"https://example.com/"}]`,
+	} {
+		_, err := parseTargets(fastjson.MustParse(body))
+		require.Error(t, err, body)
+	}
+}
+
+func TestGetUrl_Targets(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"bob:editor:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:        logger,
+		Storage:       store,
+		principals:    principals,
+		countryHeader: "CF-IPCountry",
+		countryLookup: staticCountry("AT"),
+	}
+
+	saveReq := fasthttp.AcquireRequest()
+	saveReq.Header.SetMethod("POST")
+	saveReq.Header.SetHost("dab")
+	saveReq.Header.SetContentType(contentTypeJSON)
+	saveReq.SetRequestURI("/api/shorten")
+	saveReq.SetBody([]byte(`{"url":"https://example.com/","targets":[` +
+		`{"os":["ios"],"device":["mobile","tablet"],"url":"https://apps.apple.com/app"},` +
+		`{"os":["android"],"url":"https://play.google.com/app"},` +
+		`{"language":["de"],"country":["AT","CH"],"url":"https://example.com/de"}]}`))
+
+	saveRes := fasthttp.AcquireResponse()
+	err = serve(h.saveURL, saveReq, saveRes)
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusOK, saveRes.StatusCode(), string(saveRes.Body()))
+	short := fastjson.GetString(saveRes.Body(), "short")
+
+	iPhone := "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Mobile/15E148 Safari/604.1"
+	windows := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.102 Safari/537.36"
+	for _, tc := range []struct {
+		ua       string
+		language string
+		country  string
+		location string
+	}{
+		{iPhone, "de-AT", "AT", "https://apps.apple.com/app"},
+		{windows, "de-AT", "AT", "https://example.com/de"},
+		{windows, "de-CH, fr;q=0.5", "ch", "https://example.com/de"},
+		{windows, "en-US", "AT", "https://example.com/"},
+		// lookup is used when proxy does not tell country
+		{windows, "de", "", "https://example.com/de"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.Header.SetUserAgent(tc.ua)
+		req.Header.Set(fasthttp.HeaderAcceptLanguage, tc.language)
+		if tc.country != "" {
+			req.Header.Set("CF-IPCountry", tc.country)
+		}
+		req.SetRequestURI("/" + short)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		name := tc.language + " " + tc.country
+		require.Equal(t, fasthttp.StatusMovedPermanently, res.StatusCode(), name)
+		require.Equal(t, tc.location, string(res.Header.Peek(fasthttp.HeaderLocation)), name)
+		// shared caches would serve the destination to other devices
+		require.Equal(t, "no-store, max-age=0", string(res.Header.Peek(fasthttp.HeaderCacheControl)), name)
+	}
+
+	clicks, err := store.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), clicks.Total)
+
+	for _, tc := range []struct {
+		query string
+		rule  int
+		url   string
+	}{
+		{"?user_agent=Mozilla%2F5.0+%28Linux%3B+Android+10%3B+Pixel+3%29+Mobile", 1, "https://play.google.com/app"},
+		{"?accept_language=de-CH&country=ch", 2, "https://example.com/de"},
+		// the tester's own country is used for omitted parameters
+		{"?accept_language=de-CH", 2, "https://example.com/de"},
+		{"?accept_language=en&country=DE", -1, "https://example.com/"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("GET")
+		req.Header.SetHost("dab")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.SetRequestURI("/api/v1/links/" + short + "/targets/test" + tc.query)
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusOK, res.StatusCode(), tc.query)
+		require.Equal(t, tc.url, fastjson.GetString(res.Body(), "url"), tc.query)
+		if tc.rule >= 0 {
+			require.Equal(t, tc.rule, fastjson.GetInt(res.Body(), "rule"), tc.query)
+		} else {
+			require.False(t, fastjson.Exists(res.Body(), "rule"), tc.query)
+		}
+	}
+
+	for _, tc := range []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"targets":{"os":["ios"]}}`, fasthttp.StatusBadRequest, "targets_invalid"},
+		{`{"targets":[{"os":["symbian"],"url":"https://example.com/"}]}`, fasthttp.StatusBadRequest, "targets_invalid"},
+		{`{"targets":[{"os":["ios"],"url":"apps"}]}`, fasthttp.StatusBadRequest, "url_invalid"},
+		{`{"targets":[]}`, fasthttp.StatusOK, ""},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("PUT")
+		req.Header.SetHost("dab")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.SetRequestURI("/api/v1/links/" + short + "/targets")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.body)
+		require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"), tc.body)
+	}
+
+	// links without rules are cached as usual
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("dab")
+	req.Header.SetUserAgent(iPhone)
+	req.SetRequestURI("/" + short)
+
+	res := fasthttp.AcquireResponse()
+
+	err = serve(h.router().dispatch, req, res)
+	require.NoError(t, err)
+
+	require.Equal(t, "https://example.com/", string(res.Header.Peek(fasthttp.HeaderLocation)))
+	require.Empty(t, res.Header.Peek(fasthttp.HeaderCacheControl))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"encoding/json"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+)
+
+// templatesPath is a path of query templates endpoints, single template is addressed by name after it
+const templatesPath = apiPrefix + "/templates"
+
+// writeTemplate writes query template as JSON
+func writeTemplate(ctx *fasthttp.RequestCtx, status int, t storage.QueryTemplate) {
+	body, _ := json.Marshal(t)
+
+	ctx.SetStatusCode(status)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBody(body)
+}
+
+// listTemplates handles HTTP requests on "GET /api/templates" endpoint. Lists query templates ordered by name
+func (h *handler) listTemplates(ctx *fasthttp.RequestCtx) {
+	if _, ok := h.authorize(ctx, roleEditor); !ok {
+		return
+	}
+
+	templates, err := h.Storage.QueryTemplates(ctx.ID())
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	body, _ := json.Marshal(struct {
+		Templates []storage.QueryTemplate `json:"templates"`
+	}{templates})
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentTypeJSON)
+	ctx.SetBody(body)
+}
+
+// getTemplate handles HTTP requests on "GET /api/templates/{name}" endpoint
+func (h *handler) getTemplate(ctx *fasthttp.RequestCtx, name string) {
+	if _, ok := h.authorize(ctx, roleEditor); !ok {
+		return
+	}
+
+	t, err := h.Storage.QueryTemplate(ctx.ID(), name)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	writeTemplate(ctx, fasthttp.StatusOK, t)
+}
+
+// putTemplate handles HTTP requests on "PUT /api/templates/{name}" endpoint.
+// Creates or replaces query template, links referencing it pick the change up on the next redirect
+func (h *handler) putTemplate(ctx *fasthttp.RequestCtx, name string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	v, err := fastjson.ParseBytes(ctx.PostBody())
+	if err != nil {
+		writeError(ctx, errTemplateInvalid, err.Error())
+		return
+	}
+
+	t := storage.QueryTemplate{Name: name}
+	if field := v.Get("params"); field != nil {
+		if t.Params, err = parseQueryParams(field); err != nil {
+			writeError(ctx, errTemplateInvalid, err.Error())
+			return
+		}
+	}
+
+	t, err = h.Storage.PutQueryTemplate(ctx.ID(), p.name, t)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	writeTemplate(ctx, fasthttp.StatusOK, t)
+}
+
+// removeTemplate handles HTTP requests on "DELETE /api/templates/{name}" endpoint.
+// Links referencing removed template keep the reference
+func (h *handler) removeTemplate(ctx *fasthttp.RequestCtx, name string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	if err := h.Storage.RemoveQueryTemplate(ctx.ID(), p.name, name); err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
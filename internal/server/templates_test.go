@@ -0,0 +1,139 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestManageTemplates(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"bob:editor:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"PUT", "/api/v1/templates/spring", `{"params":{"utm_campaign":"spring"}}`, fasthttp.StatusOK, ""},
+		{"PUT", "/api/v1/templates/Spring", `{}`, fasthttp.StatusBadRequest, "template_invalid"},
+		{"PUT", "/api/v1/templates/spring", `{"params":{"ref":"{short}"}}`, fasthttp.StatusBadRequest, "template_invalid"},
+		{"PUT", "/api/v1/templates/spring", `{"params":{"ref":1}}`, fasthttp.StatusBadRequest, "template_invalid"},
+		{"PUT", "/api/v1/templates/spring", `[`, fasthttp.StatusBadRequest, "template_invalid"},
+		{"GET", "/api/v1/templates/spring", "", fasthttp.StatusOK, ""},
+		{"GET", "/api/v1/templates", "", fasthttp.StatusOK, ""},
+		{"DELETE", "/api/v1/templates/spring", "", fasthttp.StatusNoContent, ""},
+		{"DELETE", "/api/v1/templates/spring", "", fasthttp.StatusNotFound, "template_not_found"},
+		{"GET", "/api/v1/templates/spring", "", fasthttp.StatusNotFound, "template_not_found"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(tc.method)
+		req.Header.SetHost("dab")
+		req.SetRequestURI(tc.path)
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, fasthttp.StatusUnauthorized, res.StatusCode(), tc.method+" "+tc.path)
+
+		req.Header.Set("Authorization", "Bearer secret")
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.method+" "+tc.path+" "+tc.body)
+		if tc.code != "" {
+			require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"))
+		}
+
+		switch {
+		case tc.status == fasthttp.StatusOK && tc.path == "/api/v1/templates":
+			require.Equal(t, "spring", fastjson.GetString(res.Body(), "templates", "0", "name"))
+		case tc.status == fasthttp.StatusOK:
+			require.Equal(t, "spring", fastjson.GetString(res.Body(), "params", "utm_campaign"))
+			require.Equal(t, "bob", fastjson.GetString(res.Body(), "editor"))
+		}
+	}
+}
+
+func TestManageLink_Query(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	h := &handler{
+		logger:  logger,
+		Storage: store,
+	}
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{contentTypeJSON, `{"url":"https://example.com/","template":"spring","query":{"utm_content":"{code}"}}`, fasthttp.StatusOK},
+		{contentTypeForm, "url=https%3A%2F%2Fexample.com%2F&template=spring&query.utm_content=%7Bcode%7D", fasthttp.StatusOK},
+		{contentTypeJSON, `{"url":"https://example.com/","template":"Spring"}`, fasthttp.StatusBadRequest},
+		{contentTypeJSON, `{"url":"https://example.com/","query":{"utm_content":"{id}"}}`, fasthttp.StatusBadRequest},
+		{contentTypeJSON, `{"url":"https://example.com/","query":["utm_content"]}`, fasthttp.StatusBadRequest},
+		{contentTypeForm, "url=https%3A%2F%2Fexample.com%2F&query.=mail", fasthttp.StatusBadRequest},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.Header.SetHost("dab")
+		req.Header.SetContentType(tc.contentType)
+		req.Header.Set(fasthttp.HeaderAccept, contentTypeJSON)
+		req.SetRequestURI("/api/v1/shorten")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.body)
+		if tc.status == fasthttp.StatusOK {
+			require.Equal(t, "spring", fastjson.GetString(res.Body(), "template"))
+			require.Equal(t, "{code}", fastjson.GetString(res.Body(), "query", "utm_content"))
+		} else {
+			require.Equal(t, "redirect_invalid", fastjson.GetString(res.Body(), "code"))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"auto/internal/storage"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"hash/fnv"
+	"strconv"
+)
+
+// visitorCookie is a cookie visitors of split links are assigned to variants by
+const visitorCookie = "visitor"
+
+// maxVisitorLength limits length of visitor cookie value, longer ones are replaced
+const maxVisitorLength = 64
+
+// visitorCookieAge defines how long visitors stay in their variants in seconds
+const visitorCookieAge = 365 * 24 * 60 * 60
+
+// visitorKey returns key visitor is assigned to split link variant by. Visitors without cookie are keyed
+// by hash of their IP address and get the key as cookie, so they keep their variant when the address changes
+func visitorKey(ctx *fasthttp.RequestCtx) string {
+	if key := ctx.Request.Header.Cookie(visitorCookie); len(key) > 0 && len(key) <= maxVisitorLength {
+		return string(key)
+	}
+
+	// writes to hash never fail
+	h := fnv.New64a()
+	_, _ = h.Write(ctx.RemoteIP())
+	key := strconv.FormatUint(h.Sum64(), 36)
+
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+	c.SetKey(visitorCookie)
+	c.SetValue(key)
+	c.SetPath("/")
+	c.SetMaxAge(visitorCookieAge)
+	c.SetHTTPOnly(true)
+	c.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	ctx.Response.Header.SetCookie(c)
+
+	return key
+}
+
+// errInvalidVariantsField describes "variants" field that is not an array of objects with "url" and "weight"
+var errInvalidVariantsField = errors.New("field \"variants\" must be an array of objects with \"url\" string" +
+	" and \"weight\" non-negative integer, and can not be sent along with \"url\"")
+
+// parseVariants parses destinations of split link from "variants" field of JSON link creation request,
+// their number and weights are checked by storage
+func parseVariants(body []byte) ([]storage.Variant, error) {
+	v, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Exists("url") {
+		return nil, errInvalidVariantsField
+	}
+
+	items := v.GetArray("variants")
+	if len(items) == 0 {
+		return nil, errInvalidVariantsField
+	}
+
+	variants := make([]storage.Variant, len(items))
+	for i, item := range items {
+		url := item.GetStringBytes("url")
+		weight, err := parseWeight(item.Get("weight"))
+		if len(url) == 0 || err != nil {
+			return nil, errInvalidVariantsField
+		}
+
+		variants[i] = storage.Variant{URL: string(url), Weight: weight}
+	}
+
+	return variants, nil
+}
+
+// errInvalidWeights describes variant weight that is not non-negative integer
+var errInvalidWeights = errors.New("variant weights must be non-negative integers")
+
+// parseWeight parses variant weight from JSON value, upper limit is checked by storage
+func parseWeight(v *fastjson.Value) (uint32, error) {
+	if v == nil {
+		return 0, errInvalidWeights
+	}
+
+	// raw value keeps number as is, so fractions and exponents are rejected rather than rounded
+	weight, err := strconv.ParseUint(v.String(), 10, 32)
+	if err != nil {
+		return 0, errInvalidWeights
+	}
+
+	return uint32(weight), nil
+}
+
+// setVariantWeights handles HTTP requests on "PUT /api/links/{short}/variants" endpoint.
+// Replaces weights of split link variants listed in variant order, visitors are reassigned at once
+func (h *handler) setVariantWeights(ctx *fasthttp.RequestCtx, short string) {
+	p, ok := h.authorize(ctx, roleEditor)
+	if !ok {
+		return
+	}
+
+	v, err := fastjson.ParseBytes(ctx.PostBody())
+	if err != nil {
+		writeError(ctx, errVariantsInvalid, err.Error())
+		return
+	}
+
+	items := v.GetArray("weights")
+	if items == nil {
+		writeError(ctx, errVariantsInvalid, errInvalidWeights.Error())
+		return
+	}
+
+	weights := make([]uint32, len(items))
+	for i, item := range items {
+		if weights[i], err = parseWeight(item); err != nil {
+			writeError(ctx, errVariantsInvalid, err.Error())
+			return
+		}
+	}
+
+	link, err := h.Storage.SetVariantWeights(ctx.ID(), short, weights, p.name)
+	if err != nil {
+		writeStorageError(ctx, err)
+		return
+	}
+
+	h.writeLink(ctx, short, link)
+}
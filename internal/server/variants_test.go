@@ -0,0 +1,166 @@
+package server
+
+import (
+	"auto/internal/storage"
+	mytesting "auto/internal/testing"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastjson"
+	"go.uber.org/zap"
+	"strconv"
+	"testing"
+)
+
+func TestParseVariants(t *testing.T) {
+	variants, err := parseVariants([]byte(`{"variants":[{"url":"https://example.com/a","weight":70},{"url":"https://example.com/b","weight":0}]}`))
+	require.NoError(t, err)
+	require.Equal(t, []storage.Variant{{URL: "https://example.com/a", Weight: 70}, {URL: "https://example.com/b", Weight: 0}}, variants)
+
+	for _, body := range []string{
+		`{"variants":[]}`,
+		`{"variants":{"url":"https://example.com/a","weight":1}}`,
+		`{"variants":[{"url":"https://example.com/a"}]}`,
+		`{"variants":[{"url":"","weight":1}]}`,
+		`{"variants":[{"url":"https://example.com/a","weight":-1}]}`,
+		`{"variants":[{"url":"https://example.com/a","weight":1.5}]}`,
+		`{"variants":[{"url":"https://example.com/a","weight":"1"}]}`,
+		`{"variants":["https://example.com/a"]}`,
+		`{"url":"https://example.com/a","variants":[{"url":"https://example.com/a","weight":1}]}`,
+	} {
+		_, err := parseVariants([]byte(body))
+		require.Error(t, err, body)
+	}
+}
+
+func TestVisitorKey(t *testing.T) {
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetCookie(visitorCookie, "alice")
+	require.Equal(t, "alice", visitorKey(&ctx))
+	require.Empty(t, ctx.Response.Header.PeekCookie(visitorCookie))
+
+	// visitors without cookie get one keyed by their address
+	var first, second fasthttp.RequestCtx
+	key := visitorKey(&first)
+	require.NotEmpty(t, key)
+	require.Equal(t, key, visitorKey(&second))
+
+	var c fasthttp.Cookie
+	c.SetKey(visitorCookie)
+	require.True(t, first.Response.Header.Cookie(&c))
+	require.Equal(t, key, string(c.Value()))
+	require.True(t, c.HTTPOnly())
+}
+
+func TestGetUrl_Variants(t *testing.T) {
+	dir := mytesting.SetTempDir(t)
+	defer mytesting.CleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	store, err := storage.New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = store.Close()
+		require.NoError(t, err)
+	}()
+
+	principals, err := parseTokens([]string{"bob:editor:secret"})
+	require.NoError(t, err)
+
+	h := &handler{
+		logger:     logger,
+		Storage:    store,
+		principals: principals,
+	}
+
+	saveReq := fasthttp.AcquireRequest()
+	saveReq.Header.SetMethod("POST")
+	saveReq.Header.SetHost("dab")
+	saveReq.Header.SetContentType(contentTypeJSON)
+	saveReq.SetRequestURI("/api/shorten")
+	saveReq.SetBody([]byte(`{"variants":[{"url":"https://example.com/a","weight":1},{"url":"https://example.com/b","weight":1}]}`))
+
+	saveRes := fasthttp.AcquireResponse()
+	err = serve(h.saveURL, saveReq, saveRes)
+	require.NoError(t, err)
+	require.Equal(t, fasthttp.StatusOK, saveRes.StatusCode(), string(saveRes.Body()))
+	require.Equal(t, "https://example.com/a", fastjson.GetString(saveRes.Body(), "url"))
+	short := fastjson.GetString(saveRes.Body(), "short")
+
+	link, err := store.GetLink(0, short)
+	require.NoError(t, err)
+
+	redirect := func(method, visitor string) *fasthttp.Response {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(method)
+		req.Header.SetHost("dab")
+		req.Header.SetCookie(visitorCookie, visitor)
+		req.SetRequestURI("/" + short)
+
+		res := fasthttp.AcquireResponse()
+		if method == "HEAD" {
+			res.SkipBody = true
+		}
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+		require.Equal(t, fasthttp.StatusMovedPermanently, res.StatusCode())
+		// redirects are not cached, so weight changes apply at once and every click is counted
+		require.Equal(t, "no-store, max-age=0", string(res.Header.Peek(fasthttp.HeaderCacheControl)))
+
+		return res
+	}
+
+	expected := make([]uint64, len(link.Variants))
+	for i := 0; i < 20; i++ {
+		visitor := "visitor-" + strconv.Itoa(i)
+		split, variant := link.Split(short, visitor)
+		expected[variant]++
+
+		res := redirect("GET", visitor)
+		require.Equal(t, split.URL, string(res.Header.Peek(fasthttp.HeaderLocation)), visitor)
+
+		// link checkers see the same variant and are not counted
+		res = redirect("HEAD", visitor)
+		require.Equal(t, split.URL, string(res.Header.Peek(fasthttp.HeaderLocation)), visitor)
+	}
+
+	clicks, err := store.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), clicks.Total)
+	require.Equal(t, expected, clicks.Variants)
+
+	for _, tc := range []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"weights":[1]}`, fasthttp.StatusBadRequest, "variants_invalid"},
+		{`{"weights":[1,-1]}`, fasthttp.StatusBadRequest, "variants_invalid"},
+		{`{"weights":"1,1"}`, fasthttp.StatusBadRequest, "variants_invalid"},
+		{`{"weights":[0,0]}`, fasthttp.StatusBadRequest, "variants_invalid"},
+		{`{"weights":[0,1]}`, fasthttp.StatusOK, ""},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod("PUT")
+		req.Header.SetHost("dab")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.SetRequestURI("/api/v1/links/" + short + "/variants")
+		req.SetBody([]byte(tc.body))
+
+		res := fasthttp.AcquireResponse()
+
+		err = serve(h.router().dispatch, req, res)
+		require.NoError(t, err)
+
+		require.Equal(t, tc.status, res.StatusCode(), tc.body)
+		require.Equal(t, tc.code, fastjson.GetString(res.Body(), "code"), tc.body)
+	}
+
+	// every visitor moves to the only variant left
+	for i := 0; i < 5; i++ {
+		res := redirect("GET", "visitor-"+strconv.Itoa(i))
+		require.Equal(t, "https://example.com/b", string(res.Header.Peek(fasthttp.HeaderLocation)))
+	}
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	// auditPrefix marks keys of audit log entries.
+	// Entry key layout is auditPrefix + big endian entry sequence number so entries are iterated in order
+	auditPrefix = []byte("audit/")
+	// auditHeadKey holds sequence number and hash of the last audit log entry
+	auditHeadKey = []byte("audit-head")
+)
+
+// audit log actions
+const (
+	ActionLinkUpdate     = "link.update"
+	ActionLinkRevert     = "link.revert"
+	ActionLinkRedirect   = "link.redirect"
+	ActionLinkVariants   = "link.variants"
+	ActionLinkTargets    = "link.targets"
+	ActionLinkDisable    = "link.disable"
+	ActionLinkEnable     = "link.enable"
+	ActionLinkDelete     = "link.delete"
+	ActionLinkPurge      = "link.purge"
+	ActionIndexRebuild   = "index.rebuild"
+	ActionStoreRepair    = "store.repair"
+	ActionPolicyAdd      = "policy.add"
+	ActionPolicyRemove   = "policy.remove"
+	ActionTemplatePut    = "template.put"
+	ActionTemplateRemove = "template.remove"
+)
+
+// AuditEntry defines single administrative operation record.
+// Hash covers every other field including hash of the previous entry, so changing or removing any entry breaks the chain
+type AuditEntry struct {
+	Seq       uint64          `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Object    string          `json:"object"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID uint64          `json:"request_id"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// AuditQuery defines filters and page for AuditLog. Empty filters match every entry
+type AuditQuery struct {
+	Actor  string
+	Action string
+	Object string
+	// AfterSeq skips entries up to this sequence number inclusive
+	AfterSeq uint64
+	Limit    int
+}
+
+// AuditPage holds single page of audit log entries and sequence number to continue from (zero for the last page)
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	NextSeq uint64       `json:"next_seq"`
+}
+
+// AuditReport describes result of audit log hash chain verification
+type AuditReport struct {
+	Entries uint64 `json:"entries"`
+	Valid   bool   `json:"valid"`
+	// BrokenSeq is sequence number of the first entry that does not match the chain
+	BrokenSeq uint64 `json:"broken_seq,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// auditHead defines value stored under auditHeadKey
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// auditKey returns audit log entry key for sequence number
+func auditKey(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+
+	return append(append([]byte{}, auditPrefix...), buf...)
+}
+
+// hashEntry returns hex encoded SHA-256 of entry serialized with empty hash
+func hashEntry(entry AuditEntry) string {
+	entry.Hash = ""
+
+	// marshalling can not fail as entry Before and After hold valid JSON written by storage only
+	b, _ := json.Marshal(entry)
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// appendAudit chains new entry stamped with now time to audit log inside provided transaction.
+// Transactions appending concurrently conflict on audit head, so they have to be run with Storage.update
+func appendAudit(txn *badger.Txn, entry AuditEntry, now time.Time) error {
+	var head auditHead
+
+	item, err := txn.Get(auditHeadKey)
+	switch {
+	case err == nil:
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(value, &head); err != nil {
+			return err
+		}
+	case !errors.Is(err, badger.ErrKeyNotFound):
+		return err
+	}
+
+	entry.Seq = head.Seq + 1
+	entry.Time = now.UTC()
+	entry.PrevHash = head.Hash
+	entry.Hash = hashEntry(entry)
+
+	b, _ := json.Marshal(entry)
+	if err := txn.Set(auditKey(entry.Seq), b); err != nil {
+		return err
+	}
+
+	b, _ = json.Marshal(auditHead{Seq: entry.Seq, Hash: entry.Hash})
+
+	return txn.Set(auditHeadKey, b)
+}
+
+// linkAudit builds audit log entry for link change
+func linkAudit(reqID uint64, actor, action, short string, before, after *Link) AuditEntry {
+	entry := AuditEntry{
+		Actor:     actor,
+		Action:    action,
+		Object:    short,
+		RequestID: reqID,
+	}
+
+	if before != nil {
+		entry.Before = encodeLink(*before)
+	}
+	if after != nil {
+		entry.After = encodeLink(*after)
+	}
+
+	return entry
+}
+
+// AuditLog returns audit log entries matching query ordered by sequence number
+func (s *Storage) AuditLog(reqID uint64, q AuditQuery) (AuditPage, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	page := AuditPage{Entries: make([]AuditEntry, 0)}
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = auditPrefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(auditKey(q.AfterSeq + 1)); it.ValidForPrefix(auditPrefix); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			failpoint.Inject("auditValueCopyErr", func() {
+				err = errors.New("mock audit value copy error")
+			})
+			if err != nil {
+				return err
+			}
+
+			var entry AuditEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+
+			if (q.Actor != "" && entry.Actor != q.Actor) ||
+				(q.Action != "" && entry.Action != q.Action) ||
+				(q.Object != "" && entry.Object != q.Object) {
+				continue
+			}
+
+			if len(page.Entries) == limit {
+				page.NextSeq = page.Entries[limit-1].Seq
+				break
+			}
+
+			page.Entries = append(page.Entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("retrieving audit log", zap.Error(err))
+		return AuditPage{}, err
+	}
+
+	return page, nil
+}
+
+// VerifyAudit walks the whole audit log checking hash chain and reports the first broken entry
+func (s *Storage) VerifyAudit(reqID uint64) (AuditReport, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	var report AuditReport
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		report, err = verifyAudit(txn)
+		return err
+	})
+	if err != nil {
+		logger.Error("verifying audit log", zap.Error(err))
+		return AuditReport{}, err
+	}
+
+	return report, nil
+}
+
+// verifyAudit checks audit log hash chain inside provided transaction
+func verifyAudit(txn *badger.Txn) (AuditReport, error) {
+	report := AuditReport{Valid: true}
+	broken := func(seq uint64, reason string) (AuditReport, error) {
+		report.Valid = false
+		report.BrokenSeq = seq
+		report.Reason = reason
+		return report, nil
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = auditPrefix
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var prev auditHead
+	for it.Seek(auditPrefix); it.ValidForPrefix(auditPrefix); it.Next() {
+		item := it.Item()
+		expected := prev.Seq + 1
+		seq := binary.BigEndian.Uint64(item.Key()[len(auditPrefix):])
+
+		value, err := item.ValueCopy(nil)
+		failpoint.Inject("verifyAuditValueCopyErr", func() {
+			err = errors.New("mock verify audit value copy error")
+		})
+		if err != nil {
+			return AuditReport{}, err
+		}
+
+		if seq != expected {
+			return broken(expected, fmt.Sprintf("entry is missing, next stored entry is %d", seq))
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return broken(seq, "entry can not be parsed: "+err.Error())
+		}
+
+		switch {
+		case entry.Seq != seq:
+			return broken(seq, fmt.Sprintf("entry holds sequence number %d", entry.Seq))
+		case entry.PrevHash != prev.Hash:
+			return broken(seq, "previous hash does not match previous entry")
+		case hashEntry(entry) != entry.Hash:
+			return broken(seq, "hash does not match entry content")
+		}
+
+		prev = auditHead{Seq: seq, Hash: entry.Hash}
+		report.Entries++
+	}
+
+	var head auditHead
+	item, err := txn.Get(auditHeadKey)
+	switch {
+	case err == nil:
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return AuditReport{}, err
+		}
+		if err := json.Unmarshal(value, &head); err != nil {
+			return broken(prev.Seq+1, "head can not be parsed: "+err.Error())
+		}
+	case !errors.Is(err, badger.ErrKeyNotFound):
+		return AuditReport{}, err
+	}
+
+	if head != prev {
+		// entries after the last stored one have been removed
+		return broken(prev.Seq+1, fmt.Sprintf("head points to entry %d", head.Seq))
+	}
+
+	return report, nil
+}
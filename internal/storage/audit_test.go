@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.SetClock(func() time.Time { return now })
+
+	short, err := s.SaveURL(0, "https://example.com/v1")
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(1, short, "https://example.com/v2", "bob")
+	require.NoError(t, err)
+
+	_, err = s.Disable(2, short, "carol")
+	require.NoError(t, err)
+
+	err = s.Purge(3, short, "root")
+	require.NoError(t, err)
+
+	page, err := s.AuditLog(0, AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 3)
+	require.Zero(t, page.NextSeq)
+
+	update := page.Entries[0]
+	require.Equal(t, uint64(1), update.Seq)
+	require.Equal(t, "bob", update.Actor)
+	require.Equal(t, ActionLinkUpdate, update.Action)
+	require.Equal(t, short, update.Object)
+	require.Equal(t, uint64(1), update.RequestID)
+	require.Equal(t, now, update.Time)
+	require.Empty(t, update.PrevHash)
+	require.Equal(t, "https://example.com/v1", linkURL(t, update.Before))
+	require.Equal(t, "https://example.com/v2", linkURL(t, update.After))
+
+	require.Equal(t, ActionLinkDisable, page.Entries[1].Action)
+	require.Equal(t, update.Hash, page.Entries[1].PrevHash)
+
+	require.Equal(t, ActionLinkPurge, page.Entries[2].Action)
+	require.Nil(t, page.Entries[2].After)
+
+	page, err = s.AuditLog(0, AuditQuery{Actor: "carol"})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, ActionLinkDisable, page.Entries[0].Action)
+
+	page, err = s.AuditLog(0, AuditQuery{Object: short, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, uint64(1), page.NextSeq)
+
+	page, err = s.AuditLog(0, AuditQuery{Object: short, Limit: 2, AfterSeq: page.NextSeq})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	require.Equal(t, uint64(2), page.Entries[0].Seq)
+	require.Zero(t, page.NextSeq)
+
+	report, err := s.VerifyAudit(0)
+	require.NoError(t, err)
+	require.Equal(t, AuditReport{Entries: 3, Valid: true}, report)
+}
+
+// linkURL extracts URL from link record saved in audit log entry
+func linkURL(t *testing.T, raw json.RawMessage) string {
+	link, err := decodeLink(raw)
+	require.NoError(t, err)
+
+	return link.URL
+}
+
+func TestAuditLog_ErrValueCopy(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"auditValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "auditValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.RebuildIndex(0, "cli")
+	require.NoError(t, err)
+
+	_, err = s.AuditLog(0, AuditQuery{})
+	require.Equal(t, errors.New("mock audit value copy error"), err)
+}
+
+func TestVerifyAudit_Tampered(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = s.Disable(0, short, "bob")
+		require.NoError(t, err)
+		_, err = s.Enable(0, short, "bob")
+		require.NoError(t, err)
+	}
+
+	readEntry := func(seq uint64) AuditEntry {
+		var entry AuditEntry
+		err := s.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(auditKey(seq))
+			require.NoError(t, err)
+			value, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			return json.Unmarshal(value, &entry)
+		})
+		require.NoError(t, err)
+		return entry
+	}
+	writeEntry := func(entry AuditEntry) {
+		b, err := json.Marshal(entry)
+		require.NoError(t, err)
+		err = s.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(auditKey(entry.Seq), b)
+		})
+		require.NoError(t, err)
+	}
+
+	// rewriting actor without fixing hash
+	original := readEntry(3)
+	tampered := original
+	tampered.Actor = "mallory"
+	writeEntry(tampered)
+
+	report, err := s.VerifyAudit(0)
+	require.NoError(t, err)
+	require.Equal(t, AuditReport{Entries: 2, BrokenSeq: 3, Reason: "hash does not match entry content"}, report)
+
+	// rewriting actor with recalculated hash breaks the next link of the chain
+	tampered.Hash = hashEntry(tampered)
+	writeEntry(tampered)
+
+	report, err = s.VerifyAudit(0)
+	require.NoError(t, err)
+	require.Equal(t, AuditReport{Entries: 3, BrokenSeq: 4, Reason: "previous hash does not match previous entry"}, report)
+
+	writeEntry(original)
+
+	// removing entry from the middle
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(auditKey(5))
+	})
+	require.NoError(t, err)
+
+	report, err = s.VerifyAudit(0)
+	require.NoError(t, err)
+	require.Equal(t, AuditReport{Entries: 4, BrokenSeq: 5, Reason: "entry is missing, next stored entry is 6"}, report)
+
+	// removing the tail
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(auditKey(6))
+	})
+	require.NoError(t, err)
+
+	report, err = s.VerifyAudit(0)
+	require.NoError(t, err)
+	require.Equal(t, AuditReport{Entries: 4, BrokenSeq: 5, Reason: "head points to entry 6"}, report)
+}
+
+func TestVerifyAudit_ErrValueCopy(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"verifyAuditValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "verifyAuditValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.RebuildIndex(0, "cli")
+	require.NoError(t, err)
+
+	_, err = s.VerifyAudit(0)
+	require.Equal(t, errors.New("mock verify audit value copy error"), err)
+}
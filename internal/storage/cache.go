@@ -0,0 +1,62 @@
+package storage
+
+import "sync"
+
+// defaultCacheSize limits number of link records kept in memory
+const defaultCacheSize = 10000
+
+// linkCache keeps recently resolved link records to serve redirects without database lookups.
+// Every invalidation bumps generation so records read before invalidation are never cached after it
+type linkCache struct {
+	mu         sync.RWMutex
+	size       int
+	generation uint64
+	links      map[uint64]Link
+}
+
+// newLinkCache constructs linkCache holding up to size records
+func newLinkCache(size int) *linkCache {
+	return &linkCache{
+		size:  size,
+		links: make(map[uint64]Link),
+	}
+}
+
+// get returns cached link record and current generation to be passed to put after database lookup
+func (c *linkCache) get(id uint64) (Link, bool, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	link, ok := c.links[id]
+
+	return link, ok, c.generation
+}
+
+// put caches link record unless cache has been invalidated since generation has been obtained
+func (c *linkCache) put(id uint64, link Link, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if len(c.links) >= c.size {
+		// map iteration order is random so it is a cheap random eviction
+		for k := range c.links {
+			delete(c.links, k)
+			break
+		}
+	}
+
+	c.links[id] = link
+}
+
+// invalidate drops cached link record
+func (c *linkCache) invalidate(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.links, id)
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLinkCache(t *testing.T) {
+	c := newLinkCache(2)
+
+	_, ok, generation := c.get(1)
+	require.False(t, ok)
+
+	c.put(1, Link{URL: "a"}, generation)
+	link, ok, _ := c.get(1)
+	require.True(t, ok)
+	require.Equal(t, "a", link.URL)
+
+	// record read before invalidation must not be cached
+	_, _, stale := c.get(2)
+	c.invalidate(1)
+	c.put(2, Link{URL: "b"}, stale)
+	_, ok, _ = c.get(2)
+	require.False(t, ok)
+	_, ok, _ = c.get(1)
+	require.False(t, ok)
+
+	_, _, generation = c.get(0)
+	c.put(1, Link{URL: "a"}, generation)
+	c.put(2, Link{URL: "b"}, generation)
+	c.put(3, Link{URL: "c"}, generation)
+	require.Len(t, c.links, 2)
+}
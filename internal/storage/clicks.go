@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clicksPrefix marks keys of link click counters. Counter key layout is clicksPrefix + link ID.
+// Counters are kept apart from link records, so clicks neither create versions nor invalidate cached records
+var clicksPrefix = []byte("clicks/")
+
+// clickDeltaPrefix marks keys of single clicks not folded into counter yet.
+// Delta key layout is clickDeltaPrefix + link ID + unique click ID.
+// Every click is a new key no transaction reads before writing, so concurrent clicks never conflict
+var clickDeltaPrefix = []byte("clicks-delta/")
+
+// clickFoldInterval is number of counted clicks between folds of clicked link deltas into its counter
+const clickFoldInterval = 256
+
+// Clicks defines click totals of link
+type Clicks struct {
+	Total  uint64     `json:"total"`
+	LastAt *time.Time `json:"last_at,omitempty"`
+	// Variants holds clicks of split link variants in variant order
+	Variants []uint64 `json:"variants,omitempty"`
+}
+
+// clickDelta defines single click not folded into counter yet
+type clickDelta struct {
+	At time.Time `json:"at"`
+	// Variant is index of split link variant clicked, -1 if link is not split
+	Variant int `json:"variant"`
+}
+
+// add counts click at provided time, variant -1 counts click of link which is not split
+func (c *Clicks) add(at time.Time, variant int) {
+	c.Total++
+	if c.LastAt == nil || at.After(*c.LastAt) {
+		c.LastAt = &at
+	}
+	if variant >= 0 {
+		for len(c.Variants) <= variant {
+			c.Variants = append(c.Variants, 0)
+		}
+		c.Variants[variant]++
+	}
+}
+
+// keyedMutex holds mutex per key, so holding one key never blocks others.
+// Mutexes are dropped as soon as nobody holds or waits for them
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[uint64]*keyedLock
+}
+
+// keyedLock defines mutex of key with number of its holders and waiters
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks mutex of key and returns function unlocking it
+func (m *keyedMutex) lock(key uint64) func() {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[uint64]*keyedLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		m.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// clicksKey returns click counter key for link ID
+func clicksKey(id uint64) []byte {
+	return append(append([]byte{}, clicksPrefix...), utob(id)...)
+}
+
+// clickDeltaKeyPrefix returns prefix of all click delta keys for link ID
+func clickDeltaKeyPrefix(id uint64) []byte {
+	return append(append([]byte{}, clickDeltaPrefix...), utob(id)...)
+}
+
+// clickDeltaKey returns new unique click delta key for link ID
+func clickDeltaKey(id uint64) []byte {
+	return append(clickDeltaKeyPrefix(id), xid.New().Bytes()...)
+}
+
+// readClicks reads click totals of link ID inside provided transaction, links never clicked have zero totals
+func readClicks(txn *badger.Txn, id uint64) (Clicks, error) {
+	clicks, _, err := sumClicks(txn, id)
+	return clicks, err
+}
+
+// sumClicks reads click counter of link ID and adds its deltas to it inside provided transaction.
+// Keys of deltas added are returned, so they can be deleted once totals are written back to counter
+func sumClicks(txn *badger.Txn, id uint64) (Clicks, [][]byte, error) {
+	var clicks Clicks
+
+	item, err := txn.Get(clicksKey(id))
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+	case err != nil:
+		return clicks, nil, err
+	default:
+		err = item.Value(func(value []byte) error {
+			return json.Unmarshal(value, &clicks)
+		})
+		if err != nil {
+			return clicks, nil, err
+		}
+	}
+
+	var deltas [][]byte
+
+	prefix := clickDeltaKeyPrefix(id)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		var delta clickDelta
+		err := it.Item().Value(func(value []byte) error {
+			return json.Unmarshal(value, &delta)
+		})
+		if err != nil {
+			return clicks, nil, err
+		}
+
+		clicks.add(delta.At, delta.Variant)
+		deltas = append(deltas, it.Item().KeyCopy(nil))
+	}
+
+	return clicks, deltas, nil
+}
+
+// writeClicks writes click totals of link ID to its counter and deletes deltas already added to them
+func writeClicks(txn *badger.Txn, id uint64, clicks Clicks, deltas [][]byte) error {
+	value, _ := json.Marshal(clicks)
+
+	if err := txn.Set(clicksKey(id), value); err != nil {
+		return err
+	}
+
+	for _, key := range deltas {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// foldClicks moves click deltas of link ID into its counter, so reading totals does not walk every click.
+// Clicks counted meanwhile are kept as deltas, as fold deletes only deltas it has read
+func (s *Storage) foldClicks(id uint64) error {
+	return s.update(func(txn *badger.Txn) error {
+		clicks, deltas, err := sumClicks(txn, id)
+		if err != nil || len(deltas) == 0 {
+			return err
+		}
+
+		return writeClicks(txn, id, clicks, deltas)
+	})
+}
+
+// checkClicks returns ErrShortGone if click limited link of ID has been clicked as many times as allowed
+func (s *Storage) checkClicks(reqID, id uint64, link Link) error {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	var clicks Clicks
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		clicks, err = readClicks(txn, id)
+		return err
+	})
+	failpoint.Inject("checkClicksErr", func() {
+		err = errors.New("mock check clicks error")
+	})
+	if err != nil {
+		logger.Error("retrieving link clicks", zap.Error(err))
+		return err
+	}
+
+	if clicks.Total >= link.MaxClicks {
+		return ErrShortGone
+	}
+
+	return nil
+}
+
+// Click returns active link record referenced by short string ID and counts its click.
+// Split links return URL of variant visitor is assigned to, its click is counted for the variant as well.
+// Counting failure is logged only, as redirect must not fail because of statistics, unless link is click limited:
+// its click is counted in the same transaction the limit is checked in, so concurrent clicks never overshoot it
+func (s *Storage) Click(reqID uint64, short, visitor string) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, link, err := s.getLink(reqID, short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	now := s.now().UTC()
+	if err := link.checkActive(now); err != nil {
+		return Link{}, err
+	}
+
+	link, variant := link.Split(short, visitor)
+
+	if link.MaxClicks > 0 {
+		defer s.clickLocks.lock(id)()
+
+		err = s.update(func(txn *badger.Txn) error {
+			clicks, deltas, err := sumClicks(txn, id)
+			if err != nil {
+				return err
+			}
+
+			if clicks.Total >= link.MaxClicks {
+				return ErrShortGone
+			}
+
+			clicks.add(now, variant)
+
+			return writeClicks(txn, id, clicks, deltas)
+		})
+	} else {
+		value, _ := json.Marshal(clickDelta{At: now, Variant: variant})
+
+		err = s.update(func(txn *badger.Txn) error {
+			return txn.Set(clickDeltaKey(id), value)
+		})
+		if err == nil && atomic.AddUint64(&s.clicks, 1)%clickFoldInterval == 0 {
+			if err := s.foldClicks(id); err != nil {
+				logger.Error("folding clicks", zap.String("short", short), zap.Error(err))
+			}
+		}
+	}
+	failpoint.Inject("clickErr", func() {
+		err = errors.New("mock click error")
+	})
+	if errors.Is(err, ErrShortGone) {
+		return Link{}, err
+	}
+	if err != nil {
+		logger.Error("counting click", zap.String("short", short), zap.Error(err))
+		if link.MaxClicks > 0 {
+			return Link{}, err
+		}
+	}
+
+	return link, nil
+}
+
+// LinkClicks returns click totals of link referenced by short string ID whatever its status is
+func (s *Storage) LinkClicks(reqID uint64, short string) (Clicks, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Clicks{}, err
+	}
+
+	var clicks Clicks
+	err = s.db.View(func(txn *badger.Txn) error {
+		link, err := readLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		clicks, err = readClicks(txn, id)
+		// variants never clicked have zero clicks
+		for len(clicks.Variants) < len(link.Variants) {
+			clicks.Variants = append(clicks.Variants, 0)
+		}
+		return err
+	})
+	failpoint.Inject("linkClicksErr", func() {
+		err = errors.New("mock link clicks error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("retrieving link clicks", zap.Error(err))
+		}
+		return Clicks{}, err
+	}
+
+	return clicks, nil
+}
@@ -0,0 +1,483 @@
+package storage
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClick(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	clicks, err := s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, Clicks{}, clicks)
+
+	for i := 0; i < 3; i++ {
+		link, err := s.Click(0, short, "")
+		require.NoError(t, err)
+		require.Equal(t, "https://example.com/", link.URL)
+	}
+
+	clicks, err = s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), clicks.Total)
+	require.NotNil(t, clicks.LastAt)
+
+	// clicks do not create link versions
+	link, err := s.LookupLink(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), link.Version)
+
+	_, err = s.Disable(0, short, "bob")
+	require.NoError(t, err)
+
+	_, err = s.Click(0, short, "")
+	require.Equal(t, ErrShortGone, err)
+
+	clicks, err = s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), clicks.Total)
+
+	err = s.Purge(0, short, "root")
+	require.NoError(t, err)
+
+	_, err = s.LinkClicks(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	err = s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(clicksKey(0))
+		return err
+	})
+	require.Equal(t, badger.ErrKeyNotFound, err)
+	require.Zero(t, countClickDeltas(t, s, 0))
+
+	_, err = s.LinkClicks(0, s.encodeID(100))
+	require.Equal(t, ErrShortNotExist, err)
+}
+
+func TestClick_Concurrent(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	const clicks = 500
+
+	var wg sync.WaitGroup
+	for i := 0; i < clicks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Click(0, short, "")
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// every click is counted, folds included
+	total, err := s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(clicks), total.Total)
+	require.Less(t, countClickDeltas(t, s, 0), clicks)
+
+	err = s.foldClicks(0)
+	require.NoError(t, err)
+	require.Zero(t, countClickDeltas(t, s, 0))
+
+	total, err = s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(clicks), total.Total)
+}
+
+// countClickDeltas returns number of click deltas of link ID not folded into its counter yet
+func countClickDeltas(t *testing.T, s *Storage, id uint64) int {
+	var deltas [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		_, deltas, err = sumClicks(txn, id)
+		return err
+	})
+	require.NoError(t, err)
+
+	return len(deltas)
+}
+
+func TestClick_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"clickErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "clickErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	// redirect does not fail because of statistics
+	link, err := s.Click(0, short, "")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/", link.URL)
+}
+
+func TestClick_MaxClicks(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	once, err := s.SaveLink(0, Link{URL: "https://example.com/invite", MaxClicks: 1})
+	require.NoError(t, err)
+
+	_, err = s.GetLink(0, once)
+	require.NoError(t, err)
+
+	_, err = s.Click(0, once, "")
+	require.NoError(t, err)
+
+	_, err = s.Click(0, once, "")
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.GetLink(0, once)
+	require.Equal(t, ErrShortGone, err)
+
+	// exhausted links keep their status
+	link, err := s.LookupLink(0, once)
+	require.NoError(t, err)
+	require.Equal(t, StatusActive, link.Status)
+
+	short, err := s.SaveLink(0, Link{URL: "https://example.com/invite", MaxClicks: 10})
+	require.NoError(t, err)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		outcomes = map[error]int{}
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.Click(0, short, "")
+
+			mu.Lock()
+			outcomes[err]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, map[error]int{nil: 10, ErrShortGone: 40}, outcomes)
+
+	clicks, err := s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), clicks.Total)
+}
+
+func TestClick_MaxClicksErr(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveLink(0, Link{URL: "https://example.com/invite", MaxClicks: 1})
+	require.NoError(t, err)
+
+	for _, fp := range []string{"clickErr", "checkClicksErr"} {
+		err = failpoint.Enable(packagePath+fp, "return(true)")
+		require.NoError(t, err)
+
+		// limit can not be checked, so link does not redirect
+		if fp == "clickErr" {
+			_, err = s.Click(0, short, "")
+			require.Equal(t, errors.New("mock click error"), err)
+		} else {
+			_, err = s.GetLink(0, short)
+			require.Equal(t, errors.New("mock check clicks error"), err)
+		}
+
+		err = failpoint.Disable(packagePath + fp)
+		require.NoError(t, err)
+	}
+}
+
+func TestLinkClicks_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"linkClicksErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "linkClicksErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	_, err = s.LinkClicks(0, short)
+	require.Equal(t, errors.New("mock link clicks error"), err)
+}
+
+func TestCreateLink_Expiry(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	past := time.Now().Add(-time.Minute)
+	_, _, err = s.CreateLink(0, Link{URL: "https://example.com/", ExpiresAt: &past})
+	require.True(t, errors.Is(err, ErrInvalidExpiry))
+
+	soon := time.Now().Add(200 * time.Millisecond)
+	short, link, err := s.CreateLink(0, Link{URL: "https://example.com/", ExpiresAt: &soon})
+	require.NoError(t, err)
+	require.True(t, soon.Equal(*link.ExpiresAt))
+
+	_, err = s.GetLink(0, short)
+	require.NoError(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+
+	_, err = s.GetLink(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.Click(0, short, "")
+	require.Equal(t, ErrShortGone, err)
+
+	// expired links keep their status
+	link, err = s.LookupLink(0, short)
+	require.NoError(t, err)
+	require.Equal(t, StatusActive, link.Status)
+}
+
+func TestCreateLink_Activation(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.SetClock(func() time.Time {
+		return now
+	})
+
+	launch := now.Add(time.Hour).In(time.FixedZone("CET", 3600))
+	end := now.Add(2 * time.Hour)
+	for _, link := range []Link{
+		{URL: "https://example.com/", Fallback: "https://example.com/soon"},
+		{URL: "https://example.com/", NotBefore: &end, ExpiresAt: &end},
+		{URL: "https://example.com/", NotBefore: &launch, Fallback: "/soon"},
+		{URL: "https://example.com/", NotBefore: &launch, Fallback: "ftp://example.com/soon"},
+	} {
+		_, _, err = s.CreateLink(0, link)
+		require.True(t, errors.Is(err, ErrInvalidActivation), link.Fallback)
+	}
+
+	short, link, err := s.CreateLink(0, Link{URL: "https://example.com/", NotBefore: &launch, ExpiresAt: &end})
+	require.NoError(t, err)
+	require.Equal(t, time.UTC, link.NotBefore.Location())
+	require.True(t, launch.Equal(*link.NotBefore))
+	require.Equal(t, now, link.CreatedAt)
+
+	_, err = s.GetLink(0, short)
+	require.Equal(t, ErrShortNotActive, err)
+
+	_, err = s.Click(0, short, "")
+	require.Equal(t, ErrShortNotActive, err)
+
+	now = launch
+	_, err = s.GetLink(0, short)
+	require.NoError(t, err)
+
+	_, err = s.Click(0, short, "")
+	require.NoError(t, err)
+
+	now = end
+	_, err = s.GetLink(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	// activation time in the past makes link active since creation
+	now = end.Add(time.Hour)
+	short, err = s.SaveLink(0, Link{URL: "https://example.com/", NotBefore: &launch})
+	require.NoError(t, err)
+
+	_, err = s.GetLink(0, short)
+	require.NoError(t, err)
+}
+
+func TestKeyedMutex(t *testing.T) {
+	var m keyedMutex
+
+	unlock := m.lock(1)
+
+	// other keys are not blocked by held one
+	done := make(chan struct{})
+	go func() {
+		m.lock(2)()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lock of other key is blocked")
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		m.lock(1)()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("lock of held key is not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-locked
+
+	// mutexes nobody holds are dropped
+	require.Empty(t, m.locks)
+}
+
+func TestLinkState(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	now := time.Now()
+	s.SetClock(func() time.Time { return now })
+
+	expiresAt := now.Add(time.Hour)
+	expiring, err := s.SaveLink(0, Link{URL: "https://example.com/expiring", ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	limited, err := s.SaveLink(0, Link{URL: "https://example.com/limited", MaxClicks: 1})
+	require.NoError(t, err)
+
+	disabled, err := s.SaveLink(0, Link{URL: "https://example.com/disabled", MaxClicks: 1})
+	require.NoError(t, err)
+	_, err = s.Disable(0, disabled, "bob")
+	require.NoError(t, err)
+
+	for short, status := range map[string]LinkStatus{expiring: StatusActive, limited: StatusActive, disabled: StatusDisabled} {
+		link, err := s.LinkState(0, short)
+		require.NoError(t, err)
+		require.Equal(t, status, link.Status, short)
+	}
+
+	_, err = s.Click(0, limited, "")
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+
+	// stored status of links which do not redirect anymore is kept
+	for short, status := range map[string]LinkStatus{expiring: StatusExpired, limited: StatusExhausted, disabled: StatusDisabled} {
+		link, err := s.LinkState(0, short)
+		require.NoError(t, err)
+		require.Equal(t, status, link.Status, short)
+
+		link, err = s.LookupLink(0, short)
+		require.NoError(t, err)
+		require.NotEqual(t, StatusExpired, link.Status)
+		require.NotEqual(t, StatusExhausted, link.Status)
+	}
+
+	_, err = s.LinkState(0, s.encodeID(100))
+	require.Equal(t, ErrShortNotExist, err)
+}
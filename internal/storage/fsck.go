@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"auto/internal/linkurl"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consistency checks
+const (
+	CheckKey      = "key"
+	CheckValue    = "value"
+	CheckSequence = "sequence"
+	CheckIndex    = "index"
+	CheckDatabase = "database"
+)
+
+// Finding describes single inconsistency found by Check
+type Finding struct {
+	Check    string `json:"check"`
+	Key      string `json:"key"`
+	Short    string `json:"short,omitempty"`
+	Message  string `json:"message"`
+	Repaired bool   `json:"repaired"`
+}
+
+// CheckReport describes result of offline consistency check
+type CheckReport struct {
+	Links    uint64    `json:"links"`
+	Findings []Finding `json:"findings"`
+}
+
+// Healthy reports whether every finding has been repaired
+func (r CheckReport) Healthy() bool {
+	for _, f := range r.Findings {
+		if !f.Repaired {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check opens database at path and verifies that link keys round-trip through short forms,
+// link records parse and hold valid URLs, sequence lease is ahead of the highest link ID ever used
+// and search index agrees with link records.
+// Database is opened read-only unless repair is set, in which case sequence lease and index are fixed
+// and the repair is recorded in audit log on behalf of actor. Databases not closed cleanly can not be opened
+// read-only, they are reported as single finding asking for repair, which replays their log first
+func Check(logger *zap.Logger, path string, repair bool, actor string) (CheckReport, error) {
+	if logger == nil {
+		return CheckReport{}, errors.New("no logger provided")
+	}
+
+	db, err := badger.Open(badger.DefaultOptions(path).WithReadOnly(!repair))
+	failpoint.Inject("checkOpenDatabaseErr", func() {
+		err = errors.New("mock check open database error")
+	})
+	if !repair && isReplayNeeded(err) {
+		logger.Warn("database has not been closed cleanly", zap.String("path", path))
+		return CheckReport{Findings: []Finding{{
+			Check:   CheckDatabase,
+			Message: "database has not been closed cleanly and can not be checked read-only, run fsck with --repair to replay its log",
+		}}}, nil
+	}
+	if err != nil {
+		logger.Error("opening database", zap.String("path", path), zap.Error(err))
+		return CheckReport{}, err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Error("closing database", zap.Error(err))
+		}
+	}()
+
+	hashID, err := newHashID()
+	if err != nil {
+		logger.Error("generating new hashID", zap.Error(err))
+		return CheckReport{}, err
+	}
+
+	c := checker{
+		s:        &Storage{logger: logger, db: db, hashID: hashID, now: time.Now},
+		report:   CheckReport{Findings: make([]Finding, 0)},
+		expected: make(map[string]uint64),
+		indexed:  make(map[string]uint64),
+		broken:   make(map[uint64]bool),
+	}
+
+	if err := db.View(c.scan); err != nil {
+		logger.Error("scanning database", zap.Error(err))
+		return CheckReport{}, err
+	}
+
+	c.compare()
+
+	if repair {
+		if err := c.repair(actor); err != nil {
+			logger.Error("repairing database", zap.Error(err))
+			return CheckReport{}, err
+		}
+	}
+
+	return c.report, nil
+}
+
+// isReplayNeeded reports whether opening database failed as its log must be replayed.
+// Badger wraps the error without supporting errors.Is, so its message is looked for as well
+func isReplayNeeded(err error) bool {
+	return err != nil && (errors.Is(err, badger.ErrReplayNeeded) || strings.Contains(err.Error(), badger.ErrReplayNeeded.Error()))
+}
+
+// checker holds state collected while scanning database
+type checker struct {
+	s      *Storage
+	report CheckReport
+
+	// lease is stored sequence lease, hasLease is false if sequence has never been leased
+	lease    uint64
+	hasLease bool
+	// maxID is the highest ID of links and tombstones of purged links, hasIDs is false if there are none
+	maxID  uint64
+	hasIDs bool
+
+	// expected holds index keys built from link records, indexed holds stored index keys, both mapped to link IDs
+	expected map[string]uint64
+	indexed  map[string]uint64
+	// broken holds IDs of link records that can not be parsed, so their index entries can not be judged
+	broken map[uint64]bool
+
+	// fixes are applied by repair
+	fixes []fix
+}
+
+// fix defines single repairing write
+type fix struct {
+	finding int
+	key     []byte
+	value   []byte
+	delete  bool
+}
+
+// add appends finding and returns its position
+func (c *checker) add(f Finding) int {
+	c.report.Findings = append(c.report.Findings, f)
+	return len(c.report.Findings) - 1
+}
+
+// scan walks every key of database
+func (c *checker) scan(txn *badger.Txn) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.KeyCopy(nil)
+
+		value, err := item.ValueCopy(nil)
+		failpoint.Inject("checkValueCopyErr", func() {
+			err = errors.New("mock check value copy error")
+		})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bytes.Equal(key, seqKey):
+			if len(value) != 8 {
+				c.add(Finding{Check: CheckSequence, Key: hex.EncodeToString(key), Message: "lease is not 8 bytes long"})
+				continue
+			}
+			c.lease, c.hasLease = binary.BigEndian.Uint64(value), true
+		case isLinkKey(key):
+			c.checkLink(key, value)
+		case bytes.HasPrefix(key, tombstonePrefix) && len(key) == len(tombstonePrefix)+8:
+			// IDs of purged links are never used again, so lease must be ahead of them too
+			c.useID(btou(key[len(tombstonePrefix):]))
+		case bytes.HasPrefix(key, urlIndexPrefix):
+			_, id, ok := parseURLIndexKey(key)
+			if !ok {
+				c.fixes = append(c.fixes, fix{
+					finding: c.add(Finding{Check: CheckIndex, Key: hex.EncodeToString(key), Message: "index key can not be parsed"}),
+					key:     key,
+					delete:  true,
+				})
+				continue
+			}
+			c.indexed[string(key)] = id
+		}
+	}
+
+	return nil
+}
+
+// checkLink checks link key and record
+func (c *checker) checkLink(key, value []byte) {
+	id := btou(key)
+	short := c.s.encodeID(id)
+
+	c.report.Links++
+	c.useID(id)
+
+	if decoded, err := c.s.decodeShort(short); err != nil || decoded != id {
+		c.add(Finding{Check: CheckKey, Key: hex.EncodeToString(key), Short: short, Message: "ID does not round-trip through short form"})
+	}
+
+	link, err := decodeLink(value)
+	if err != nil {
+		c.broken[id] = true
+		c.add(Finding{Check: CheckValue, Key: hex.EncodeToString(key), Short: short, Message: "record can not be parsed: " + err.Error()})
+		return
+	}
+
+	// the first variant shares link URL, so every URL is checked once
+	checked := make(map[string]bool)
+	check := func(name, rawURL string) {
+		if checked[rawURL] {
+			return
+		}
+		checked[rawURL] = true
+
+		if err := checkURL(rawURL); err != nil {
+			c.add(Finding{Check: CheckValue, Key: hex.EncodeToString(key), Short: short, Message: "invalid " + name + ": " + err.Error()})
+		}
+	}
+
+	check("URL", link.URL)
+	for i, v := range link.Variants {
+		check(fmt.Sprintf("variant %d URL", i), v.URL)
+	}
+	for i, r := range link.Targets {
+		check(fmt.Sprintf("targeting rule %d URL", i), r.URL)
+	}
+	if link.Fallback != "" {
+		check("fallback URL", link.Fallback)
+	}
+
+	for _, key := range linkIndexKeys(link, id) {
+		c.expected[string(key)] = id
+	}
+}
+
+// useID records link ID as used
+func (c *checker) useID(id uint64) {
+	if !c.hasIDs || id > c.maxID {
+		c.maxID = id
+	}
+	c.hasIDs = true
+}
+
+// checkURL checks that stored URL passes validation and is stored normalized, so it is redirected to as is
+func checkURL(rawURL string) error {
+	normalized, err := linkurl.Normalize(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if normalized != rawURL {
+		return fmt.Errorf("URL is not normalized, expected %q", normalized)
+	}
+
+	return nil
+}
+
+// compare checks sequence lease and index against link records
+func (c *checker) compare() {
+	if c.hasIDs && (!c.hasLease || c.lease <= c.maxID) {
+		c.fixes = append(c.fixes, fix{
+			finding: c.add(Finding{
+				Check:   CheckSequence,
+				Key:     hex.EncodeToString(seqKey),
+				Message: fmt.Sprintf("lease %d is not ahead of the highest link ID %d", c.lease, c.maxID),
+			}),
+			key:   seqKey,
+			value: leaseValue(c.maxID + 1),
+		})
+	}
+
+	for _, key := range sortedKeys(c.expected) {
+		id := c.expected[key]
+		if _, ok := c.indexed[key]; !ok {
+			c.fixes = append(c.fixes, fix{
+				finding: c.add(Finding{Check: CheckIndex, Key: hex.EncodeToString([]byte(key)), Short: c.s.encodeID(id), Message: "index entry is missing"}),
+				key:     []byte(key),
+			})
+		}
+	}
+
+	for _, key := range sortedKeys(c.indexed) {
+		id := c.indexed[key]
+		if _, ok := c.expected[key]; ok || c.broken[id] {
+			continue
+		}
+		c.fixes = append(c.fixes, fix{
+			finding: c.add(Finding{Check: CheckIndex, Key: hex.EncodeToString([]byte(key)), Short: c.s.encodeID(id), Message: "index entry does not match link record"}),
+			key:     []byte(key),
+			delete:  true,
+		})
+	}
+}
+
+// repair applies collected fixes, records them in audit log and marks corresponding findings as repaired
+func (c *checker) repair(actor string) error {
+	if len(c.fixes) == 0 {
+		return nil
+	}
+
+	wb := c.s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, f := range c.fixes {
+		var err error
+		if f.delete {
+			err = wb.Delete(f.key)
+		} else {
+			err = wb.Set(f.key, f.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := wb.Flush()
+	failpoint.Inject("checkRepairErr", func() {
+		err = errors.New("mock check repair error")
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.s.db.Update(func(txn *badger.Txn) error {
+		return appendAudit(txn, AuditEntry{
+			Actor:  actor,
+			Action: ActionStoreRepair,
+			Object: "store",
+			After:  []byte(strconv.Itoa(len(c.fixes))),
+		}, c.s.now())
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range c.fixes {
+		c.report.Findings[f.finding].Repaired = true
+	}
+
+	return nil
+}
+
+// sortedKeys returns map keys in ascending order so findings are reported in stable order
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// leaseValue encodes sequence lease the way badger.Sequence stores it
+func leaseValue(lease uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, lease)
+
+	return buf
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckWithoutLogger(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	_, err := Check(nil, dir, false, "cli")
+	require.Equal(t, errors.New("no logger provided"), err)
+}
+
+func TestCheck(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+
+	healthy, err := s.SaveURL(0, "https://example.com/healthy")
+	require.NoError(t, err)
+
+	unindexed, err := s.SaveURL(0, "https://example.com/unindexed")
+	require.NoError(t, err)
+
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(urlIndexKey("https://example.com/unindexed", 1)); err != nil {
+			return err
+		}
+		if err := txn.Set(urlIndexKey("https://example.com/dangling", 1), nil); err != nil {
+			return err
+		}
+		if err := txn.Set(utob(500), []byte("{broken")); err != nil {
+			return err
+		}
+		return txn.Set(utob(501), []byte("javascript:alert(1)"))
+	})
+	require.NoError(t, err)
+
+	err = s.Close()
+	require.NoError(t, err)
+
+	report, err := Check(logger, dir, false, "cli")
+	require.NoError(t, err)
+	require.False(t, report.Healthy())
+	require.Equal(t, uint64(4), report.Links)
+
+	messages := make(map[string]string)
+	for _, f := range report.Findings {
+		require.False(t, f.Repaired)
+		messages[f.Check+" "+f.Short] = f.Message
+	}
+	require.Len(t, messages, 5)
+	require.Contains(t, messages[CheckValue+" "+s.encodeID(500)], "record can not be parsed")
+	require.Equal(t, "invalid URL: URL scheme is not allowed: \"javascript\", only http and https are allowed", messages[CheckValue+" "+s.encodeID(501)])
+	require.Equal(t, "lease 2 is not ahead of the highest link ID 501", messages[CheckSequence+" "])
+	// unindexed link has both dangling entry and missing one, the latter is reported first
+	require.Equal(t, "index entry does not match link record", messages[CheckIndex+" "+unindexed])
+	require.NotContains(t, messages, CheckIndex+" "+healthy)
+
+	report, err = Check(logger, dir, true, "cli")
+	require.NoError(t, err)
+	repaired := 0
+	for _, f := range report.Findings {
+		if f.Repaired {
+			repaired++
+		}
+	}
+	// sequence lease, both index entries of unindexed link and missing index entry of invalid URL are fixed
+	require.Equal(t, 4, repaired)
+
+	report, err = Check(logger, dir, false, "cli")
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 2)
+	for _, f := range report.Findings {
+		require.Equal(t, CheckValue, f.Check)
+	}
+
+	s, err = New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/after-repair")
+	require.NoError(t, err)
+	require.Equal(t, s.encodeID(502), short)
+
+	page, err := s.AuditLog(0, AuditQuery{Action: ActionStoreRepair})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, "cli", page.Entries[0].Actor)
+}
+
+func TestCheck_Destinations(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+
+	link := Link{
+		URL:       "https://example.com/a",
+		Status:    StatusActive,
+		Variants:  []Variant{{"https://example.com/a", 1}, {"javascript:alert(1)", 1}},
+		Targets:   []TargetRule{{OS: []string{"ios"}, URL: "HTTPS://Example.com/ios"}},
+		Fallback:  "ftp://example.com/",
+		NotBefore: &time.Time{},
+	}
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(utob(7), encodeLink(link)); err != nil {
+			return err
+		}
+		if err := txn.Set(urlIndexKey(link.URL, 7), nil); err != nil {
+			return err
+		}
+		// purged link ID is ahead of lease as well
+		return txn.Set(tombstoneKey(900), nil)
+	})
+	require.NoError(t, err)
+
+	err = s.Close()
+	require.NoError(t, err)
+
+	report, err := Check(logger, dir, false, "cli")
+	require.NoError(t, err)
+
+	var messages []string
+	for _, f := range report.Findings {
+		if f.Check == CheckValue || f.Check == CheckSequence {
+			messages = append(messages, f.Message)
+		}
+	}
+	require.Equal(t, []string{
+		"invalid variant 1 URL: URL scheme is not allowed: \"javascript\", only http and https are allowed",
+		"invalid targeting rule 0 URL: URL is not normalized, expected \"https://example.com/ios\"",
+		"invalid fallback URL: URL scheme is not allowed: \"ftp\", only http and https are allowed",
+		"lease 0 is not ahead of the highest link ID 900",
+	}, messages)
+}
+
+func TestCheck_NotClosedCleanly(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	// copy of database left open is what crash leaves behind
+	crashed := filepath.Join(dir, "crashed")
+	err = os.Mkdir(crashed, 0755)
+	require.NoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(t, err)
+		err = ioutil.WriteFile(filepath.Join(crashed, f.Name()), b, 0644)
+		require.NoError(t, err)
+	}
+
+	report, err := Check(logger, crashed, false, "cli")
+	require.NoError(t, err)
+	require.False(t, report.Healthy())
+	require.Len(t, report.Findings, 1)
+	require.Equal(t, CheckDatabase, report.Findings[0].Check)
+	require.Contains(t, report.Findings[0].Message, "--repair")
+
+	// repair replays log, so database can be checked read-only afterwards
+	_, err = Check(logger, crashed, true, "cli")
+	require.NoError(t, err)
+
+	report, err = Check(logger, crashed, false, "cli")
+	require.NoError(t, err)
+	require.True(t, report.Healthy())
+	require.Equal(t, uint64(1), report.Links)
+}
+
+func TestCheck_ErrOpenDB(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"checkOpenDatabaseErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "checkOpenDatabaseErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	_, err = Check(logger, dir, true, "cli")
+	require.Equal(t, errors.New("mock check open database error"), err)
+}
+
+func TestCheck_ErrValueCopy(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"checkValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "checkValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	err = s.Close()
+	require.NoError(t, err)
+
+	_, err = Check(logger, dir, false, "cli")
+	require.Equal(t, errors.New("mock check value copy error"), err)
+}
+
+func TestCheck_ErrRepair(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"checkRepairErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "checkRepairErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	_, err = s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+	err = s.db.DropPrefix(urlIndexPrefix)
+	require.NoError(t, err)
+	err = s.Close()
+	require.NoError(t, err)
+
+	_, err = Check(logger, dir, true, "cli")
+	require.Equal(t, errors.New("mock check repair error"), err)
+}
+
+func TestCheck_MalformedKeys(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(append(append([]byte{}, urlIndexPrefix...), "x"...), nil); err != nil {
+			return err
+		}
+		return txn.Set(seqKey, []byte("bad"))
+	})
+	require.NoError(t, err)
+	// closing database without releasing sequence to keep broken lease
+	err = s.db.Close()
+	require.NoError(t, err)
+
+	report, err := Check(logger, dir, false, "cli")
+	require.NoError(t, err)
+	require.Equal(t, []Finding{
+		{Check: CheckIndex, Key: hex.EncodeToString([]byte("idx/url/x")), Message: "index key can not be parsed"},
+		{Check: CheckSequence, Key: hex.EncodeToString(seqKey), Message: "lease is not 8 bytes long"},
+	}, report.Findings)
+}
+
+func TestCheckURL(t *testing.T) {
+	require.NoError(t, checkURL("https://example.com/path"))
+	require.Equal(t, errors.New("URL is not normalized, expected \"https://example.com/path\""), checkURL("HTTPS://Example.com:443/path"))
+	require.Error(t, checkURL("not a url"))
+}
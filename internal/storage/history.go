@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"time"
+)
+
+// historyPrefix marks keys of link revisions replaced by edits.
+// Revision key layout is historyPrefix + link ID + big endian version so revisions are iterated in order
+var historyPrefix = []byte("hist/")
+
+var ErrVersionNotExist = errors.New("link version does not exist")
+
+// Revision defines single version of link target
+type Revision struct {
+	Version uint64    `json:"version"`
+	URL     string    `json:"url"`
+	Editor  string    `json:"editor,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// revisionOf returns revision describing current link target
+func revisionOf(link Link) Revision {
+	return Revision{
+		Version: link.Version,
+		URL:     link.URL,
+		Editor:  link.Editor,
+		Time:    link.UpdatedAt,
+	}
+}
+
+// historyKeyPrefix returns prefix of all revision keys for link ID
+func historyKeyPrefix(id uint64) []byte {
+	return append(append([]byte{}, historyPrefix...), utob(id)...)
+}
+
+// historyKey returns revision key for link ID and version
+func historyKey(id, version uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, version)
+
+	return append(historyKeyPrefix(id), buf...)
+}
+
+// UpdateURL points link referenced by short string ID to new URL.
+// Current target is kept in history so it can be restored with Revert
+func (s *Storage) UpdateURL(reqID uint64, short, url, editor string) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err = s.update(func(txn *badger.Txn) error {
+		var before Link
+		before, link, err = s.replaceURL(txn, id, url, editor)
+		if err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, editor, ActionLinkUpdate, short, &before, &link), s.now())
+	})
+	failpoint.Inject("updateURLErr", func() {
+		err = errors.New("mock update url error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("updating link", zap.Error(err))
+		}
+		return Link{}, err
+	}
+
+	s.cache.invalidate(id)
+
+	return link, nil
+}
+
+// History returns all versions of link target referenced by short string ID ordered from the oldest one.
+// The last revision describes current target
+func (s *Storage) History(reqID uint64, short string) ([]Revision, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]Revision, 0)
+	err = s.db.View(func(txn *badger.Txn) error {
+		link, err := readLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		prefix := historyKeyPrefix(id)
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			failpoint.Inject("historyValueCopyErr", func() {
+				err = errors.New("mock history value copy error")
+			})
+			if err != nil {
+				return err
+			}
+
+			var revision Revision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+		}
+
+		revisions = append(revisions, revisionOf(link))
+
+		return nil
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("retrieving link history", zap.Error(err))
+		}
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Revert points link referenced by short string ID to URL of its earlier version.
+// Reverting creates new version so history is never rewritten
+func (s *Storage) Revert(reqID uint64, short string, version uint64, editor string) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err = s.update(func(txn *badger.Txn) error {
+		current, err := readLiveLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		if version == 0 || version >= current.Version {
+			return ErrVersionNotExist
+		}
+
+		item, err := txn.Get(historyKey(id, version))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrVersionNotExist
+			}
+			return err
+		}
+
+		value, err := item.ValueCopy(nil)
+		failpoint.Inject("revertValueCopyErr", func() {
+			err = errors.New("mock revert value copy error")
+		})
+		if err != nil {
+			return err
+		}
+
+		var revision Revision
+		if err := json.Unmarshal(value, &revision); err != nil {
+			return err
+		}
+
+		var before Link
+		before, link, err = s.replaceURL(txn, id, revision.URL, editor)
+		if err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, editor, ActionLinkRevert, short, &before, &link), s.now())
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("reverting link", zap.Error(err))
+		}
+		return Link{}, err
+	}
+
+	s.cache.invalidate(id)
+
+	return link, nil
+}
+
+// replaceURL moves current link target to history and sets the new one as the next version.
+// Returns link record before and after the change
+func (s *Storage) replaceURL(txn *badger.Txn, id uint64, url, editor string) (Link, Link, error) {
+	before, err := readLiveLink(txn, id)
+	if err != nil {
+		return Link{}, Link{}, err
+	}
+
+	revision, _ := json.Marshal(revisionOf(before))
+	if err := txn.Set(historyKey(id, before.Version), revision); err != nil {
+		return Link{}, Link{}, err
+	}
+
+	link := before
+	link.URL = url
+	// the first variant of split link follows link target
+	if len(before.Variants) > 0 {
+		link.Variants = append([]Variant{{URL: url, Weight: before.Variants[0].Weight}}, before.Variants[1:]...)
+	}
+	link.Version++
+	link.Editor = editor
+	link.UpdatedAt = s.now().UTC()
+
+	if err := writeLink(txn, id, link); err != nil {
+		return Link{}, Link{}, err
+	}
+
+	if err := reindexLink(txn, id, before, link); err != nil {
+		return Link{}, Link{}, err
+	}
+
+	return before, link, nil
+}
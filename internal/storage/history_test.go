@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestUpdateURL(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveLink(0, Link{URL: "https://example.com/old", Creator: "alice"})
+	require.NoError(t, err)
+
+	// warming up cache to ensure update invalidates it
+	actual, err := s.GetURL(0, short)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/old", actual)
+
+	link, err := s.UpdateURL(0, short, "https://example.com/new", "bob")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/new", link.URL)
+	require.Equal(t, uint64(2), link.Version)
+	require.Equal(t, "alice", link.Creator)
+	require.Equal(t, "bob", link.Editor)
+
+	actual, err = s.GetURL(0, short)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/new", actual)
+
+	result, err := s.Search(0, SearchQuery{Mode: SearchSubstring, Term: "example.com/"})
+	require.NoError(t, err)
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.com/new"}}, result.Links)
+}
+
+func TestUpdateURL_ErrShortNotExist(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.UpdateURL(0, s.encodeID(0), "https://example.com", "bob")
+	require.Equal(t, ErrShortNotExist, err)
+
+	_, err = s.UpdateURL(0, "invalid", "https://example.com", "bob")
+	require.Equal(t, ErrInvalidShort, err)
+}
+
+func TestUpdateURL_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"updateURLErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "updateURLErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/old")
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.com/new", "bob")
+	require.Equal(t, errors.New("mock update url error"), err)
+}
+
+func TestHistoryRevert(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveLink(0, Link{URL: "https://example.com/v1", Creator: "alice"})
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.com/v2", "bob")
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.com/v3", "carol")
+	require.NoError(t, err)
+
+	link, err := s.Revert(0, short, 1, "dave")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/v1", link.URL)
+	require.Equal(t, uint64(4), link.Version)
+
+	actual, err := s.GetURL(0, short)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/v1", actual)
+
+	revisions, err := s.History(0, short)
+	require.NoError(t, err)
+	require.Len(t, revisions, 4)
+
+	expected := []struct {
+		url    string
+		editor string
+	}{
+		{"https://example.com/v1", "alice"},
+		{"https://example.com/v2", "bob"},
+		{"https://example.com/v3", "carol"},
+		{"https://example.com/v1", "dave"},
+	}
+	for i, e := range expected {
+		require.Equal(t, uint64(i+1), revisions[i].Version)
+		require.Equal(t, e.url, revisions[i].URL)
+		require.Equal(t, e.editor, revisions[i].Editor)
+		require.False(t, revisions[i].Time.IsZero())
+	}
+
+	_, err = s.Revert(0, short, 4, "dave")
+	require.Equal(t, ErrVersionNotExist, err)
+
+	_, err = s.Revert(0, short, 0, "dave")
+	require.Equal(t, ErrVersionNotExist, err)
+
+	_, err = s.Revert(0, s.encodeID(100), 1, "dave")
+	require.Equal(t, ErrShortNotExist, err)
+
+	_, err = s.History(0, s.encodeID(100))
+	require.Equal(t, ErrShortNotExist, err)
+}
+
+func TestHistory_ErrValueCopy(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"historyValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "historyValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/v1")
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.com/v2", "bob")
+	require.NoError(t, err)
+
+	_, err = s.History(0, short)
+	require.Equal(t, errors.New("mock history value copy error"), err)
+}
+
+func TestRevert_ErrValueCopy(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"revertValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "revertValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/v1")
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.com/v2", "bob")
+	require.NoError(t, err)
+
+	_, err = s.Revert(0, short, 1, "bob")
+	require.Equal(t, errors.New("mock revert value copy error"), err)
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// urlIndexPrefix marks keys of secondary index that maps normalized URL (host plus path tokens) to link ID.
+// Index key layout is urlIndexPrefix + normalized URL + 0x00 + ID
+var urlIndexPrefix = []byte("idx/url/")
+
+const (
+	// defaultSearchLimit is used when SearchQuery.Limit is not set
+	defaultSearchLimit = 20
+	// maxSearchLimit caps SearchQuery.Limit
+	maxSearchLimit = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid search cursor")
+
+// SearchMode defines how SearchQuery.Term is matched against normalized URLs
+type SearchMode int
+
+const (
+	// SearchHost matches links which host is equal to term
+	SearchHost SearchMode = iota
+	// SearchPrefix matches links which normalized URL starts with term
+	SearchPrefix
+	// SearchSubstring matches links which normalized URL contains term
+	SearchSubstring
+)
+
+// SearchQuery defines parameters for Search
+type SearchQuery struct {
+	Mode   SearchMode
+	Term   string
+	Limit  int
+	Cursor string
+}
+
+// FoundLink is a single Search hit
+type FoundLink struct {
+	Short string `json:"short"`
+	URL   string `json:"url"`
+}
+
+// SearchResult holds single page of Search hits and cursor for the next page (empty for the last page)
+type SearchResult struct {
+	Links      []FoundLink `json:"links"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// normalizeForIndex returns lowercased host followed by lowercased path tokens joined with slash.
+// Scheme, port, userinfo, query and fragment are dropped. Unparseable input is just lowercased
+func normalizeForIndex(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimSpace(rawURL))
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	tokens := make([]string, 0)
+	for _, token := range strings.Split(strings.ToLower(u.EscapedPath()), "/") {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return host + "/" + strings.Join(tokens, "/")
+}
+
+// normalizeSearchTerm brings term to the same form as normalizeForIndex output.
+// Scheme is optional in term and trailing slash is kept as it narrows prefix search
+func normalizeSearchTerm(mode SearchMode, term string) string {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if i := strings.Index(term, "://"); i >= 0 {
+		term = term[i+3:]
+	}
+
+	if mode == SearchHost {
+		return strings.TrimSuffix(strings.SplitN(term, "/", 2)[0], ".")
+	}
+
+	return term
+}
+
+// urlIndexKey builds index key for provided URL and ID
+func urlIndexKey(rawURL string, id uint64) []byte {
+	normalized := normalizeForIndex(rawURL)
+
+	key := make([]byte, 0, len(urlIndexPrefix)+len(normalized)+1+8)
+	key = append(key, urlIndexPrefix...)
+	key = append(key, normalized...)
+	key = append(key, 0)
+	key = append(key, utob(id)...)
+
+	return key
+}
+
+// linkIndexKeys returns index keys of every URL link ID is searchable by: its target, variants,
+// targeting rules and fallback. URLs normalized to the same index form share single key
+func linkIndexKeys(link Link, id uint64) [][]byte {
+	urls := link.destinations()
+
+	seen := make(map[string]bool, len(urls))
+	keys := make([][]byte, 0, len(urls))
+	for _, u := range urls {
+		key := urlIndexKey(u, id)
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// reindexLink replaces index keys of link ID record before its change with keys of record after it
+// inside provided transaction. Zero record before indexes new link
+func reindexLink(txn *badger.Txn, id uint64, before, after Link) error {
+	stale := make(map[string]bool)
+	if before.URL != "" {
+		for _, key := range linkIndexKeys(before, id) {
+			stale[string(key)] = true
+		}
+	}
+
+	for _, key := range linkIndexKeys(after, id) {
+		delete(stale, string(key))
+		if err := txn.Set(key, nil); err != nil {
+			return err
+		}
+	}
+
+	for key := range stale {
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseURLIndexKey extracts normalized URL and ID from index key
+func parseURLIndexKey(key []byte) (string, uint64, bool) {
+	if !bytes.HasPrefix(key, urlIndexPrefix) || len(key) < len(urlIndexPrefix)+1+8 {
+		return "", 0, false
+	}
+
+	sep := len(key) - 9
+	if key[sep] != 0 {
+		return "", 0, false
+	}
+
+	return string(key[len(urlIndexPrefix):sep]), btou(key[sep+1:]), true
+}
+
+// isLinkKey reports whether key belongs to primary link record
+func isLinkKey(key []byte) bool {
+	return len(key) == 8
+}
+
+// Search looks up links by normalized target URL using secondary index.
+// Results are ordered by normalized URL and paginated with opaque cursor
+func (s *Storage) Search(reqID uint64, q SearchQuery) (SearchResult, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	term := normalizeSearchTerm(q.Mode, q.Term)
+
+	scanPrefix := append([]byte{}, urlIndexPrefix...)
+	switch q.Mode {
+	case SearchHost:
+		scanPrefix = append(scanPrefix, term+"/"...)
+	case SearchPrefix:
+		scanPrefix = append(scanPrefix, term...)
+	}
+
+	seekKey := scanPrefix
+	if q.Cursor != "" {
+		cursor, err := hex.DecodeString(q.Cursor)
+		if err != nil || !bytes.HasPrefix(cursor, scanPrefix) {
+			return SearchResult{}, ErrInvalidCursor
+		}
+		// seeking right after the last returned key
+		seekKey = append(cursor, 0)
+	}
+
+	result := SearchResult{Links: make([]FoundLink, 0)}
+	var lastKey []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = scanPrefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seekKey); it.ValidForPrefix(scanPrefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+
+			normalized, id, ok := parseURLIndexKey(key)
+			if !ok {
+				continue
+			}
+
+			if q.Mode == SearchSubstring && !strings.Contains(normalized, term) {
+				continue
+			}
+
+			if len(result.Links) == limit {
+				result.NextCursor = hex.EncodeToString(lastKey)
+				break
+			}
+
+			item, err := txn.Get(utob(id))
+			if err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					// dangling index entry, fsck-like tools are responsible for cleaning it up
+					continue
+				}
+				return err
+			}
+
+			value, err := item.ValueCopy(nil)
+			failpoint.Inject("searchValueCopyErr", func() {
+				err = errors.New("mock search value copy error")
+			})
+			if err != nil {
+				return err
+			}
+
+			link, err := decodeLink(value)
+			if err != nil {
+				return err
+			}
+
+			result.Links = append(result.Links, FoundLink{Short: s.encodeID(id), URL: link.URL})
+			lastKey = key
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("searching links", zap.Error(err))
+		return SearchResult{}, err
+	}
+
+	return result, nil
+}
+
+// RebuildIndex drops secondary index and fills it again from primary link records.
+// Returns the number of indexed links
+func (s *Storage) RebuildIndex(reqID uint64, actor string) (int, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	err := s.db.DropPrefix(urlIndexPrefix)
+	failpoint.Inject("dropPrefixErr", func() {
+		err = errors.New("mock drop prefix error")
+	})
+	if err != nil {
+		logger.Error("dropping url index", zap.Error(err))
+		return 0, err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	count := 0
+	err = s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isLinkKey(item.Key()) {
+				continue
+			}
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			link, err := decodeLink(value)
+			if err != nil {
+				return err
+			}
+
+			for _, key := range linkIndexKeys(link, btou(item.Key())) {
+				if err := wb.Set(key, nil); err != nil {
+					return err
+				}
+			}
+			count++
+		}
+
+		return nil
+	})
+	failpoint.Inject("rebuildIndexErr", func() {
+		err = errors.New("mock rebuild index error")
+	})
+	if err != nil {
+		logger.Error("rebuilding url index", zap.Error(err))
+		return 0, err
+	}
+
+	if err := wb.Flush(); err != nil {
+		logger.Error("flushing url index", zap.Error(err))
+		return 0, err
+	}
+
+	err = s.update(func(txn *badger.Txn) error {
+		return appendAudit(txn, AuditEntry{
+			Actor:     actor,
+			Action:    ActionIndexRebuild,
+			Object:    string(urlIndexPrefix),
+			After:     []byte(strconv.Itoa(count)),
+			RequestID: reqID,
+		}, s.now())
+	})
+	if err != nil {
+		logger.Error("appending audit log", zap.Error(err))
+		return 0, err
+	}
+
+	logger.Info("url index rebuilt", zap.Int("links", count))
+
+	return count, nil
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestNormalizeForIndex(t *testing.T) {
+	require.Equal(t, "www.example.com/promo/spring", normalizeForIndex("https://user@WWW.Example.com:8080/Promo//Spring/?utm=1#top"))
+	require.Equal(t, "example.com/", normalizeForIndex("http://example.com."))
+	require.Equal(t, "not a url", normalizeForIndex(" Not a URL "))
+}
+
+func TestNormalizeSearchTerm(t *testing.T) {
+	require.Equal(t, "example.com", normalizeSearchTerm(SearchHost, "HTTPS://Example.com./promo"))
+	require.Equal(t, "example.com/promo", normalizeSearchTerm(SearchPrefix, " https://Example.com/Promo"))
+	require.Equal(t, "promo", normalizeSearchTerm(SearchSubstring, "Promo"))
+}
+
+func TestParseURLIndexKey(t *testing.T) {
+	normalized, id, ok := parseURLIndexKey(urlIndexKey("https://example.com/a", 42))
+	require.True(t, ok)
+	require.Equal(t, "example.com/a", normalized)
+	require.Equal(t, uint64(42), id)
+
+	_, _, ok = parseURLIndexKey([]byte("idx/url/short"))
+	require.False(t, ok)
+
+	_, _, ok = parseURLIndexKey([]byte("idx/url/example.com/a-12345678"))
+	require.False(t, ok)
+}
+
+func TestSearch(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	urls := []string{
+		"https://example.com/promo/spring",
+		"https://www.example.com/promo/summer",
+		"https://example.com/about",
+		"https://example.org/promo",
+	}
+	shorts := make(map[string]string)
+	for _, u := range urls {
+		short, err := s.SaveURL(0, u)
+		require.NoError(t, err)
+		shorts[u] = short
+	}
+
+	result, err := s.Search(0, SearchQuery{Mode: SearchHost, Term: "Example.com"})
+	require.NoError(t, err)
+	require.Equal(t, []FoundLink{
+		{Short: shorts[urls[2]], URL: urls[2]},
+		{Short: shorts[urls[0]], URL: urls[0]},
+	}, result.Links)
+	require.Empty(t, result.NextCursor)
+
+	result, err = s.Search(0, SearchQuery{Mode: SearchPrefix, Term: "https://example.com/promo"})
+	require.NoError(t, err)
+	require.Equal(t, []FoundLink{{Short: shorts[urls[0]], URL: urls[0]}}, result.Links)
+
+	result, err = s.Search(0, SearchQuery{Mode: SearchSubstring, Term: "promo", Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []FoundLink{
+		{Short: shorts[urls[0]], URL: urls[0]},
+		{Short: shorts[urls[3]], URL: urls[3]},
+	}, result.Links)
+	require.NotEmpty(t, result.NextCursor)
+
+	result, err = s.Search(0, SearchQuery{Mode: SearchSubstring, Term: "promo", Limit: 2, Cursor: result.NextCursor})
+	require.NoError(t, err)
+	require.Equal(t, []FoundLink{{Short: shorts[urls[1]], URL: urls[1]}}, result.Links)
+	require.Empty(t, result.NextCursor)
+
+	result, err = s.Search(0, SearchQuery{Mode: SearchHost, Term: "example.net"})
+	require.NoError(t, err)
+	require.Empty(t, result.Links)
+}
+
+func TestSearch_Destinations(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+
+	short, err := s.SaveLink(0, Link{Variants: []Variant{
+		{"https://example.com/a", 1},
+		{"https://example.org/b", 1},
+	}})
+	require.NoError(t, err)
+
+	found := func(host string) []FoundLink {
+		result, err := s.Search(0, SearchQuery{Mode: SearchHost, Term: host})
+		require.NoError(t, err)
+		return result.Links
+	}
+
+	// links are found by every variant
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.com/a"}}, found("example.com"))
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.com/a"}}, found("example.org"))
+
+	// editing target replaces the first variant in index as well
+	_, err = s.UpdateURL(0, short, "https://example.net/c", "bob")
+	require.NoError(t, err)
+	require.Empty(t, found("example.com"))
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.net/c"}}, found("example.net"))
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.net/c"}}, found("example.org"))
+
+	err = s.db.DropPrefix(urlIndexPrefix)
+	require.NoError(t, err)
+
+	_, err = s.RebuildIndex(0, "cli")
+	require.NoError(t, err)
+	require.Len(t, found("example.org"), 1)
+
+	err = s.Close()
+	require.NoError(t, err)
+
+	// consistency check expects every destination indexed
+	report, err := Check(logger, dir, false, "cli")
+	require.NoError(t, err)
+	require.Empty(t, report.Findings)
+
+	s, err = New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	err = s.Purge(0, short, "root")
+	require.NoError(t, err)
+	require.Empty(t, found("example.net"))
+	require.Empty(t, found("example.org"))
+}
+
+func TestSearch_TargetsAndFallback(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	launch := s.Now().Add(time.Hour)
+	short, err := s.SaveLink(0, Link{
+		URL:       "https://example.com/",
+		NotBefore: &launch,
+		Fallback:  "https://example.net/soon",
+		Targets:   []TargetRule{{OS: []string{"ios"}, URL: "https://example.org/ios"}},
+	})
+	require.NoError(t, err)
+
+	found := func(host string) []FoundLink {
+		result, err := s.Search(0, SearchQuery{Mode: SearchHost, Term: host})
+		require.NoError(t, err)
+		return result.Links
+	}
+
+	require.Len(t, found("example.net"), 1)
+	require.Len(t, found("example.org"), 1)
+
+	// replaced targeting rules leave index with the link
+	_, err = s.SetTargets(0, short, []TargetRule{{OS: []string{"android"}, URL: "https://example.io/android"}}, "bob")
+	require.NoError(t, err)
+	require.Empty(t, found("example.org"))
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.com/"}}, found("example.io"))
+
+	_, err = s.SetTargets(0, short, nil, "bob")
+	require.NoError(t, err)
+	require.Empty(t, found("example.io"))
+	require.Len(t, found("example.net"), 1)
+}
+
+func TestSearch_ErrInvalidCursor(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.Search(0, SearchQuery{Mode: SearchSubstring, Term: "a", Cursor: "not hex"})
+	require.Equal(t, ErrInvalidCursor, err)
+
+	// cursor issued for another host must not be accepted
+	_, err = s.Search(0, SearchQuery{Mode: SearchHost, Term: "example.com", Cursor: "69"})
+	require.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestSearch_ErrValueCopy(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"searchValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "searchValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	_, err = s.Search(0, SearchQuery{Mode: SearchHost, Term: "example.com"})
+	require.Equal(t, errors.New("mock search value copy error"), err)
+}
+
+func TestRebuildIndex(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/a")
+	require.NoError(t, err)
+
+	// simulating index lost due to crash
+	err = s.db.DropPrefix(urlIndexPrefix)
+	require.NoError(t, err)
+
+	result, err := s.Search(0, SearchQuery{Mode: SearchHost, Term: "example.com"})
+	require.NoError(t, err)
+	require.Empty(t, result.Links)
+
+	count, err := s.RebuildIndex(0, "cli")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	result, err = s.Search(0, SearchQuery{Mode: SearchHost, Term: "example.com"})
+	require.NoError(t, err)
+	require.Equal(t, []FoundLink{{Short: short, URL: "https://example.com/a"}}, result.Links)
+}
+
+func TestRebuildIndex_ErrDropPrefix(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"dropPrefixErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "dropPrefixErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.RebuildIndex(0, "cli")
+	require.Equal(t, errors.New("mock drop prefix error"), err)
+}
+
+func TestRebuildIndex_ErrRebuild(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"rebuildIndexErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "rebuildIndexErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.RebuildIndex(0, "cli")
+	require.Equal(t, errors.New("mock rebuild index error"), err)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+)
+
+// tombstonePrefix marks keys left for purged links so their IDs are never used again.
+// Tombstone key layout is tombstonePrefix + link ID
+var tombstonePrefix = []byte("tomb/")
+
+// LinkStatus defines whether link is redirecting
+type LinkStatus string
+
+const (
+	// StatusActive links redirect to their targets
+	StatusActive LinkStatus = "active"
+	// StatusDisabled links do not redirect but can be enabled again
+	StatusDisabled LinkStatus = "disabled"
+	// StatusDeleted links do not redirect and can not be changed anymore
+	StatusDeleted LinkStatus = "deleted"
+	// StatusExpired is effective status of active links past their expiry time, it is never stored
+	StatusExpired LinkStatus = "expired"
+	// StatusExhausted is effective status of active links clicked as many times as allowed, it is never stored
+	StatusExhausted LinkStatus = "exhausted"
+)
+
+// tombstoneKey returns tombstone key for link ID
+func tombstoneKey(id uint64) []byte {
+	return append(append([]byte{}, tombstonePrefix...), utob(id)...)
+}
+
+// missingLinkErr tells apart never existed links from purged ones
+func missingLinkErr(txn *badger.Txn, id uint64) error {
+	_, err := txn.Get(tombstoneKey(id))
+	switch {
+	case err == nil:
+		return ErrShortGone
+	case errors.Is(err, badger.ErrKeyNotFound):
+		return ErrShortNotExist
+	default:
+		return err
+	}
+}
+
+// idTaken reports whether link ID is held by existing link or tombstone
+func idTaken(txn *badger.Txn, id uint64) (bool, error) {
+	for _, key := range [][]byte{utob(id), tombstoneKey(id)} {
+		_, err := txn.Get(key)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// statusActions maps link statuses to audit log actions setting them
+var statusActions = map[LinkStatus]string{
+	StatusActive:   ActionLinkEnable,
+	StatusDisabled: ActionLinkDisable,
+	StatusDeleted:  ActionLinkDelete,
+}
+
+// Disable stops link referenced by short string ID from redirecting
+func (s *Storage) Disable(reqID uint64, short, actor string) (Link, error) {
+	return s.setStatus(reqID, short, actor, StatusDisabled)
+}
+
+// Enable makes disabled link referenced by short string ID redirecting again
+func (s *Storage) Enable(reqID uint64, short, actor string) (Link, error) {
+	return s.setStatus(reqID, short, actor, StatusActive)
+}
+
+// Delete marks link referenced by short string ID as deleted.
+// Deleted link keeps its record and history but can not be changed or enabled anymore
+func (s *Storage) Delete(reqID uint64, short, actor string) error {
+	_, err := s.setStatus(reqID, short, actor, StatusDeleted)
+	return err
+}
+
+// setStatus changes status of link that is not deleted
+func (s *Storage) setStatus(reqID uint64, short, actor string, status LinkStatus) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err = s.update(func(txn *badger.Txn) error {
+		before, err := readLiveLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		link = before
+		link.Status = status
+
+		if err := writeLink(txn, id, link); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, actor, statusActions[status], short, &before, &link), s.now())
+	})
+	failpoint.Inject("setStatusErr", func() {
+		err = errors.New("mock set status error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("changing link status", zap.String("status", string(status)), zap.Error(err))
+		}
+		return Link{}, err
+	}
+
+	s.cache.invalidate(id)
+
+	return link, nil
+}
+
+// Purge removes link referenced by short string ID with its history, click counter and index entries.
+// Tombstone is left instead so the short form keeps answering as gone and is never issued again
+func (s *Storage) Purge(reqID uint64, short, actor string) error {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return err
+	}
+
+	err = s.update(func(txn *badger.Txn) error {
+		link, err := readLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		keys := append([][]byte{utob(id), clicksKey(id), passwordKey(id)}, linkIndexKeys(link, id)...)
+
+		for _, prefix := range [][]byte{historyKeyPrefix(id), clickDeltaKeyPrefix(id)} {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = prefix
+
+			it := txn.NewIterator(opts)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+			it.Close()
+		}
+
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		if err := txn.Set(tombstoneKey(id), nil); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, actor, ActionLinkPurge, short, &link, nil), s.now())
+	})
+	failpoint.Inject("purgeErr", func() {
+		err = errors.New("mock purge error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("purging link", zap.Error(err))
+		}
+		return err
+	}
+
+	s.cache.invalidate(id)
+
+	return nil
+}
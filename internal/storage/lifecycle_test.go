@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestDisableEnable(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	// warming up cache to ensure status change invalidates it
+	_, err = s.GetURL(0, short)
+	require.NoError(t, err)
+
+	link, err := s.Disable(0, short, "bob")
+	require.NoError(t, err)
+	require.Equal(t, StatusDisabled, link.Status)
+
+	_, err = s.GetURL(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	// lookup returns links whatever their status is
+	link, err = s.LookupLink(0, short)
+	require.NoError(t, err)
+	require.Equal(t, StatusDisabled, link.Status)
+	require.Equal(t, "https://example.com", link.URL)
+
+	link, err = s.Enable(0, short, "bob")
+	require.NoError(t, err)
+	require.Equal(t, StatusActive, link.Status)
+
+	actual, err := s.GetURL(0, short)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com", actual)
+}
+
+func TestDelete(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	err = s.Delete(0, short, "bob")
+	require.NoError(t, err)
+
+	_, err = s.GetURL(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.Enable(0, short, "bob")
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.org", "bob")
+	require.Equal(t, ErrShortGone, err)
+
+	err = s.Delete(0, s.encodeID(100), "bob")
+	require.Equal(t, ErrShortNotExist, err)
+}
+
+func TestSetStatus_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"setStatusErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "setStatusErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	_, err = s.Disable(0, short, "bob")
+	require.Equal(t, errors.New("mock set status error"), err)
+}
+
+func TestPurge(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/v1")
+	require.NoError(t, err)
+
+	_, err = s.UpdateURL(0, short, "https://example.com/v2", "bob")
+	require.NoError(t, err)
+
+	err = s.Purge(0, short, "root")
+	require.NoError(t, err)
+
+	_, err = s.GetURL(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.History(0, short)
+	require.Equal(t, ErrShortGone, err)
+
+	result, err := s.Search(0, SearchQuery{Mode: SearchHost, Term: "example.com"})
+	require.NoError(t, err)
+	require.Empty(t, result.Links)
+
+	err = s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(historyKey(0, 1))
+		return err
+	})
+	require.Equal(t, badger.ErrKeyNotFound, err)
+
+	err = s.Purge(0, short, "root")
+	require.Equal(t, ErrShortGone, err)
+}
+
+func TestPurge_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"purgeErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "purgeErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com")
+	require.NoError(t, err)
+
+	err = s.Purge(0, short, "root")
+	require.Equal(t, errors.New("mock purge error"), err)
+}
+
+func TestSaveURL_SkipsTakenIDs(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	// simulating database restored from backup that is behind issued IDs
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(utob(0), []byte("https://example.com/restored")); err != nil {
+			return err
+		}
+		return txn.Set(tombstoneKey(1), nil)
+	})
+	require.NoError(t, err)
+
+	short, err := s.SaveURL(0, "https://example.com/new")
+	require.NoError(t, err)
+	require.Equal(t, s.encodeID(2), short)
+
+	actual, err := s.GetURL(0, s.encodeID(0))
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/restored", actual)
+}
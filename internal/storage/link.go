@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"net/url"
+	"time"
+)
+
+// maxConflictRetries limits attempts to commit read-modify-write transaction that keeps conflicting
+const maxConflictRetries = 10
+
+// Link defines stored link record
+type Link struct {
+	URL       string     `json:"url"`
+	Version   uint64     `json:"version"`
+	Status    LinkStatus `json:"status"`
+	Creator   string     `json:"creator,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	Editor    string     `json:"editor,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	// NotBefore is time link starts redirecting at, nil for links active since creation
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// Fallback is URL link redirects to before NotBefore, empty to respond as not active yet
+	Fallback string `json:"fallback_url,omitempty"`
+	// ExpiresAt is time link stops redirecting at, nil for links that never expire
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxClicks is number of redirects link stops redirecting after, zero for unlimited links
+	MaxClicks uint64 `json:"max_clicks,omitempty"`
+	// Variants lists destinations split link distributes traffic across, the first one is link URL.
+	// Empty for links with single destination
+	Variants []Variant `json:"variants,omitempty"`
+	// Targets lists rules choosing destination by visit in order, link URL is the destination of visits matching none
+	Targets []TargetRule `json:"targets,omitempty"`
+	// Protected is set for links redirecting only to clients knowing their password
+	Protected bool `json:"protected,omitempty"`
+	// Password is set on link creation only, storage keeps nothing but its hash apart from link record
+	Password string `json:"-"`
+	RedirectPolicy
+}
+
+// checkActive returns ErrShortGone if link does not redirect at provided time anymore
+// and ErrShortNotActive if it does not redirect yet
+func (l Link) checkActive(now time.Time) error {
+	if l.Status != StatusActive {
+		return ErrShortGone
+	}
+
+	if l.ExpiresAt != nil && !now.Before(*l.ExpiresAt) {
+		return ErrShortGone
+	}
+
+	if l.NotBefore != nil && now.Before(*l.NotBefore) {
+		return ErrShortNotActive
+	}
+
+	return nil
+}
+
+// destinations returns every URL link may redirect to: its target, variants, targeting rule destinations and fallback
+func (l Link) destinations() []string {
+	urls := []string{l.URL}
+	for _, v := range l.Variants {
+		urls = append(urls, v.URL)
+	}
+	for _, r := range l.Targets {
+		urls = append(urls, r.URL)
+	}
+	if l.Fallback != "" {
+		urls = append(urls, l.Fallback)
+	}
+
+	return urls
+}
+
+// validateActivation checks link activation window and normalizes its time to UTC.
+// Activation time may be in the past, such links are active since creation
+func (l *Link) validateActivation() error {
+	if l.NotBefore == nil {
+		if l.Fallback != "" {
+			return fmt.Errorf("%w: fallback URL requires activation time", ErrInvalidActivation)
+		}
+		return nil
+	}
+
+	if l.ExpiresAt != nil && !l.NotBefore.Before(*l.ExpiresAt) {
+		return fmt.Errorf("%w: activation time must be before expiry time", ErrInvalidActivation)
+	}
+
+	if l.Fallback != "" {
+		u, err := url.Parse(l.Fallback)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%w: fallback URL must be absolute HTTP(S) URL", ErrInvalidActivation)
+		}
+	}
+
+	notBefore := l.NotBefore.UTC()
+	l.NotBefore = &notBefore
+
+	return nil
+}
+
+// decodeLink parses stored link record.
+// Records saved before link metadata has been introduced hold nothing but URL and are treated as the first version
+func decodeLink(b []byte) (Link, error) {
+	if len(b) == 0 || b[0] != '{' {
+		return Link{URL: string(b), Version: 1, Status: StatusActive}, nil
+	}
+
+	var link Link
+	if err := json.Unmarshal(b, &link); err != nil {
+		return Link{}, err
+	}
+
+	if link.Status == "" {
+		link.Status = StatusActive
+	}
+
+	return link, nil
+}
+
+// encodeLink serializes link record
+func encodeLink(link Link) []byte {
+	// marshalling can not fail as Link holds no channels, functions or cyclic values
+	b, _ := json.Marshal(link)
+
+	return b
+}
+
+// decodeShort returns link ID referenced by short form
+func (s *Storage) decodeShort(short string) (uint64, error) {
+	ids, err := s.hashID.DecodeInt64WithError(short)
+	if err != nil {
+		return 0, ErrInvalidShort
+	}
+
+	return int64SliceToUint64(ids), nil
+}
+
+// encodeID returns short form for link ID
+func (s *Storage) encodeID(id uint64) string {
+	// skip error handing due to impossible condition
+	// EncodeInt64 inside checks if provided int64 slice is not empty and holds values grater or equal zero
+	// uint64ToInt64Slice by design can not return empty slice (compilation check)
+	// uint64ToInt64Slice also can not hold negative values
+	short, _ := s.hashID.EncodeInt64(uint64ToInt64Slice(id))
+
+	return short
+}
+
+// readLink reads and decodes link record inside provided transaction
+func readLink(txn *badger.Txn, id uint64) (Link, error) {
+	item, err := txn.Get(utob(id))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return Link{}, missingLinkErr(txn, id)
+		}
+		return Link{}, err
+	}
+
+	value, err := item.ValueCopy(nil)
+	failpoint.Inject("valueCopyErr", func() {
+		err = errors.New("mock value copy error")
+	})
+	if err != nil {
+		return Link{}, err
+	}
+
+	return decodeLink(value)
+}
+
+// readLiveLink reads link record that is not deleted inside provided transaction
+func readLiveLink(txn *badger.Txn, id uint64) (Link, error) {
+	link, err := readLink(txn, id)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if link.Status == StatusDeleted {
+		return Link{}, ErrShortGone
+	}
+
+	return link, nil
+}
+
+// writeLink encodes and stores link record inside provided transaction
+func writeLink(txn *badger.Txn, id uint64, link Link) error {
+	return txn.Set(utob(id), encodeLink(link))
+}
+
+// update runs read-modify-write transaction retrying it when it conflicts with concurrent one
+func (s *Storage) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.db.Update(fn)
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+
+	return err
+}
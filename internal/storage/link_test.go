@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestDecodeLink(t *testing.T) {
+	link, err := decodeLink([]byte("https://example.com"))
+	require.NoError(t, err)
+	require.Equal(t, Link{URL: "https://example.com", Version: 1, Status: StatusActive}, link)
+
+	// records saved before statuses have been introduced are active
+	link, err = decodeLink([]byte(`{"url":"https://example.com","version":3,"creator":"alice"}`))
+	require.NoError(t, err)
+	require.Equal(t, Link{URL: "https://example.com", Version: 3, Status: StatusActive, Creator: "alice"}, link)
+
+	link, err = decodeLink(encodeLink(Link{URL: "https://example.com", Version: 3, Status: StatusDisabled}))
+	require.NoError(t, err)
+	require.Equal(t, Link{URL: "https://example.com", Version: 3, Status: StatusDisabled}, link)
+
+	_, err = decodeLink([]byte("{broken"))
+	require.Error(t, err)
+}
+
+func TestGetURL_LegacyRecord(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	// records saved before link metadata has been introduced hold plain URL
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(utob(7), []byte("https://example.com/legacy"))
+	})
+	require.NoError(t, err)
+
+	actual, err := s.GetURL(0, s.encodeID(7))
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/legacy", actual)
+
+	link, err := s.UpdateURL(0, s.encodeID(7), "https://example.com/new", "bob")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), link.Version)
+
+	revisions, err := s.History(0, s.encodeID(7))
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/legacy", revisions[0].URL)
+}
+
+func TestCreateLink(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, link, err := s.CreateLink(0, Link{URL: "https://example.com", Creator: "alice"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), link.Version)
+	require.Equal(t, StatusActive, link.Status)
+	require.Equal(t, "alice", link.Editor)
+	require.False(t, link.CreatedAt.IsZero())
+
+	// returned record is the stored one
+	stored, err := s.GetLink(0, short)
+	require.NoError(t, err)
+	require.Equal(t, link.URL, stored.URL)
+	require.True(t, link.CreatedAt.Equal(stored.CreatedAt))
+}
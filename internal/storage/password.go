@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordsPrefix marks keys of link password hashes. Hash key layout is passwordsPrefix + link ID.
+// Hashes are kept apart from link records, so they never show up in link versions, audit log or API responses
+var passwordsPrefix = []byte("passwords/")
+
+// maxPasswordLength limits link password length in bytes, bcrypt ignores bytes past it
+const maxPasswordLength = 72
+
+var (
+	ErrInvalidPassword = errors.New("invalid link password")
+	ErrWrongPassword   = errors.New("wrong link password")
+)
+
+// passwordCost is bcrypt cost of link password hashes
+var passwordCost = bcrypt.DefaultCost
+
+// passwordKey returns password hash key for link ID
+func passwordKey(id uint64) []byte {
+	return append(append([]byte{}, passwordsPrefix...), utob(id)...)
+}
+
+// hashPassword validates link password and returns its bcrypt hash
+func hashPassword(password string) ([]byte, error) {
+	if len(password) > maxPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at most %d bytes long", ErrInvalidPassword, maxPasswordLength)
+	}
+
+	return bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+}
+
+// CheckPassword returns ErrWrongPassword if password does not match the one link referenced by short string ID
+// is protected with. Links without password accept any
+func (s *Storage) CheckPassword(reqID uint64, short, password string) error {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return err
+	}
+
+	var hash []byte
+	err = s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(passwordKey(id))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		hash, err = item.ValueCopy(nil)
+		return err
+	})
+	failpoint.Inject("checkPasswordErr", func() {
+		err = errors.New("mock check password error")
+	})
+	if err != nil {
+		logger.Error("retrieving link password", zap.Error(err))
+		return err
+	}
+
+	if hash == nil {
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, _, err = s.CreateLink(0, Link{URL: "https://example.com/", Password: strings.Repeat("x", maxPasswordLength+1)})
+	require.True(t, errors.Is(err, ErrInvalidPassword))
+
+	short, link, err := s.CreateLink(0, Link{URL: "https://example.com/", Password: "s3cr3t"})
+	require.NoError(t, err)
+	require.True(t, link.Protected)
+	require.Empty(t, link.Password)
+
+	err = s.CheckPassword(0, short, "s3cr3t")
+	require.NoError(t, err)
+
+	err = s.CheckPassword(0, short, "secret")
+	require.Equal(t, ErrWrongPassword, err)
+
+	err = s.CheckPassword(0, short, "")
+	require.Equal(t, ErrWrongPassword, err)
+
+	// hash is kept apart from link record
+	stored, err := s.LookupLink(0, short)
+	require.NoError(t, err)
+	require.True(t, stored.Protected)
+	require.NotContains(t, string(encodeLink(stored)), "s3cr3t")
+
+	// links without password accept any, client can not protect link without one
+	open, link, err := s.CreateLink(0, Link{URL: "https://example.com/", Protected: true})
+	require.NoError(t, err)
+	require.False(t, link.Protected)
+
+	err = s.CheckPassword(0, open, "anything")
+	require.NoError(t, err)
+
+	err = s.CheckPassword(0, "invalid", "s3cr3t")
+	require.Equal(t, ErrInvalidShort, err)
+
+	err = s.Purge(0, short, "root")
+	require.NoError(t, err)
+
+	err = s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(passwordKey(0))
+		return err
+	})
+	require.Equal(t, badger.ErrKeyNotFound, err)
+}
+
+func TestCheckPassword_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	err := failpoint.Enable(packagePath+"checkPasswordErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "checkPasswordErr")
+		require.NoError(t, err)
+	}()
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveLink(0, Link{URL: "https://example.com/", Password: "s3cr3t"})
+	require.NoError(t, err)
+
+	err = s.CheckPassword(0, short, "s3cr3t")
+	require.Equal(t, errors.New("mock check password error"), err)
+}
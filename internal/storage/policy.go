@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"auto/internal/policy"
+	"encoding/json"
+	"errors"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
+)
+
+// policyPrefix marks keys of runtime policy rules. Rule key layout is policyPrefix + rule ID
+var policyPrefix = []byte("policy/")
+
+// enforcedPrefix marks keys of file policy rules already enforced. Key layout is enforcedPrefix + rule key.
+// Rules keep being enforced across restarts, so links re-enabled after enforcement stay enabled
+var enforcedPrefix = []byte("policy-enforced/")
+
+var ErrRuleNotExist = errors.New("policy rule does not exist")
+
+// policyKey returns runtime policy rule key for rule ID
+func policyKey(id string) []byte {
+	return append(append([]byte{}, policyPrefix...), id...)
+}
+
+// enforcedKey returns key recording enforcement of policy rule
+func enforcedKey(rule policy.Rule) []byte {
+	return append(append([]byte{}, enforcedPrefix...), rule.Key()...)
+}
+
+// encodeRule serializes policy rule
+func encodeRule(rule policy.Rule) []byte {
+	b, _ := json.Marshal(rule)
+
+	return b
+}
+
+// PolicyRules returns runtime policy rules ordered by creation
+func (s *Storage) PolicyRules(reqID uint64) ([]policy.Rule, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	rules := make([]policy.Rule, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = policyPrefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(policyPrefix); it.ValidForPrefix(policyPrefix); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			failpoint.Inject("policyValueCopyErr", func() {
+				err = errors.New("mock policy value copy error")
+			})
+			if err != nil {
+				return err
+			}
+
+			var rule policy.Rule
+			if err := json.Unmarshal(value, &rule); err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("retrieving policy rules", zap.Error(err))
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// AddPolicyRule stores runtime policy rule on behalf of actor and returns it with ID assigned.
+// Rule IDs are sortable by creation time
+func (s *Storage) AddPolicyRule(reqID uint64, actor string, rule policy.Rule) (policy.Rule, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	rule.ID = xid.New().String()
+	rule.Source = policy.SourceAPI
+	rule.Creator = actor
+	rule.CreatedAt = s.now().UTC()
+
+	err := s.update(func(txn *badger.Txn) error {
+		if err := txn.Set(policyKey(rule.ID), encodeRule(rule)); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, AuditEntry{
+			Actor:     actor,
+			Action:    ActionPolicyAdd,
+			Object:    rule.ID,
+			After:     encodeRule(rule),
+			RequestID: reqID,
+		}, s.now())
+	})
+	failpoint.Inject("addPolicyRuleErr", func() {
+		err = errors.New("mock add policy rule error")
+	})
+	if err != nil {
+		logger.Error("adding policy rule", zap.Error(err))
+		return policy.Rule{}, err
+	}
+
+	return rule, nil
+}
+
+// RemovePolicyRule removes runtime policy rule on behalf of actor
+func (s *Storage) RemovePolicyRule(reqID uint64, actor, id string) error {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	err := s.update(func(txn *badger.Txn) error {
+		item, err := txn.Get(policyKey(id))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrRuleNotExist
+			}
+			return err
+		}
+
+		before, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(policyKey(id)); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, AuditEntry{
+			Actor:     actor,
+			Action:    ActionPolicyRemove,
+			Object:    id,
+			Before:    before,
+			RequestID: reqID,
+		}, s.now())
+	})
+	failpoint.Inject("removePolicyRuleErr", func() {
+		err = errors.New("mock remove policy rule error")
+	})
+	if err != nil {
+		if !errors.Is(err, ErrRuleNotExist) {
+			logger.Error("removing policy rule", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RuleEnforced reports whether policy rule with the same meaning has been enforced already
+func (s *Storage) RuleEnforced(reqID uint64, rule policy.Rule) (bool, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(enforcedKey(rule))
+		return err
+	})
+	failpoint.Inject("ruleEnforcedErr", func() {
+		err = errors.New("mock rule enforced error")
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		logger.Error("checking policy rule enforcement", zap.Error(err))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetRuleEnforced records enforcement of policy rule, so it is not enforced again
+func (s *Storage) SetRuleEnforced(reqID uint64, rule policy.Rule) error {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	err := s.update(func(txn *badger.Txn) error {
+		return txn.Set(enforcedKey(rule), nil)
+	})
+	failpoint.Inject("setRuleEnforcedErr", func() {
+		err = errors.New("mock set rule enforced error")
+	})
+	if err != nil {
+		logger.Error("recording policy rule enforcement", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// DisableMatching disables every active link any destination of which is matched on behalf of actor and returns their short forms
+func (s *Storage) DisableMatching(reqID uint64, actor string, match func(url string) bool) ([]string, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	ids := make([]uint64, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isLinkKey(item.Key()) {
+				continue
+			}
+
+			value, err := item.ValueCopy(nil)
+			failpoint.Inject("disableMatchingValueCopyErr", func() {
+				err = errors.New("mock disable matching value copy error")
+			})
+			if err != nil {
+				return err
+			}
+
+			link, err := decodeLink(value)
+			if err != nil {
+				return err
+			}
+
+			if link.Status != StatusActive {
+				continue
+			}
+			for _, url := range link.destinations() {
+				if match(url) {
+					ids = append(ids, btou(item.Key()))
+					break
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("looking for links to disable", zap.Error(err))
+		return nil, err
+	}
+
+	disabled := make([]string, 0, len(ids))
+	for _, id := range ids {
+		short := s.encodeID(id)
+		if _, err := s.setStatus(reqID, short, actor, StatusDisabled); err != nil {
+			// link could be deleted or purged since it has been found
+			if isOutcomeErr(err) {
+				continue
+			}
+			return disabled, err
+		}
+		disabled = append(disabled, short)
+	}
+
+	if len(disabled) > 0 {
+		logger.Info("links disabled by policy", zap.String("actor", actor), zap.Int("count", len(disabled)))
+	}
+
+	return disabled, nil
+}
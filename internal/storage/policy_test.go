@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"auto/internal/policy"
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyRules(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	rules, err := s.PolicyRules(0)
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	rule, err := policy.NewRule(policy.ActionBlock, "*.evil.com", true)
+	require.NoError(t, err)
+
+	first, err := s.AddPolicyRule(0, "root", rule)
+	require.NoError(t, err)
+	require.NotEmpty(t, first.ID)
+	require.Equal(t, policy.SourceAPI, first.Source)
+	require.Equal(t, "root", first.Creator)
+	require.False(t, first.CreatedAt.IsZero())
+
+	second, err := s.AddPolicyRule(0, "root", rule)
+	require.NoError(t, err)
+
+	rules, err = s.PolicyRules(0)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, first.ID, rules[0].ID)
+	require.Equal(t, "*.evil.com", rules[0].Pattern)
+	require.Equal(t, second.ID, rules[1].ID)
+
+	err = s.RemovePolicyRule(0, "root", first.ID)
+	require.NoError(t, err)
+
+	err = s.RemovePolicyRule(0, "root", first.ID)
+	require.Equal(t, ErrRuleNotExist, err)
+
+	rules, err = s.PolicyRules(0)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	page, err := s.AuditLog(0, AuditQuery{Object: first.ID})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	require.Equal(t, ActionPolicyAdd, page.Entries[0].Action)
+	require.Equal(t, ActionPolicyRemove, page.Entries[1].Action)
+	require.NotEmpty(t, page.Entries[1].Before)
+}
+
+func TestPolicyRules_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	rule, err := policy.NewRule(policy.ActionBlock, "evil.com", false)
+	require.NoError(t, err)
+
+	rule, err = s.AddPolicyRule(0, "root", rule)
+	require.NoError(t, err)
+
+	for _, name := range []string{"policyValueCopyErr", "addPolicyRuleErr", "removePolicyRuleErr", "ruleEnforcedErr", "setRuleEnforcedErr"} {
+		err = failpoint.Enable(packagePath+name, "return(true)")
+		require.NoError(t, err)
+	}
+	defer func() {
+		for _, name := range []string{"policyValueCopyErr", "addPolicyRuleErr", "removePolicyRuleErr", "ruleEnforcedErr", "setRuleEnforcedErr"} {
+			err = failpoint.Disable(packagePath + name)
+			require.NoError(t, err)
+		}
+	}()
+
+	_, err = s.PolicyRules(0)
+	require.Equal(t, errors.New("mock policy value copy error"), err)
+
+	_, err = s.AddPolicyRule(0, "root", rule)
+	require.Equal(t, errors.New("mock add policy rule error"), err)
+
+	err = s.RemovePolicyRule(0, "root", rule.ID)
+	require.Equal(t, errors.New("mock remove policy rule error"), err)
+
+	_, err = s.RuleEnforced(0, rule)
+	require.Equal(t, errors.New("mock rule enforced error"), err)
+
+	err = s.SetRuleEnforced(0, rule)
+	require.Equal(t, errors.New("mock set rule enforced error"), err)
+}
+
+func TestRuleEnforced(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	rule, err := policy.NewRule(policy.ActionBlock, "evil.com", true)
+	require.NoError(t, err)
+
+	enforced, err := s.RuleEnforced(0, rule)
+	require.NoError(t, err)
+	require.False(t, enforced)
+
+	err = s.SetRuleEnforced(0, rule)
+	require.NoError(t, err)
+
+	// rules are identified by meaning, not by ID
+	rule.ID = "other"
+	enforced, err = s.RuleEnforced(0, rule)
+	require.NoError(t, err)
+	require.True(t, enforced)
+
+	other, err := policy.NewRule(policy.ActionBlock, "evil.com", false)
+	require.NoError(t, err)
+
+	enforced, err = s.RuleEnforced(0, other)
+	require.NoError(t, err)
+	require.False(t, enforced)
+}
+
+func TestDisableMatching(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	phishing, err := s.SaveURL(0, "https://login.evil.com/")
+	require.NoError(t, err)
+
+	deleted, err := s.SaveURL(0, "https://www.evil.com/")
+	require.NoError(t, err)
+	err = s.Delete(0, deleted, "bob")
+	require.NoError(t, err)
+
+	healthy, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	split, err := s.SaveLink(0, Link{Variants: []Variant{
+		{URL: "https://example.com/a", Weight: 1},
+		{URL: "https://promo.evil.com/", Weight: 1},
+	}})
+	require.NoError(t, err)
+
+	targeted, err := s.SaveLink(0, Link{
+		URL:     "https://example.com/",
+		Targets: []TargetRule{{OS: []string{"ios"}, URL: "https://apps.evil.com/"}},
+	})
+	require.NoError(t, err)
+
+	notBefore := time.Now().Add(time.Hour)
+	scheduled, err := s.SaveLink(0, Link{
+		URL:       "https://example.com/launch",
+		NotBefore: &notBefore,
+		Fallback:  "https://soon.evil.com/",
+	})
+	require.NoError(t, err)
+
+	// warming up cache to ensure disabling invalidates it
+	_, err = s.GetURL(0, phishing)
+	require.NoError(t, err)
+
+	disabled, err := s.DisableMatching(0, "policy:1", func(url string) bool {
+		return strings.Contains(url, "evil.com")
+	})
+	require.NoError(t, err)
+	// links are matched by every destination they may redirect to
+	require.Equal(t, []string{phishing, split, targeted, scheduled}, disabled)
+
+	_, err = s.GetURL(0, phishing)
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.GetURL(0, healthy)
+	require.NoError(t, err)
+
+	page, err := s.AuditLog(0, AuditQuery{Actor: "policy:1"})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 4)
+	require.Equal(t, ActionLinkDisable, page.Entries[0].Action)
+
+	err = failpoint.Enable(packagePath+"disableMatchingValueCopyErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "disableMatchingValueCopyErr")
+		require.NoError(t, err)
+	}()
+
+	_, err = s.DisableMatching(0, "policy:1", func(string) bool { return true })
+	require.Equal(t, errors.New("mock disable matching value copy error"), err)
+}
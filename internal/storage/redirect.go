@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+)
+
+// MaxCacheAge limits how long redirects may be cached, in seconds
+const MaxCacheAge = 365 * 24 * 60 * 60
+
+var ErrInvalidRedirect = errors.New("invalid redirect policy")
+
+// redirectStatuses holds HTTP status codes links may redirect with
+var redirectStatuses = map[int]bool{301: true, 302: true, 307: true, 308: true}
+
+// RedirectPolicy defines how link redirects. Zero values mean deployment defaults
+type RedirectPolicy struct {
+	// Redirect is HTTP status code of redirect: 301, 302, 307 or 308
+	Redirect int `json:"redirect,omitempty"`
+	// MaxAge defines how long redirect may be cached in seconds, zero forbids caching
+	MaxAge *int64 `json:"max_age,omitempty"`
+	// Passthrough enables appending extra path of short link requests to target and merging their query parameters
+	Passthrough bool `json:"passthrough,omitempty"`
+	// Query holds parameters set on target query on redirect, they override parameters of template
+	Query map[string]string `json:"query,omitempty"`
+	// Template is a name of query template shared by group of links
+	Template string `json:"template,omitempty"`
+}
+
+// Validate checks redirect status code, cache age and query parameters
+func (p RedirectPolicy) Validate() error {
+	if p.Redirect != 0 && !redirectStatuses[p.Redirect] {
+		return fmt.Errorf("%w: redirect status must be 301, 302, 307 or 308, got %d", ErrInvalidRedirect, p.Redirect)
+	}
+
+	if p.MaxAge != nil && (*p.MaxAge < 0 || *p.MaxAge > MaxCacheAge) {
+		return fmt.Errorf("%w: max age must be between 0 and %d seconds, got %d", ErrInvalidRedirect, MaxCacheAge, *p.MaxAge)
+	}
+
+	if err := ValidateQuery(p.Query); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRedirect, err)
+	}
+
+	if p.Template != "" {
+		if err := validateTemplateName(p.Template); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidRedirect, err)
+		}
+	}
+
+	return nil
+}
+
+// SetRedirect replaces redirect policy of link referenced by short string ID on behalf of actor.
+// Link target and version are kept
+func (s *Storage) SetRedirect(reqID uint64, short string, policy RedirectPolicy, actor string) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	if err := policy.Validate(); err != nil {
+		return Link{}, err
+	}
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err = s.update(func(txn *badger.Txn) error {
+		before, err := readLiveLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		link = before
+		link.RedirectPolicy = policy
+
+		if err := writeLink(txn, id, link); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, actor, ActionLinkRedirect, short, &before, &link), s.now())
+	})
+	failpoint.Inject("setRedirectErr", func() {
+		err = errors.New("mock set redirect error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("changing link redirect policy", zap.Error(err))
+		}
+		return Link{}, err
+	}
+
+	s.cache.invalidate(id)
+
+	return link, nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestRedirectPolicy_Validate(t *testing.T) {
+	zero, week, negative, tooLong := int64(0), int64(7*24*60*60), int64(-1), int64(MaxCacheAge+1)
+
+	for _, p := range []RedirectPolicy{{}, {Redirect: 302}, {Redirect: 308, MaxAge: &zero}, {MaxAge: &week}} {
+		require.NoError(t, p.Validate())
+	}
+
+	for _, p := range []RedirectPolicy{{Redirect: 200}, {Redirect: 303}, {MaxAge: &negative}, {MaxAge: &tooLong}} {
+		require.True(t, errors.Is(p.Validate(), ErrInvalidRedirect))
+	}
+}
+
+func TestSetRedirect(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	maxAge := int64(3600)
+	short, err := s.SaveLink(0, Link{URL: "https://example.com/", RedirectPolicy: RedirectPolicy{Redirect: 302}})
+	require.NoError(t, err)
+
+	link, err := s.GetLink(0, short)
+	require.NoError(t, err)
+	require.Equal(t, 302, link.Redirect)
+	require.Nil(t, link.MaxAge)
+
+	link, err = s.SetRedirect(0, short, RedirectPolicy{Redirect: 307, MaxAge: &maxAge}, "bob")
+	require.NoError(t, err)
+	require.Equal(t, 307, link.Redirect)
+	require.Equal(t, &maxAge, link.MaxAge)
+	require.Equal(t, uint64(1), link.Version)
+
+	// cached link is replaced
+	link, err = s.GetLink(0, short)
+	require.NoError(t, err)
+	require.Equal(t, 307, link.Redirect)
+	require.Equal(t, "https://example.com/", link.URL)
+
+	page, err := s.AuditLog(0, AuditQuery{Object: short, Action: ActionLinkRedirect})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, "bob", page.Entries[0].Actor)
+
+	_, err = s.SetRedirect(0, short, RedirectPolicy{Redirect: 200}, "bob")
+	require.True(t, errors.Is(err, ErrInvalidRedirect))
+
+	_, err = s.SaveLink(0, Link{URL: "https://example.com/", RedirectPolicy: RedirectPolicy{Redirect: 200}})
+	require.True(t, errors.Is(err, ErrInvalidRedirect))
+
+	_, err = s.SetRedirect(0, s.encodeID(100), RedirectPolicy{}, "bob")
+	require.Equal(t, ErrShortNotExist, err)
+
+	err = s.Delete(0, short, "bob")
+	require.NoError(t, err)
+
+	_, err = s.SetRedirect(0, short, RedirectPolicy{}, "bob")
+	require.Equal(t, ErrShortGone, err)
+
+	_, err = s.GetLink(0, short)
+	require.Equal(t, ErrShortGone, err)
+}
+
+func TestSetRedirect_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	short, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	err = failpoint.Enable(packagePath+"setRedirectErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "setRedirectErr")
+		require.NoError(t, err)
+	}()
+
+	_, err = s.SetRedirect(0, short, RedirectPolicy{Redirect: 302}, "bob")
+	require.Equal(t, errors.New("mock set redirect error"), err)
+}
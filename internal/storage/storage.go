@@ -3,24 +3,61 @@ package storage
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/dgraph-io/badger/v2"
 	"github.com/pingcap/failpoint"
 	"github.com/speps/go-hashids"
 	"go.uber.org/zap"
 	"math"
+	"time"
 )
 
 var (
-	ErrShortNotExist = errors.New("short form does not exist")
-	ErrInvalidShort  = errors.New("invalid short form")
+	ErrShortNotExist     = errors.New("short form does not exist")
+	ErrInvalidShort      = errors.New("invalid short form")
+	ErrShortGone         = errors.New("short form is disabled or deleted")
+	ErrInvalidExpiry     = errors.New("invalid link expiry")
+	ErrShortNotActive    = errors.New("short form is not active yet")
+	ErrInvalidActivation = errors.New("invalid link activation")
 )
 
+// seqKey holds lease of link ID sequence
+var seqKey = []byte("seq")
+
+// maxTakenIDs limits attempts to find link ID that is not held by existing link or tombstone
+const maxTakenIDs = 100
+
+// isOutcomeErr reports whether err describes state of requested link rather than storage failure
+func isOutcomeErr(err error) bool {
+	return errors.Is(err, ErrShortNotExist) ||
+		errors.Is(err, ErrInvalidShort) ||
+		errors.Is(err, ErrShortGone) ||
+		errors.Is(err, ErrVersionNotExist) ||
+		errors.Is(err, ErrInvalidRedirect) ||
+		errors.Is(err, ErrInvalidExpiry) ||
+		errors.Is(err, ErrInvalidActivation) ||
+		errors.Is(err, ErrInvalidVariants) ||
+		errors.Is(err, ErrInvalidTargets) ||
+		errors.Is(err, ErrShortNotActive) ||
+		errors.Is(err, ErrInvalidPassword) ||
+		errors.Is(err, ErrWrongPassword)
+}
+
 // Storage defines fields used in db interaction process
 type Storage struct {
+	// clicks counts clicks of links without limit, every clickFoldInterval one folds deltas of clicked link.
+	// It is kept first, so atomic access is 64-bit aligned on 32-bit platforms
+	clicks uint64
 	logger *zap.Logger
 	db     *badger.DB
 	seq    *badger.Sequence
 	hashID *hashids.HashID
+	cache  *linkCache
+	// clickLocks serializes counting clicks of every click limited link, so its concurrent clicks wait
+	// instead of exhausting conflict retries. The limit itself is checked inside counting transaction
+	clickLocks keyedMutex
+	// now returns current time, links are activated, expired and stamped according to it
+	now func() time.Time
 }
 
 // New constructs Storage instance with provided path and default badger options
@@ -42,7 +79,7 @@ func New(logger *zap.Logger, path string) (*Storage, error) {
 		return nil, err
 	}
 
-	seq, err := db.GetSequence([]byte("seq"), 100)
+	seq, err := db.GetSequence(seqKey, 100)
 	failpoint.Inject("getSequenceErr", func() {
 		err = errors.New("mock get sequence error")
 	})
@@ -53,13 +90,7 @@ func New(logger *zap.Logger, path string) (*Storage, error) {
 		return nil, err
 	}
 
-	data := hashids.NewData()
-	data.MinLength = 7
-
-	hashID, err := hashids.NewWithData(data)
-	failpoint.Inject("newWithDataErr", func() {
-		err = errors.New("mock NewWithData error")
-	})
+	hashID, err := newHashID()
 	if err != nil {
 		logger.Error("generating new hashID", zap.Error(err))
 		logger.Info("releasing sequence")
@@ -74,9 +105,35 @@ func New(logger *zap.Logger, path string) (*Storage, error) {
 		db:     db,
 		seq:    seq,
 		hashID: hashID,
+		cache:  newLinkCache(defaultCacheSize),
+		now:    time.Now,
 	}, err
 }
 
+// Now returns current time of storage clock
+func (s *Storage) Now() time.Time {
+	return s.now()
+}
+
+// SetClock replaces time source of storage, so link activation and expiry can be checked at any time.
+// It must be called before storage is used
+func (s *Storage) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// newHashID constructs encoder of link IDs to short forms
+func newHashID() (*hashids.HashID, error) {
+	data := hashids.NewData()
+	data.MinLength = 7
+
+	hashID, err := hashids.NewWithData(data)
+	failpoint.Inject("newWithDataErr", func() {
+		err = errors.New("mock NewWithData error")
+	})
+
+	return hashID, err
+}
+
 // Close releases sequence and closes database
 func (s *Storage) Close() error {
 	s.logger.Info("closing storage")
@@ -106,78 +163,222 @@ func (s *Storage) Close() error {
 
 // SaveURL returns short unique string ID for provided URL
 func (s *Storage) SaveURL(reqID uint64, url string) (string, error) {
+	return s.SaveLink(reqID, Link{URL: url})
+}
+
+// SaveLink stores link record as its first version and returns short unique string ID for it.
+// Version, status and timestamps are set by storage
+func (s *Storage) SaveLink(reqID uint64, link Link) (string, error) {
+	short, _, err := s.CreateLink(reqID, link)
+
+	return short, err
+}
+
+// CreateLink stores link record as its first version and returns short unique string ID for it
+// along with the stored record
+func (s *Storage) CreateLink(reqID uint64, link Link) (string, Link, error) {
 	logger := s.logger.With(zap.Uint64("request id", reqID))
 
-	id, err := s.seq.Next()
-	failpoint.Inject("nextIDErr", func() {
-		err = errors.New("mock next ID error")
-	})
-	if err != nil {
-		logger.Error("retrieving next id for url", zap.Error(err))
-		return "", err
+	if err := link.RedirectPolicy.Validate(); err != nil {
+		return "", Link{}, err
 	}
 
-	// skip error handing due to impossible condition
-	// EncodeInt64 inside checks if provided int64 slice is not empty and holds values grater or equal zero
-	// uint64ToInt64Slice by design can not return empty slice (compilation check)
-	// uint64ToInt64Slice also can not hold negative values
-	short, _ := s.hashID.EncodeInt64(uint64ToInt64Slice(id))
-	//if err != nil {
-	//	logger.Error("generating short form", zap.Error(err))
-	//	return "", err
-	//}
+	now := s.now().UTC()
+	if link.ExpiresAt != nil {
+		if !link.ExpiresAt.After(now) {
+			return "", Link{}, fmt.Errorf("%w: expiry time must be in the future", ErrInvalidExpiry)
+		}
+		expiresAt := link.ExpiresAt.UTC()
+		link.ExpiresAt = &expiresAt
+	}
+	if err := link.validateActivation(); err != nil {
+		return "", Link{}, err
+	}
+	// the first variant is link target, so history, search and policy checks see it as any other target
+	if link.Variants != nil {
+		if err := validateVariants(link.Variants); err != nil {
+			return "", Link{}, err
+		}
+		link.URL = link.Variants[0].URL
+	}
+	if err := validateTargets(link.Targets); err != nil {
+		return "", Link{}, err
+	}
+	if len(link.Targets) > 0 && len(link.Variants) > 0 {
+		return "", Link{}, fmt.Errorf("%w: split link can not have targeting rules", ErrInvalidTargets)
+	}
 
-	err = s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(utob(id), []byte(url))
-	})
-	failpoint.Inject("updateErr", func() {
-		err = errors.New("mock update error")
-	})
-	if err != nil {
-		logger.Error("updating database", zap.Error(err))
-		return "", err
+	var hash []byte
+	if link.Password != "" {
+		var err error
+		if hash, err = hashPassword(link.Password); err != nil {
+			if !isOutcomeErr(err) {
+				logger.Error("hashing link password", zap.Error(err))
+			}
+			return "", Link{}, err
+		}
 	}
+	link.Protected = hash != nil
+	link.Password = ""
+
+	link.Version = 1
+	link.Status = StatusActive
+	link.CreatedAt = now
+	link.Editor = link.Creator
+	link.UpdatedAt = now
+
+	// sequence never returns the same ID twice but database restored from backup may be behind issued IDs,
+	// so IDs held by existing links or tombstones are skipped to never reissue short form
+	for i := 0; i < maxTakenIDs; i++ {
+		id, err := s.seq.Next()
+		failpoint.Inject("nextIDErr", func() {
+			err = errors.New("mock next ID error")
+		})
+		if err != nil {
+			logger.Error("retrieving next id for url", zap.Error(err))
+			return "", Link{}, err
+		}
+
+		taken := false
+		err = s.db.Update(func(txn *badger.Txn) error {
+			taken, err = idTaken(txn, id)
+			if err != nil || taken {
+				return err
+			}
+
+			if err := writeLink(txn, id, link); err != nil {
+				return err
+			}
+
+			if hash != nil {
+				if err := txn.Set(passwordKey(id), hash); err != nil {
+					return err
+				}
+			}
 
-	return short, nil
+			return reindexLink(txn, id, Link{}, link)
+		})
+		failpoint.Inject("updateErr", func() {
+			err = errors.New("mock update error")
+		})
+		if err != nil {
+			logger.Error("updating database", zap.Error(err))
+			return "", Link{}, err
+		}
+
+		if taken {
+			logger.Warn("skipping taken id", zap.Uint64("id", id))
+			continue
+		}
+
+		return s.encodeID(id), link, nil
+	}
+
+	logger.Error("no free id found", zap.Int("attempts", maxTakenIDs))
+
+	return "", Link{}, errors.New("no free id found")
 }
 
 // GetURL returns URL that has been saved referenced by short string ID
 func (s *Storage) GetURL(reqID uint64, short string) (string, error) {
-	logger := s.logger.With(zap.Uint64("request id", reqID))
+	link, err := s.GetLink(reqID, short)
+	if err != nil {
+		return "", err
+	}
+
+	return link.URL, nil
+}
 
-	ids, err := s.hashID.DecodeInt64WithError(short)
+// GetLink returns active, activated, not expired and not exhausted link record referenced by short string ID
+func (s *Storage) GetLink(reqID uint64, short string) (Link, error) {
+	id, link, err := s.getLink(reqID, short)
 	if err != nil {
-		return "", ErrInvalidShort
+		return Link{}, err
 	}
 
-	id := int64SliceToUint64(ids)
+	if err := link.checkActive(s.now()); err != nil {
+		return Link{}, err
+	}
 
-	var urlBytes []byte
-	err = s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(utob(id))
-		if err != nil {
-			return err
+	if link.MaxClicks > 0 {
+		if err := s.checkClicks(reqID, id, link); err != nil {
+			return Link{}, err
 		}
+	}
 
-		urlBytes, err = item.ValueCopy(nil)
-		failpoint.Inject("valueCopyErr", func() {
-			err = errors.New("mock value copy error")
-		})
+	return link, nil
+}
+
+// LookupLink returns link record referenced by short string ID whatever its status is
+func (s *Storage) LookupLink(reqID uint64, short string) (Link, error) {
+	_, link, err := s.getLink(reqID, short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	return link, nil
+}
+
+// LinkState returns link record referenced by short string ID whatever its status is, with status of active links
+// which do not redirect anymore replaced by effective one: StatusExpired or StatusExhausted
+func (s *Storage) LinkState(reqID uint64, short string) (Link, error) {
+	id, link, err := s.getLink(reqID, short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if link.Status != StatusActive {
+		return link, nil
+	}
+
+	if link.ExpiresAt != nil && !s.now().Before(*link.ExpiresAt) {
+		link.Status = StatusExpired
+		return link, nil
+	}
+
+	if link.MaxClicks > 0 {
+		err := s.checkClicks(reqID, id, link)
+		if errors.Is(err, ErrShortGone) {
+			link.Status = StatusExhausted
+			return link, nil
+		}
 		if err != nil {
-			return err
+			return Link{}, err
 		}
+	}
+
+	return link, nil
+}
 
-		return nil
+// getLink returns link ID and record referenced by short string ID consulting cache first
+func (s *Storage) getLink(reqID uint64, short string) (uint64, Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return 0, Link{}, err
+	}
+
+	link, ok, generation := s.cache.get(id)
+	if ok {
+		return id, link, nil
+	}
+
+	err = s.db.View(func(txn *badger.Txn) error {
+		link, err = readLink(txn, id)
+		return err
 	})
 	if err != nil {
-		if errors.Is(err, badger.ErrKeyNotFound) {
-			return "", ErrShortNotExist
+		if isOutcomeErr(err) {
+			return 0, Link{}, err
 		}
 		logger.Error("retrieving source URL", zap.Error(err))
-		return "", err
+		return 0, Link{}, err
 	}
 
-	return string(urlBytes), nil
+	s.cache.put(id, link, generation)
+
+	return id, link, nil
 }
 
 // utob converts uint64 to byte slice
@@ -188,6 +389,11 @@ func utob(u uint64) []byte {
 	return buf
 }
 
+// btou converts byte slice produced by utob back to uint64
+func btou(b []byte) uint64 {
+	return binary.LittleEndian.Uint64(b)
+}
+
 // uint64ToInt64Slice converts uint64 integer to slice of int64
 // that slice after summing up via int64SliceToUint64 gives original uint64
 func uint64ToInt64Slice(u uint64) []int64 {
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"regexp"
+)
+
+// maxTargets limits number of targeting rules of link
+const maxTargets = 20
+
+var ErrInvalidTargets = errors.New("invalid link targeting rules")
+
+// operating systems and device types targeting rules may match
+var (
+	TargetOS      = []string{"android", "ios", "windows", "macos", "linux", "chromeos"}
+	TargetDevices = []string{"mobile", "tablet", "desktop", "bot"}
+)
+
+var (
+	// targetLanguage matches lowercased language tags, e.g. "de" or "pt-br"
+	targetLanguage = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	// targetCountry matches ISO 3166-1 alpha-2 country codes
+	targetCountry = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// TargetRule defines destination of link for visits matching its conditions.
+// Visit matches rule when it matches every condition rule has, condition matches any of its values
+type TargetRule struct {
+	OS       []string `json:"os,omitempty"`
+	Device   []string `json:"device,omitempty"`
+	Language []string `json:"language,omitempty"`
+	Country  []string `json:"country,omitempty"`
+	URL      string   `json:"url"`
+}
+
+// Visit defines properties of redirect request targeting rules are matched against, empty when not known
+type Visit struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	// Language is the most preferred language tag of visitor, lowercased
+	Language string `json:"language,omitempty"`
+	Country  string `json:"country,omitempty"`
+}
+
+// validateTargets checks targeting rules of link, rules without conditions would shadow link URL
+func validateTargets(targets []TargetRule) error {
+	if len(targets) > maxTargets {
+		return fmt.Errorf("%w: link must have at most %d rules", ErrInvalidTargets, maxTargets)
+	}
+
+	for i, t := range targets {
+		if t.URL == "" {
+			return fmt.Errorf("%w: rule %d URL must not be empty", ErrInvalidTargets, i)
+		}
+		if len(t.OS)+len(t.Device)+len(t.Language)+len(t.Country) == 0 {
+			return fmt.Errorf("%w: rule %d must have conditions", ErrInvalidTargets, i)
+		}
+
+		for _, os := range t.OS {
+			if !contains(TargetOS, os) {
+				return fmt.Errorf("%w: rule %d OS must be one of %v", ErrInvalidTargets, i, TargetOS)
+			}
+		}
+		for _, device := range t.Device {
+			if !contains(TargetDevices, device) {
+				return fmt.Errorf("%w: rule %d device must be one of %v", ErrInvalidTargets, i, TargetDevices)
+			}
+		}
+		for _, language := range t.Language {
+			if !targetLanguage.MatchString(language) {
+				return fmt.Errorf("%w: rule %d language must be lowercase language tag", ErrInvalidTargets, i)
+			}
+		}
+		for _, country := range t.Country {
+			if !targetCountry.MatchString(country) {
+				return fmt.Errorf("%w: rule %d country must be uppercase ISO 3166-1 alpha-2 code", ErrInvalidTargets, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether values hold value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches reports whether visit matches every condition of rule
+func (t TargetRule) matches(v Visit) bool {
+	if len(t.OS) > 0 && !contains(t.OS, v.OS) {
+		return false
+	}
+	if len(t.Device) > 0 && !contains(t.Device, v.Device) {
+		return false
+	}
+	if len(t.Country) > 0 && !contains(t.Country, v.Country) {
+		return false
+	}
+
+	if len(t.Language) == 0 {
+		return true
+	}
+	// language ranges match more specific tags of visitor, "pt" matches "pt-br" but not vice versa
+	for _, language := range t.Language {
+		if v.Language == language || len(v.Language) > len(language) && v.Language[:len(language)+1] == language+"-" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Target returns link with URL of the first targeting rule visit matches and index of the rule.
+// Visits matching no rule get link as is with -1 index
+func (l Link) Target(v Visit) (Link, int) {
+	for i, t := range l.Targets {
+		if t.matches(v) {
+			l.URL = t.URL
+			return l, i
+		}
+	}
+
+	return l, -1
+}
+
+// SetTargets replaces targeting rules of link referenced by short string ID on behalf of actor,
+// empty rules make link redirect to its URL only. Link target and version are kept
+func (s *Storage) SetTargets(reqID uint64, short string, targets []TargetRule, actor string) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	if err := validateTargets(targets); err != nil {
+		return Link{}, err
+	}
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err = s.update(func(txn *badger.Txn) error {
+		before, err := readLiveLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		if len(targets) > 0 && len(before.Variants) > 0 {
+			return fmt.Errorf("%w: split link can not have targeting rules", ErrInvalidTargets)
+		}
+
+		link = before
+		link.Targets = targets
+
+		if err := writeLink(txn, id, link); err != nil {
+			return err
+		}
+		if err := reindexLink(txn, id, before, link); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, actor, ActionLinkTargets, short, &before, &link), s.now())
+	})
+	failpoint.Inject("setTargetsErr", func() {
+		err = errors.New("mock set targets error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("changing link targeting rules", zap.Error(err))
+		}
+		return Link{}, err
+	}
+
+	s.cache.invalidate(id)
+
+	return link, nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestValidateTargets(t *testing.T) {
+	require.NoError(t, validateTargets(nil))
+	require.NoError(t, validateTargets([]TargetRule{
+		{OS: []string{"ios"}, Device: []string{"mobile", "tablet"}, URL: "https://apps.apple.com/app"},
+		{Language: []string{"pt-br"}, Country: []string{"BR"}, URL: "https://example.com/br"},
+	}))
+
+	for _, rule := range []TargetRule{
+		{OS: []string{"ios"}},
+		{URL: "https://example.com/"},
+		{OS: []string{"iOS"}, URL: "https://example.com/"},
+		{Device: []string{"phone"}, URL: "https://example.com/"},
+		{Language: []string{"pt_BR"}, URL: "https://example.com/"},
+		{Country: []string{"br"}, URL: "https://example.com/"},
+	} {
+		require.True(t, errors.Is(validateTargets([]TargetRule{rule}), ErrInvalidTargets), rule)
+	}
+
+	many := make([]TargetRule, maxTargets+1)
+	for i := range many {
+		many[i] = TargetRule{OS: []string{"ios"}, URL: "https://example.com/"}
+	}
+	require.True(t, errors.Is(validateTargets(many), ErrInvalidTargets))
+}
+
+func TestLink_Target(t *testing.T) {
+	link := Link{URL: "https://example.com/", Targets: []TargetRule{
+		{OS: []string{"ios"}, Device: []string{"mobile", "tablet"}, URL: "https://apps.apple.com/app"},
+		{OS: []string{"android"}, URL: "https://play.google.com/app"},
+		{Language: []string{"pt"}, Country: []string{"BR", "PT"}, URL: "https://example.com/pt"},
+		{Language: []string{"de-ch"}, URL: "https://example.com/ch"},
+	}}
+
+	for _, tc := range []struct {
+		visit Visit
+		rule  int
+	}{
+		{Visit{}, -1},
+		{Visit{OS: "ios", Device: "tablet"}, 0},
+		// every condition must match
+		{Visit{OS: "ios", Device: "desktop"}, -1},
+		{Visit{OS: "android", Device: "mobile", Language: "pt-br", Country: "BR"}, 1},
+		{Visit{Language: "pt-br", Country: "BR"}, 2},
+		{Visit{Language: "pt", Country: "PT"}, 2},
+		{Visit{Language: "pt-br"}, -1},
+		{Visit{Language: "ptx", Country: "BR"}, -1},
+		{Visit{Language: "de-ch-1996"}, 3},
+		{Visit{Language: "de"}, -1},
+	} {
+		target, rule := link.Target(tc.visit)
+		require.Equal(t, tc.rule, rule, tc.visit)
+		if rule >= 0 {
+			require.Equal(t, link.Targets[rule].URL, target.URL, tc.visit)
+		} else {
+			require.Equal(t, link.URL, target.URL, tc.visit)
+		}
+	}
+}
+
+func TestSetTargets(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	targets := []TargetRule{{OS: []string{"ios"}, URL: "https://apps.apple.com/app"}}
+	variants := []Variant{{"https://example.com/a", 1}, {"https://example.com/b", 1}}
+
+	_, _, err = s.CreateLink(0, Link{Variants: variants, Targets: targets})
+	require.True(t, errors.Is(err, ErrInvalidTargets))
+
+	split, err := s.SaveLink(0, Link{Variants: variants})
+	require.NoError(t, err)
+
+	_, err = s.SetTargets(0, split, targets, "bob")
+	require.True(t, errors.Is(err, ErrInvalidTargets))
+
+	short, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	_, err = s.SetTargets(0, short, []TargetRule{{URL: "https://example.com/"}}, "bob")
+	require.True(t, errors.Is(err, ErrInvalidTargets))
+
+	link, err := s.SetTargets(0, short, targets, "bob")
+	require.NoError(t, err)
+	require.Equal(t, targets, link.Targets)
+	require.Equal(t, uint64(1), link.Version)
+
+	link, err = s.GetLink(0, short)
+	require.NoError(t, err)
+	target, rule := link.Target(Visit{OS: "ios"})
+	require.Equal(t, 0, rule)
+	require.Equal(t, "https://apps.apple.com/app", target.URL)
+
+	link, err = s.SetTargets(0, short, nil, "bob")
+	require.NoError(t, err)
+	require.Empty(t, link.Targets)
+
+	page, err := s.AuditLog(0, AuditQuery{Action: ActionLinkTargets})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+
+	err = failpoint.Enable(packagePath+"setTargetsErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "setTargetsErr")
+		require.NoError(t, err)
+	}()
+
+	_, err = s.SetTargets(0, short, targets, "bob")
+	require.Equal(t, errors.New("mock set targets error"), err)
+}
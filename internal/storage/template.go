@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"regexp"
+	"time"
+)
+
+// templatesPrefix marks keys of query templates. Template key layout is templatesPrefix + template name
+var templatesPrefix = []byte("templates/")
+
+// maxQueryParams limits number of parameters link or template adds to target query
+const maxQueryParams = 32
+
+var (
+	ErrTemplateNotExist = errors.New("query template does not exist")
+	ErrInvalidTemplate  = errors.New("invalid query template")
+)
+
+var (
+	// templateName defines allowed names of query templates
+	templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	// placeholder matches placeholders of query parameter values
+	placeholder = regexp.MustCompile(`{[^{}]*}`)
+)
+
+// Query parameter value placeholders expanded on redirect
+const (
+	// PlaceholderCode is replaced with short form of the link
+	PlaceholderCode = "{code}"
+	// PlaceholderClickID is replaced with unique ID of the click
+	PlaceholderClickID = "{click_id}"
+)
+
+// QueryTemplate defines named set of query parameters added to targets of links referencing it
+type QueryTemplate struct {
+	Name      string            `json:"name"`
+	Params    map[string]string `json:"params"`
+	Editor    string            `json:"editor,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ValidateQuery checks query parameters added to link target: names must not be empty
+// and values may hold PlaceholderCode and PlaceholderClickID placeholders only
+func ValidateQuery(params map[string]string) error {
+	if len(params) > maxQueryParams {
+		return fmt.Errorf("at most %d query parameters are allowed, got %d", maxQueryParams, len(params))
+	}
+
+	for name, value := range params {
+		if name == "" {
+			return errors.New("query parameter name must not be empty")
+		}
+
+		for _, p := range placeholder.FindAllString(value, -1) {
+			if p != PlaceholderCode && p != PlaceholderClickID {
+				return fmt.Errorf("query parameter %q holds unknown placeholder %s", name, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateTemplateName checks name of query template
+func validateTemplateName(name string) error {
+	if !templateName.MatchString(name) {
+		return fmt.Errorf("template name must be 1 to 64 lowercase letters, digits, '-' or '_', got %q", name)
+	}
+
+	return nil
+}
+
+// templateKey returns query template key for template name
+func templateKey(name string) []byte {
+	return append(append([]byte{}, templatesPrefix...), name...)
+}
+
+// encodeTemplate serializes query template
+func encodeTemplate(t QueryTemplate) []byte {
+	b, _ := json.Marshal(t)
+
+	return b
+}
+
+// readTemplate reads and decodes query template inside provided transaction
+func readTemplate(txn *badger.Txn, name string) (QueryTemplate, error) {
+	var t QueryTemplate
+
+	item, err := txn.Get(templateKey(name))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return t, ErrTemplateNotExist
+		}
+		return t, err
+	}
+
+	err = item.Value(func(value []byte) error {
+		return json.Unmarshal(value, &t)
+	})
+
+	return t, err
+}
+
+// QueryTemplates returns query templates ordered by name
+func (s *Storage) QueryTemplates(reqID uint64) ([]QueryTemplate, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	templates := make([]QueryTemplate, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = templatesPrefix
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(templatesPrefix); it.ValidForPrefix(templatesPrefix); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			failpoint.Inject("templateValueCopyErr", func() {
+				err = errors.New("mock template value copy error")
+			})
+			if err != nil {
+				return err
+			}
+
+			var t QueryTemplate
+			if err := json.Unmarshal(value, &t); err != nil {
+				return err
+			}
+			templates = append(templates, t)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("retrieving query templates", zap.Error(err))
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// QueryTemplate returns query template by name
+func (s *Storage) QueryTemplate(reqID uint64, name string) (QueryTemplate, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	var t QueryTemplate
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		t, err = readTemplate(txn, name)
+		return err
+	})
+	failpoint.Inject("queryTemplateErr", func() {
+		err = errors.New("mock query template error")
+	})
+	if err != nil {
+		if !errors.Is(err, ErrTemplateNotExist) {
+			logger.Error("retrieving query template", zap.String("name", name), zap.Error(err))
+		}
+		return QueryTemplate{}, err
+	}
+
+	return t, nil
+}
+
+// PutQueryTemplate creates or replaces query template on behalf of actor. Links referencing it by name
+// pick the change up on the next redirect
+func (s *Storage) PutQueryTemplate(reqID uint64, actor string, t QueryTemplate) (QueryTemplate, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	if err := validateTemplateName(t.Name); err != nil {
+		return QueryTemplate{}, fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+	}
+	if err := ValidateQuery(t.Params); err != nil {
+		return QueryTemplate{}, fmt.Errorf("%w: %s", ErrInvalidTemplate, err)
+	}
+
+	if t.Params == nil {
+		t.Params = map[string]string{}
+	}
+	t.Editor = actor
+	t.UpdatedAt = s.now().UTC()
+
+	err := s.update(func(txn *badger.Txn) error {
+		entry := AuditEntry{
+			Actor:     actor,
+			Action:    ActionTemplatePut,
+			Object:    t.Name,
+			After:     encodeTemplate(t),
+			RequestID: reqID,
+		}
+
+		before, err := readTemplate(txn, t.Name)
+		if err == nil {
+			entry.Before = encodeTemplate(before)
+		} else if !errors.Is(err, ErrTemplateNotExist) {
+			return err
+		}
+
+		if err := txn.Set(templateKey(t.Name), encodeTemplate(t)); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, entry, s.now())
+	})
+	failpoint.Inject("putQueryTemplateErr", func() {
+		err = errors.New("mock put query template error")
+	})
+	if err != nil {
+		logger.Error("storing query template", zap.Error(err))
+		return QueryTemplate{}, err
+	}
+
+	return t, nil
+}
+
+// RemoveQueryTemplate removes query template on behalf of actor.
+// Links referencing it keep the reference and add no template parameters until template is put again
+func (s *Storage) RemoveQueryTemplate(reqID uint64, actor, name string) error {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	err := s.update(func(txn *badger.Txn) error {
+		before, err := readTemplate(txn, name)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(templateKey(name)); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, AuditEntry{
+			Actor:     actor,
+			Action:    ActionTemplateRemove,
+			Object:    name,
+			Before:    encodeTemplate(before),
+			RequestID: reqID,
+		}, s.now())
+	})
+	failpoint.Inject("removeQueryTemplateErr", func() {
+		err = errors.New("mock remove query template error")
+	})
+	if err != nil {
+		if !errors.Is(err, ErrTemplateNotExist) {
+			logger.Error("removing query template", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// LinkQuery returns query parameters added to target of link: parameters of its template
+// overridden by its own ones. Missing template adds nothing
+func (s *Storage) LinkQuery(reqID uint64, link Link) (map[string]string, error) {
+	if link.Template == "" {
+		return link.Query, nil
+	}
+
+	t, err := s.QueryTemplate(reqID, link.Template)
+	if err != nil {
+		if errors.Is(err, ErrTemplateNotExist) {
+			return link.Query, nil
+		}
+		return nil, err
+	}
+
+	params := make(map[string]string, len(t.Params)+len(link.Query))
+	for name, value := range t.Params {
+		params[name] = value
+	}
+	for name, value := range link.Query {
+		params[name] = value
+	}
+
+	return params, nil
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestValidateQuery(t *testing.T) {
+	require.NoError(t, ValidateQuery(nil))
+	require.NoError(t, ValidateQuery(map[string]string{"utm_source": "mail", "ref": "{code}-{click_id}", "empty": ""}))
+
+	for _, params := range []map[string]string{
+		{"": "mail"},
+		{"ref": "{short}"},
+		{"ref": "{}"},
+	} {
+		require.Error(t, ValidateQuery(params), params)
+	}
+
+	many := map[string]string{}
+	for i := 0; i <= maxQueryParams; i++ {
+		many[string(rune('a'+i))] = ""
+	}
+	require.Error(t, ValidateQuery(many))
+}
+
+func TestQueryTemplates(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	templates, err := s.QueryTemplates(0)
+	require.NoError(t, err)
+	require.Empty(t, templates)
+
+	_, err = s.QueryTemplate(0, "spring")
+	require.Equal(t, ErrTemplateNotExist, err)
+
+	for _, tpl := range []QueryTemplate{
+		{Name: "Spring"},
+		{Name: "spring/sale"},
+		{Name: "spring", Params: map[string]string{"ref": "{short}"}},
+	} {
+		_, err = s.PutQueryTemplate(0, "bob", tpl)
+		require.True(t, errors.Is(err, ErrInvalidTemplate), tpl.Name)
+	}
+
+	spring, err := s.PutQueryTemplate(0, "bob", QueryTemplate{Name: "spring", Params: map[string]string{"utm_campaign": "spring"}})
+	require.NoError(t, err)
+	require.Equal(t, "bob", spring.Editor)
+	require.False(t, spring.UpdatedAt.IsZero())
+
+	_, err = s.PutQueryTemplate(0, "bob", QueryTemplate{Name: "autumn"})
+	require.NoError(t, err)
+
+	spring, err = s.PutQueryTemplate(0, "alice", QueryTemplate{Name: "spring", Params: map[string]string{"utm_campaign": "spring-2"}})
+	require.NoError(t, err)
+
+	templates, err = s.QueryTemplates(0)
+	require.NoError(t, err)
+	require.Len(t, templates, 2)
+	require.Equal(t, "autumn", templates[0].Name)
+	require.Equal(t, map[string]string{}, templates[0].Params)
+	require.Equal(t, "spring", templates[1].Name)
+	require.Equal(t, "spring-2", templates[1].Params["utm_campaign"])
+
+	err = s.RemoveQueryTemplate(0, "bob", "autumn")
+	require.NoError(t, err)
+
+	err = s.RemoveQueryTemplate(0, "bob", "autumn")
+	require.Equal(t, ErrTemplateNotExist, err)
+
+	page, err := s.AuditLog(0, AuditQuery{Object: "spring"})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 2)
+	require.Equal(t, ActionTemplatePut, page.Entries[0].Action)
+	require.Empty(t, page.Entries[0].Before)
+	require.NotEmpty(t, page.Entries[1].Before)
+
+	page, err = s.AuditLog(0, AuditQuery{Action: ActionTemplateRemove})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, "autumn", page.Entries[0].Object)
+}
+
+func TestLinkQuery(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, _, err = s.CreateLink(0, Link{URL: "https://example.com/", RedirectPolicy: RedirectPolicy{Template: "Spring"}})
+	require.True(t, errors.Is(err, ErrInvalidRedirect))
+
+	_, _, err = s.CreateLink(0, Link{URL: "https://example.com/", RedirectPolicy: RedirectPolicy{Query: map[string]string{"ref": "{id}"}}})
+	require.True(t, errors.Is(err, ErrInvalidRedirect))
+
+	_, link, err := s.CreateLink(0, Link{URL: "https://example.com/", RedirectPolicy: RedirectPolicy{
+		Query:    map[string]string{"utm_source": "mail", "ref": "{code}"},
+		Template: "spring",
+	}})
+	require.NoError(t, err)
+
+	// missing template adds nothing
+	params, err := s.LinkQuery(0, link)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"utm_source": "mail", "ref": "{code}"}, params)
+
+	_, err = s.PutQueryTemplate(0, "bob", QueryTemplate{Name: "spring", Params: map[string]string{"utm_source": "site", "utm_campaign": "spring"}})
+	require.NoError(t, err)
+
+	// link parameters override template ones
+	params, err = s.LinkQuery(0, link)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"utm_source": "mail", "utm_campaign": "spring", "ref": "{code}"}, params)
+
+	params, err = s.LinkQuery(0, Link{URL: "https://example.com/"})
+	require.NoError(t, err)
+	require.Empty(t, params)
+}
+
+func TestQueryTemplate_Err(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, err = s.PutQueryTemplate(0, "bob", QueryTemplate{Name: "spring"})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		failpoint string
+		call      func() error
+	}{
+		{"templateValueCopyErr", func() error {
+			_, err := s.QueryTemplates(0)
+			return err
+		}},
+		{"queryTemplateErr", func() error {
+			_, err := s.LinkQuery(0, Link{RedirectPolicy: RedirectPolicy{Template: "spring"}})
+			return err
+		}},
+		{"putQueryTemplateErr", func() error {
+			_, err := s.PutQueryTemplate(0, "bob", QueryTemplate{Name: "spring"})
+			return err
+		}},
+		{"removeQueryTemplateErr", func() error {
+			return s.RemoveQueryTemplate(0, "bob", "spring")
+		}},
+	} {
+		err = failpoint.Enable(packagePath+tc.failpoint, "return(true)")
+		require.NoError(t, err)
+
+		require.Error(t, tc.call(), tc.failpoint)
+
+		err = failpoint.Disable(packagePath + tc.failpoint)
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/pingcap/failpoint"
+	"go.uber.org/zap"
+	"hash/fnv"
+)
+
+// limits of split link variants
+const (
+	minVariants      = 2
+	maxVariants      = 10
+	maxVariantWeight = 10000
+)
+
+// splitBuckets is number of buckets visitors are hashed into, whatever variant weights are
+const splitBuckets = 10000
+
+var ErrInvalidVariants = errors.New("invalid link variants")
+
+// Variant defines one of destinations split link distributes traffic across in proportion to weight
+type Variant struct {
+	URL    string `json:"url"`
+	Weight uint32 `json:"weight"`
+}
+
+// validateVariants checks variants of split link, zero weight pauses variant but some variant must get traffic
+func validateVariants(variants []Variant) error {
+	if len(variants) < minVariants || len(variants) > maxVariants {
+		return fmt.Errorf("%w: link must have from %d to %d variants", ErrInvalidVariants, minVariants, maxVariants)
+	}
+
+	var total uint32
+	for _, v := range variants {
+		if v.URL == "" {
+			return fmt.Errorf("%w: variant URL must not be empty", ErrInvalidVariants)
+		}
+		if v.Weight > maxVariantWeight {
+			return fmt.Errorf("%w: variant weight must be at most %d", ErrInvalidVariants, maxVariantWeight)
+		}
+		total += v.Weight
+	}
+
+	if total == 0 {
+		return fmt.Errorf("%w: some variant must have positive weight", ErrInvalidVariants)
+	}
+
+	return nil
+}
+
+// Split returns link with URL of variant visitor of short link is assigned to and index of the variant,
+// links without variants are returned as is with -1 index. The same visitor always gets the same variant
+// until weights change, different links split visitors independently
+func (l Link) Split(short, visitor string) (Link, int) {
+	if len(l.Variants) == 0 {
+		return l, -1
+	}
+
+	var total uint64
+	for _, v := range l.Variants {
+		total += uint64(v.Weight)
+	}
+
+	// writes to hash never fail
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(short + "/" + visitor))
+	bucket := h.Sum64() % splitBuckets
+
+	// buckets are mapped onto cumulative weight ranges, so weight change moves only visitors
+	// of buckets whose range owner changes, and scaling every weight moves nobody
+	var i int
+	var upper uint64
+	for i = range l.Variants {
+		upper += uint64(l.Variants[i].Weight)
+		if bucket*total < upper*splitBuckets {
+			break
+		}
+	}
+
+	l.URL = l.Variants[i].URL
+
+	return l, i
+}
+
+// SetVariantWeights replaces weights of split link referenced by short string ID on behalf of actor.
+// Weights are listed in variant order, variants, link target and version are kept
+func (s *Storage) SetVariantWeights(reqID uint64, short string, weights []uint32, actor string) (Link, error) {
+	logger := s.logger.With(zap.Uint64("request id", reqID))
+
+	id, err := s.decodeShort(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err = s.update(func(txn *badger.Txn) error {
+		before, err := readLiveLink(txn, id)
+		if err != nil {
+			return err
+		}
+
+		if len(weights) != len(before.Variants) {
+			return fmt.Errorf("%w: link has %d variants, %d weights given", ErrInvalidVariants, len(before.Variants), len(weights))
+		}
+
+		link = before
+		link.Variants = make([]Variant, len(before.Variants))
+		for i, v := range before.Variants {
+			link.Variants[i] = Variant{URL: v.URL, Weight: weights[i]}
+		}
+		if err := validateVariants(link.Variants); err != nil {
+			return err
+		}
+
+		if err := writeLink(txn, id, link); err != nil {
+			return err
+		}
+
+		return appendAudit(txn, linkAudit(reqID, actor, ActionLinkVariants, short, &before, &link), s.now())
+	})
+	failpoint.Inject("setVariantWeightsErr", func() {
+		err = errors.New("mock set variant weights error")
+	})
+	if err != nil {
+		if !isOutcomeErr(err) {
+			logger.Error("changing link variant weights", zap.Error(err))
+		}
+		return Link{}, err
+	}
+
+	s.cache.invalidate(id)
+
+	return link, nil
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"errors"
+	"github.com/pingcap/failpoint"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestValidateVariants(t *testing.T) {
+	require.NoError(t, validateVariants([]Variant{{"https://example.com/a", 1}, {"https://example.com/b", 0}}))
+
+	for _, variants := range [][]Variant{
+		nil,
+		{{"https://example.com/a", 1}},
+		{{"https://example.com/a", 1}, {"", 1}},
+		{{"https://example.com/a", 0}, {"https://example.com/b", 0}},
+		{{"https://example.com/a", maxVariantWeight + 1}, {"https://example.com/b", 1}},
+	} {
+		require.True(t, errors.Is(validateVariants(variants), ErrInvalidVariants), variants)
+	}
+
+	many := make([]Variant, maxVariants+1)
+	for i := range many {
+		many[i] = Variant{"https://example.com/" + strconv.Itoa(i), 1}
+	}
+	require.True(t, errors.Is(validateVariants(many), ErrInvalidVariants))
+}
+
+func TestLink_Split(t *testing.T) {
+	link := Link{URL: "https://example.com/a"}
+	split, variant := link.Split("jnegYbw", "visitor")
+	require.Equal(t, -1, variant)
+	require.Equal(t, link, split)
+
+	link = Link{URL: "https://example.com/a", Variants: []Variant{
+		{"https://example.com/a", 3},
+		{"https://example.com/b", 0},
+		{"https://example.com/c", 1},
+	}}
+
+	counts := make([]int, len(link.Variants))
+	for i := 0; i < 10000; i++ {
+		visitor := strconv.Itoa(i)
+		split, variant := link.Split("jnegYbw", visitor)
+		require.Equal(t, link.Variants[variant].URL, split.URL)
+		counts[variant]++
+
+		// visitors stay in their variant
+		_, again := link.Split("jnegYbw", visitor)
+		require.Equal(t, variant, again)
+	}
+
+	require.InDelta(t, 7500, counts[0], 300)
+	require.Zero(t, counts[1])
+	require.InDelta(t, 2500, counts[2], 300)
+
+	// scaling weights keeps every visitor in place, weight change moves only visitors it has to
+	scaled := Link{Variants: []Variant{{"https://example.com/a", 6}, {"https://example.com/b", 0}, {"https://example.com/c", 2}}}
+	shifted := Link{Variants: []Variant{{"https://example.com/a", 4}, {"https://example.com/b", 0}, {"https://example.com/c", 1}}}
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		visitor := strconv.Itoa(i)
+		_, variant := link.Split("jnegYbw", visitor)
+
+		_, again := scaled.Split("jnegYbw", visitor)
+		require.Equal(t, variant, again)
+
+		_, again = shifted.Split("jnegYbw", visitor)
+		if again != variant {
+			require.Equal(t, 2, variant)
+			require.Equal(t, 0, again)
+			moved++
+		}
+	}
+	require.InDelta(t, 500, moved, 150)
+}
+
+func TestClick_Variants(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	_, _, err = s.CreateLink(0, Link{URL: "https://example.com/a", Variants: []Variant{{"https://example.com/a", 1}}})
+	require.True(t, errors.Is(err, ErrInvalidVariants))
+
+	short, link, err := s.CreateLink(0, Link{Variants: []Variant{{"https://example.com/a", 1}, {"https://example.com/b", 1}}})
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/a", link.URL)
+
+	clicks, err := s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 0}, clicks.Variants)
+
+	// concurrent clicks are counted for their variants exactly
+	expected := make([]uint64, len(link.Variants))
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		visitor := strconv.Itoa(i)
+		_, variant := link.Split(short, visitor)
+		expected[variant]++
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			clicked, err := s.Click(0, short, visitor)
+			require.NoError(t, err)
+			require.Equal(t, link.Variants[variant].URL, clicked.URL)
+		}()
+	}
+	wg.Wait()
+
+	clicks, err = s.LinkClicks(0, short)
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), clicks.Total)
+	require.Equal(t, expected, clicks.Variants)
+
+	// editing target replaces the first variant
+	link, err = s.UpdateURL(0, short, "https://example.com/c", "bob")
+	require.NoError(t, err)
+	require.Equal(t, []Variant{{"https://example.com/c", 1}, {"https://example.com/b", 1}}, link.Variants)
+}
+
+func TestSetVariantWeights(t *testing.T) {
+	dir := setTempDir(t)
+	defer cleanUp(t, dir)
+
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+
+	s, err := New(logger, dir)
+	require.NoError(t, err)
+	defer func() {
+		err = s.Close()
+		require.NoError(t, err)
+	}()
+
+	single, err := s.SaveURL(0, "https://example.com/")
+	require.NoError(t, err)
+
+	_, err = s.SetVariantWeights(0, single, []uint32{1, 1}, "bob")
+	require.True(t, errors.Is(err, ErrInvalidVariants))
+
+	short, err := s.SaveLink(0, Link{Variants: []Variant{{"https://example.com/a", 1}, {"https://example.com/b", 1}}})
+	require.NoError(t, err)
+
+	for _, weights := range [][]uint32{{1}, {1, 1, 1}, {0, 0}} {
+		_, err = s.SetVariantWeights(0, short, weights, "bob")
+		require.True(t, errors.Is(err, ErrInvalidVariants), weights)
+	}
+
+	link, err := s.SetVariantWeights(0, short, []uint32{0, 5}, "bob")
+	require.NoError(t, err)
+	require.Equal(t, []Variant{{"https://example.com/a", 0}, {"https://example.com/b", 5}}, link.Variants)
+	require.Equal(t, uint64(1), link.Version)
+
+	// the change is picked up at once
+	link, err = s.Click(0, short, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/b", link.URL)
+
+	page, err := s.AuditLog(0, AuditQuery{Action: ActionLinkVariants})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, short, page.Entries[0].Object)
+
+	err = failpoint.Enable(packagePath+"setVariantWeightsErr", "return(true)")
+	require.NoError(t, err)
+	defer func() {
+		err = failpoint.Disable(packagePath + "setVariantWeightsErr")
+		require.NoError(t, err)
+	}()
+
+	_, err = s.SetVariantWeights(0, short, []uint32{1, 1}, "bob")
+	require.Equal(t, errors.New("mock set variant weights error"), err)
+}
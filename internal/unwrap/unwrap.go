@@ -0,0 +1,184 @@
+// Package unwrap resolves URLs of known link shorteners to their targets by following redirects
+package unwrap
+
+import (
+	"auto/internal/linkurl"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaults of Unwrapper options
+const (
+	DefaultTimeout = 3 * time.Second
+	DefaultMaxHops = 5
+)
+
+var (
+	ErrLoop        = errors.New("redirect chain contains a loop")
+	ErrTooManyHops = errors.New("redirect chain is too long")
+	ErrBadRedirect = errors.New("shortener redirects to invalid URL")
+	ErrUnreachable = errors.New("shortener can not be reached")
+)
+
+type Option interface {
+	apply(*Unwrapper)
+}
+
+type optionFunc func(u *Unwrapper)
+
+func (f optionFunc) apply(u *Unwrapper) { f(u) }
+
+// WithTimeout limits time spent on resolving the whole redirect chain
+func WithTimeout(timeout time.Duration) Option {
+	return optionFunc(func(u *Unwrapper) {
+		u.timeout = timeout
+	})
+}
+
+// WithMaxHops limits number of redirects followed
+func WithMaxHops(hops int) Option {
+	return optionFunc(func(u *Unwrapper) {
+		u.maxHops = hops
+	})
+}
+
+// WithTransport replaces transport used for requests to shorteners
+func WithTransport(transport http.RoundTripper) Option {
+	return optionFunc(func(u *Unwrapper) {
+		u.client.Transport = transport
+	})
+}
+
+// Unwrapper follows redirects of known shortener hosts. It is safe for concurrent use
+type Unwrapper struct {
+	hosts   map[string]bool
+	client  *http.Client
+	timeout time.Duration
+	maxHops int
+}
+
+// New constructs Unwrapper following redirects of provided shortener hosts
+func New(hosts []string, options ...Option) *Unwrapper {
+	u := &Unwrapper{
+		hosts: make(map[string]bool, len(hosts)),
+		client: &http.Client{
+			// redirects are followed manually to check every hop
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		timeout: DefaultTimeout,
+		maxHops: DefaultMaxHops,
+	}
+
+	for _, h := range hosts {
+		u.hosts[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+
+	for _, o := range options {
+		o.apply(u)
+	}
+
+	return u
+}
+
+// Known reports whether URL belongs to known shortener
+func (u *Unwrapper) Known(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return u.hosts[parsed.Hostname()]
+}
+
+// Unwrap follows redirects while normalized URL belongs to known shortener.
+// It returns every URL of the chain starting with provided one, so the last one is the target
+func (u *Unwrapper) Unwrap(ctx context.Context, rawURL string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	chain := []string{rawURL}
+	seen := map[string]bool{rawURL: true}
+
+	current := rawURL
+	for u.Known(current) {
+		if len(chain) > u.maxHops {
+			return chain, fmt.Errorf("%w: more than %d redirects", ErrTooManyHops, u.maxHops)
+		}
+
+		next, err := u.follow(ctx, current)
+		if err != nil {
+			return chain, err
+		}
+		if next == "" {
+			// shortener does not redirect, so there is nothing to unwrap
+			break
+		}
+
+		chain = append(chain, next)
+		if seen[next] {
+			return chain, fmt.Errorf("%w: %s is visited twice", ErrLoop, next)
+		}
+		seen[next] = true
+
+		current = next
+	}
+
+	return chain, nil
+}
+
+// follow requests URL and returns normalized redirect target, empty if response is not a redirect
+func (u *Unwrapper) follow(ctx context.Context, rawURL string) (string, error) {
+	res, err := u.request(ctx, http.MethodHead, rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	// some shorteners do not support HEAD requests
+	if res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented {
+		if res, err = u.request(ctx, http.MethodGet, rawURL); err != nil {
+			return "", err
+		}
+	}
+
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return "", nil
+	}
+
+	location, err := res.Location()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadRedirect, err)
+	}
+
+	next, err := linkurl.Normalize(location.String())
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadRedirect, err)
+	}
+
+	return next, nil
+}
+
+// request sends request without body and closes response body
+func (u *Unwrapper) request(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	res.Body.Close()
+
+	return res, nil
+}
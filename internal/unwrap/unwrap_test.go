@@ -0,0 +1,151 @@
+package unwrap
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirectTo returns handler redirecting to location returned by target
+func redirectTo(target func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target(), http.StatusMovedPermanently)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	var second *httptest.Server
+
+	first := httptest.NewServer(redirectTo(func() string { return second.URL + "/b" }))
+	defer first.Close()
+
+	second = httptest.NewServer(redirectTo(func() string { return "https://Example.com:443/target" }))
+	defer second.Close()
+
+	u := New([]string{"127.0.0.1"})
+
+	require.True(t, u.Known(first.URL))
+	require.False(t, u.Known("https://example.com/"))
+
+	chain, err := u.Unwrap(context.Background(), first.URL+"/a")
+	require.NoError(t, err)
+	require.Equal(t, []string{first.URL + "/a", second.URL + "/b", "https://example.com/target"}, chain)
+
+	// unknown hosts are not requested at all
+	chain, err = u.Unwrap(context.Background(), "https://example.com/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://example.com/"}, chain)
+}
+
+func TestUnwrap_NotRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	chain, err := New([]string{"127.0.0.1"}).Unwrap(context.Background(), srv.URL+"/missing")
+	require.NoError(t, err)
+	require.Equal(t, []string{srv.URL + "/missing"}, chain)
+}
+
+func TestUnwrap_HeadNotAllowed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, "https://example.com/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	chain, err := New([]string{"127.0.0.1"}).Unwrap(context.Background(), srv.URL)
+	require.NoError(t, err)
+	require.Equal(t, []string{srv.URL, "https://example.com/"}, chain)
+}
+
+func TestUnwrap_Loop(t *testing.T) {
+	var second *httptest.Server
+
+	first := httptest.NewServer(redirectTo(func() string { return second.URL + "/b" }))
+	defer first.Close()
+
+	second = httptest.NewServer(redirectTo(func() string { return first.URL + "/a" }))
+	defer second.Close()
+
+	chain, err := New([]string{"127.0.0.1"}).Unwrap(context.Background(), first.URL+"/a")
+	require.True(t, errors.Is(err, ErrLoop))
+	require.Len(t, chain, 3)
+}
+
+func TestUnwrap_TooManyHops(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL+r.URL.Path+"x", http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	chain, err := New([]string{"127.0.0.1"}, WithMaxHops(3)).Unwrap(context.Background(), srv.URL+"/")
+	require.True(t, errors.Is(err, ErrTooManyHops))
+	require.Len(t, chain, 4)
+}
+
+func TestUnwrap_BadRedirect(t *testing.T) {
+	srv := httptest.NewServer(redirectTo(func() string { return "javascript:alert(1)" }))
+	defer srv.Close()
+
+	_, err := New([]string{"127.0.0.1"}).Unwrap(context.Background(), srv.URL)
+	require.True(t, errors.Is(err, ErrBadRedirect))
+}
+
+func TestUnwrap_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(redirectTo(func() string { return "https://example.com/" }))
+	srv.Close()
+
+	_, err := New([]string{"127.0.0.1"}).Unwrap(context.Background(), srv.URL)
+	require.True(t, errors.Is(err, ErrUnreachable))
+}
+
+func TestUnwrap_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	start := time.Now()
+	_, err := New([]string{"127.0.0.1"}, WithTimeout(50*time.Millisecond)).Unwrap(context.Background(), srv.URL)
+	require.True(t, errors.Is(err, ErrUnreachable))
+	require.True(t, strings.Contains(err.Error(), "deadline exceeded"))
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestWithTransport(t *testing.T) {
+	transport := roundTripper(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusMovedPermanently,
+			Header:     http.Header{"Location": []string{"https://example.com/"}},
+			Body:       http.NoBody,
+			Request:    r,
+		}, nil
+	})
+
+	chain, err := New([]string{"bit.ly"}, WithTransport(transport)).Unwrap(context.Background(), "https://bit.ly/abc")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://bit.ly/abc", "https://example.com/"}, chain)
+}
+
+// roundTripper answers requests without network
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}